@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// An Annotation is a note attached to a request by Annotate.
+type Annotation struct {
+	Key, Value string
+}
+
+// An EventStat is a message recorded during a request by Event.
+type EventStat struct {
+	// Offset is the time of the event since the start of the request.
+	Offset  time.Duration
+	Message string
+}
+
+// recording returns the stats of the request recorded by ctx, or nil if
+// it is not recorded.
+func recording(ctx context.Context) *RequestStats {
+	s, _ := ctx.Value(statsKey).(*RequestStats)
+	return s
+}
+
+// Annotate attaches the note key: value to the request of c, to be shown
+// on its details page, for example the ID of the entity it served or the
+// result of a feature flag. It does nothing if c is not from a recorded
+// request.
+func Annotate(c context.Context, key, value string) {
+	s := recording(c)
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.Annotations = append(s.Annotations, Annotation{key, value})
+	s.lock.Unlock()
+}
+
+// Event records msg at the current time of the request of c, to be shown
+// on the timeline of its details page. It does nothing if c is not from
+// a recorded request.
+func Event(c context.Context, msg string) {
+	s := recording(c)
+	if s == nil {
+		return
+	}
+	e := EventStat{Offset: time.Since(s.Start), Message: msg}
+	s.lock.Lock()
+	s.Events = append(s.Events, e)
+	s.lock.Unlock()
+}
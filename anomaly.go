@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// regression is a path whose recent averages rose above its baseline.
+type regression struct {
+	Path             string
+	Recent, Baseline Aggregate
+
+	// LatencyFactor and CostFactor are the recent averages divided by
+	// the baseline averages.
+	LatencyFactor, CostFactor float64
+}
+
+// factor returns a/b, or 0 if b is 0.
+func factor(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
+
+// loadRollups returns the rollups of [start, end), hourly from History
+// if it has any and from Rollups otherwise, and whether they are hourly.
+func loadRollups(c context.Context, start, end time.Time) ([]*Rollup, bool, error) {
+	minutely := rollupStorage(c)
+	if History != nil {
+		rollups, err := History.Load(c, start.Truncate(time.Hour), end)
+		if err != nil || len(rollups) > 0 || minutely == nil {
+			return rollups, true, err
+		}
+	}
+	if minutely == nil {
+		return nil, false, nil
+	}
+	rollups, err := minutely.Load(c, start, end)
+	return rollups, false, err
+}
+
+// loadBaseline returns the merged rollups of [start, end).
+func loadBaseline(c context.Context, start, end time.Time) (*Rollup, error) {
+	rollups, _, err := loadRollups(c, start, end)
+	if err != nil {
+		return nil, err
+	}
+	total := newRollupAt(start)
+	for _, r := range rollups {
+		total.Merge(r)
+	}
+	return total, nil
+}
+
+// findRegressions compares the per-path averages of the requests of ars
+// started in the last AnomalyWindow with those of the AnomalyBaseline
+// before, and returns the paths whose average latency or cost rose more
+// than AnomalyFactor times, largest rise first.
+func findRegressions(c context.Context, ars allrequestStats) ([]regression, error) {
+	if AnomalyFactor <= 0 || (History == nil && Rollups == nil) {
+		return nil, nil
+	}
+	now := time.Now()
+	since := now.Add(-AnomalyWindow)
+	recent := make(map[string]*Aggregate)
+	for _, r := range ars {
+		if r.Start.Before(since) {
+			continue
+		}
+		aggregate(recent, r.aggregatePath()).add(r.Cost, r.Duration)
+	}
+	if len(recent) == 0 {
+		return nil, nil
+	}
+	baseline, err := loadBaseline(c, since.Add(-AnomalyBaseline), since)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []regression
+	for path, a := range recent {
+		b := baseline.Paths[path]
+		if b == nil || a.Count < AnomalyMinRequests || b.Count < AnomalyMinRequests {
+			continue
+		}
+		r := regression{
+			Path:     path,
+			Recent:   *a,
+			Baseline: *b,
+		}
+		r.LatencyFactor = factor(float64(a.AvgDuration()), float64(b.AvgDuration()))
+		r.CostFactor = factor(a.AvgCost(), b.AvgCost())
+		if r.LatencyRegressed() || r.CostRegressed() {
+			found = append(found, r)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].max() != found[j].max() {
+			return found[i].max() > found[j].max()
+		}
+		return found[i].Path < found[j].Path
+	})
+	return found, nil
+}
+
+// LatencyRegressed reports whether the average latency rose more than
+// AnomalyFactor times.
+func (r regression) LatencyRegressed() bool { return r.LatencyFactor > AnomalyFactor }
+
+// CostRegressed reports whether the average cost rose more than
+// AnomalyFactor times.
+func (r regression) CostRegressed() bool { return r.CostFactor > AnomalyFactor }
+
+func (r regression) max() float64 {
+	if r.LatencyFactor > r.CostFactor {
+		return r.LatencyFactor
+	}
+	return r.CostFactor
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func serveJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// apiRequests serves the recorded requests, without RPC details, and
+// their aggregated statistics as JSON. It accepts the same filters as
+// the dashboard.
+func apiRequests(c context.Context, w http.ResponseWriter, r *http.Request) {
+	ars, err := loadRequests(c, r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	o := newOverview(ars)
+	p := newPager(r, len(ars))
+	start, end := p.bounds()
+	serveJSON(w, struct {
+		// Requests are the requests of the page, most recent first.
+		// RecentReqs of PathStats index into all matching requests,
+		// starting at 1; the page starts at index First.
+		Requests     allrequestStats
+		RPCStats     statsByName
+		PathStats    statsByName
+		ServiceCosts []serviceCost
+		Batching     []batchOpportunity
+
+		Page, Size, Pages, Total, First int
+	}{
+		Requests:     ars[start:end],
+		RPCStats:     o.AllStatsByCount,
+		PathStats:    o.PathStatsByCount,
+		ServiceCosts: o.CostByService,
+		Batching:     o.Batching,
+		Page:         p.Page,
+		Size:         p.Size,
+		Pages:        p.Pages,
+		Total:        p.Total,
+		First:        p.First(),
+	})
+}
+
+// apiDetails serves the full record of the request given by the time
+// parameter as JSON.
+func apiDetails(c context.Context, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.FormValue("time"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad time parameter", http.StatusBadRequest)
+		return
+	}
+	d, err := loadDetails(c, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	serveJSON(w, struct {
+		Request  *RequestStats
+		Header   http.Header
+		RPCStats statsByName
+	}{
+		Request:  d.Record,
+		Header:   d.Header,
+		RPCStats: d.AllStatsByCount,
+	})
+}
+
+// csvText returns s, a path or RPC name, as a CSV cell. Paths are chosen
+// by clients, so those that spreadsheets would run as formulas are
+// prefixed with a quote.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// exportCSV serves the RPC or path statistics table, as selected by the
+// table parameter, as CSV. Each aggregate row is followed by its
+// breakdown rows, which have the second column set.
+func exportCSV(c context.Context, w http.ResponseWriter, r *http.Request) {
+	ars, err := loadRequests(c, r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	o := newOverview(ars)
+
+	var header []string
+	var stats statsByName
+	switch table := r.FormValue("table"); table {
+	case "rpc", "":
+		header = []string{"RPC", "Path", "Count", "Cost", "P50 (ms)", "P95 (ms)", "P99 (ms)"}
+		stats = o.AllStatsByCount
+	case "path":
+		header = []string{"Path", "RPC", "Count", "Cost", "P50 (ms)", "P95 (ms)", "P99 (ms)"}
+		stats = o.PathStatsByCount
+	default:
+		http.Error(w, "unknown table: "+table, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=appstats.csv")
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+	}
+	row := func(name, sub string, s *statByName) {
+		cw.Write([]string{
+			csvText(name),
+			csvText(sub),
+			strconv.Itoa(s.Count),
+			strconv.FormatInt(s.Cost, 10),
+			ms(s.P50),
+			ms(s.P95),
+			ms(s.P99),
+		})
+	}
+	for _, s := range stats {
+		row(s.Name, "", s)
+		for _, sub := range s.SubStats {
+			row(s.Name, sub.Name, sub)
+		}
+	}
+	cw.Flush()
+}
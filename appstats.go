@@ -17,13 +17,25 @@
 package appstats
 
 import (
-	"bytes"
-	"encoding/gob"
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
+	"runtime"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -31,58 +43,376 @@ import (
 	"golang.org/x/net/context"
 
 	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/memcache"
 	"google.golang.org/appengine/user"
 )
 
 var (
 	// RecordFraction is the fraction of requests to record.
 	// Set to a number between 0.0 (none) and 1.0 (all).
+	//
+	// Deprecated: Use the WithSampling option instead.
 	RecordFraction float64 = 1.0
 
 	// ShouldRecord is the function used to determine if recording will occur
 	// for a given request. The default is to use RecordFraction.
+	//
+	// Deprecated: Use the WithSampler option instead.
 	ShouldRecord = DefaultShouldRecord
 
 	// ProtoMaxBytes is the amount of protobuf data to record.
 	// Data after this is truncated.
+	//
+	// Deprecated: Use the WithPayloadLimit option instead.
 	ProtoMaxBytes = 150
 
 	// MemcacheExpiration is the amount of time before recorded data will expire.
 	MemcacheExpiration = 30 * time.Minute
 
+	// MemcacheSlots is the number of requests MemcacheStorage can hold.
+	// Each request is stored in a slot chosen by its start time, replacing
+	// the previous request in that slot.
+	MemcacheSlots = 1000
+
+	// MemcacheSlotWidth is the start time resolution used to choose the
+	// slot of a request. Requests starting within the same interval of
+	// this width share a slot.
+	MemcacheSlotWidth = 100 * time.Microsecond
+
+	// CollapseStatuses lists response status codes, such as
+	// http.StatusNotFound, whose requests are aggregated on the dashboard
+	// under CollapsedPath instead of their own path. This keeps scanner
+	// traffic from cluttering the path statistics. It is empty by default.
+	CollapseStatuses []int
+
+	// CollapsedPath is the path under which requests with a status in
+	// CollapseStatuses are aggregated.
+	CollapsedPath = "<not found>"
+
+	// DashboardRefresh is how often the dashboard reloads itself. It can
+	// be overridden with the refresh query parameter, in seconds. Zero
+	// disables reloading.
+	DashboardRefresh time.Duration
+
+	// SlowestRPCs is the number of slowest individual RPCs listed on the
+	// dashboard.
+	SlowestRPCs = 10
+
+	// SlowestRequests is the number of slowest requests listed by the
+	// slowest requests report, overall and for each path, unless given
+	// by the n query parameter.
+	SlowestRequests = 10
+
+	// Sink receives every recorded request when it finishes. The default,
+	// like nil, discards them.
+	Sink RecordSink = NopSink{}
+
+	// SinkConcurrency is the maximum number of records emitted to Sink
+	// at once. Records finishing while this many are being emitted are
+	// dropped.
+	SinkConcurrency = 10
+
+	// SaveQueue, if set, is the number of records waiting to be stored
+	// by a background goroutine, so that storing them does not delay
+	// the end of requests. Records finishing while the queue is full are
+	// dropped, or wait for room if SaveQueueBlock is set. The depth of
+	// the queue and the records dropped are served by the metrics
+	// endpoint. First generation App Engine, where API calls cannot
+	// outlive their request, always stores records synchronously. It
+	// must not be changed once requests are recorded. The queue is
+	// shared by all handlers, so it is configured here rather than by
+	// an option.
+	SaveQueue = 0
+
+	// SaveQueueBlock makes requests wait for room in a full SaveQueue
+	// instead of dropping their records.
+	SaveQueueBlock = false
+
+	// SaveFlushInterval, if set, is how long the SaveQueue goroutine
+	// waits for more records once it gets one, up to SaveBatchSize, to
+	// store them together with a BatchStorage, such as MemcacheStorage
+	// with a single memcache.SetMulti call. It needs SaveQueue.
+	SaveFlushInterval time.Duration
+
+	// SaveBatchSize is the maximum number of requests whose records are
+	// stored together by SaveFlushInterval.
+	SaveBatchSize = 10
+
+	// CompactStacks stores RPC call stacks as parsed frames, without
+	// call arguments, instead of the raw stack text. This makes records
+	// considerably smaller.
+	CompactStacks = false
+
 	// Namespace is the memcache namespace under which to store appstats data.
 	Namespace = "__appstats__"
+
+	// Store is where recorded requests are saved. The default is
+	// MemcacheStorage.
+	//
+	// Deprecated: Use the WithStorage option instead, with the same
+	// storage given to the Dashboard or DashboardOptions.
+	Store Storage = MemcacheStorage{}
+
+	// OverheadWarnFraction is the fraction of a request's duration that
+	// appstats may spend recording it before a warning is logged.
+	// Set to 0 to disable the warning.
+	OverheadWarnFraction = 0.1
+
+	// ProtoRecords stores records with ProtoCodec instead of GobCodec.
+	//
+	// Deprecated: Use WithCodec(ProtoCodec) instead.
+	ProtoRecords = false
+
+	// PythonRecords makes MemcacheStorage also read the records saved
+	// by the Python appstats module in the Namespace memcache namespace,
+	// so that apps mixing Python and Go see all of their requests in
+	// the dashboard.
+	PythonRecords = false
+
+	// FlameRequests is the number of most recent requests whose RPC
+	// stacks are aggregated in the flame graph. Each needs its full
+	// record loaded.
+	FlameRequests = 100
+
+	// PageSize is the number of requests per page of the requests
+	// history and API, unless given by the size query parameter.
+	PageSize = 100
+
+	// Rollups stores the per-path and per-RPC totals of recorded
+	// requests by time bucket, for comparisons over time beyond the
+	// requests kept by Store. Each recorded request updates the rollup
+	// of its bucket. Set to nil to disable rollups.
+	//
+	// Deprecated: Use the WithRollups option instead.
+	Rollups RollupStorage = MemcacheRollups{}
+
+	// RollupWidth is the width of the time buckets of Rollups. It must
+	// not be changed once rollups are stored.
+	RollupWidth = time.Minute
+
+	// RollupExpiration is how long MemcacheRollups keeps rollups.
+	RollupExpiration = 7 * 24 * time.Hour
+
+	// History durably stores hourly rollups, compacted from Rollups by
+	// the rollup job at /_ah/stats/rollup, for the trends page. It is
+	// nil, disabling both, by default.
+	History HistoryStorage
+
+	// HistoryCompactHours is the number of most recent complete hours
+	// compacted by each run of the rollup job, so that runs may be
+	// missed.
+	HistoryCompactHours = 3
+
+	// HeatmapHours is the number of hours shown by the latency heatmap,
+	// unless given by the hours query parameter.
+	HeatmapHours = 24
+
+	// TrendDays is the number of days shown by the trends page, unless
+	// given by the days query parameter.
+	TrendDays = 7
+
+	// AnomalyFactor is how many times its baseline average the recent
+	// average latency or cost of a path must be for the dashboard to
+	// list it among regressions. Zero disables the regressions panel.
+	AnomalyFactor = 2.0
+
+	// AnomalyWindow is the period of most recent requests whose
+	// averages are compared with the baseline.
+	AnomalyWindow = time.Hour
+
+	// AnomalyBaseline is the period before AnomalyWindow whose rollups,
+	// from History if set and Rollups otherwise, form the baseline.
+	AnomalyBaseline = 24 * time.Hour
+
+	// AnomalyMinRequests is the number of requests a path needs in both
+	// the window and the baseline to be compared.
+	AnomalyMinRequests = 5
+
+	// DiffThreshold is the relative increase in average latency or cost
+	// above which the window comparison highlights a path or RPC.
+	DiffThreshold = 0.2
+
+	// StreamPoll is how often the stream endpoint checks Store for
+	// newly recorded requests.
+	StreamPoll = time.Second
+
+	// StreamTimeout is how long a connection to the stream endpoint is
+	// held open. Browsers reconnect when it ends.
+	StreamTimeout = 50 * time.Second
+
+	// PathNormalizer, if set, returns the route under which a request
+	// is aggregated, such as /user/:id for /user/123. Otherwise requests
+	// are aggregated by path.
+	PathNormalizer func(r *http.Request) string
+
+	// SourceURL, if set, is the URL of stack frame locations in a
+	// source browser, with {file} and {line} replaced by the file,
+	// without SourcePrefix, and line number. For example:
+	//
+	//	https://github.com/user/app/blob/master/{file}#L{line}
+	//	https://host/app/+/refs/heads/master/{file}#{line}
+	//
+	// Otherwise frames link to the file viewer of the dashboard, which
+	// shows the Go files under the working directory of the running app.
+	SourceURL string
+
+	// SourcePrefix is removed from the files of frame locations in
+	// SourceURL, usually the directory of the app when it was built.
+	SourcePrefix string
+
+	// RecordHeaders, if set, are the only request headers recorded.
+	RecordHeaders []string
+
+	// IgnoreHeaders are request headers that are not recorded.
+	IgnoreHeaders []string
+
+	// RedactHeaders are the headers whose values are replaced by
+	// [redacted] in records, for requests and outbound HTTP calls.
+	RedactHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"}
+
+	// RedactHeaderPattern, if set, also redacts the headers whose
+	// names it matches, such as regexp.MustCompile(`(?i)token|secret`).
+	RedactHeaderPattern *regexp.Regexp
+
+	// Pricing, if set, estimates the costs of RPCs instead of App
+	// Engine, which reports the legacy billing of datastore writes. Set
+	// it to CloudDatastorePrices, or a PriceTable of your own, for
+	// current dollar estimates.
+	Pricing CostModel
+
+	// RecordBody is the number of bytes of the bodies of POST, PUT and
+	// PATCH requests recorded in full records, as they are read by the
+	// handler. Zero, the default, disables body recording.
+	//
+	// Deprecated: Use the WithBodyLimit option instead.
+	RecordBody = 0
+
+	// RedactBody maps media types, such as application/json, to
+	// functions returning the recorded bodies of requests of that type
+	// with any sensitive data removed.
+	RedactBody map[string]func(body []byte) []byte
+
+	// ReplayHeaders are the request headers sent when a recorded
+	// request is replayed from its details page. Replays are signed
+	// with the token of WithRecordToken, which the dashboard needs to
+	// replay requests.
+	ReplayHeaders = []string{"Accept", "Accept-Language", "Content-Type", "User-Agent"}
+
+	// ReplayWait is how long to wait for the trace of a replayed request
+	// to be saved.
+	ReplayWait = 5 * time.Second
+
+	// RecordMemory records the changes in runtime.MemStats during each
+	// recorded request. Reading them briefly stops the world twice per
+	// request, so it is disabled by default.
+	RecordMemory = false
+
+	// RecoverPanics makes recorded handlers answer requests that panic
+	// with a 500 error instead of panicking again once the request is
+	// recorded.
+	RecoverPanics = false
+
+	// MaxLogLines is the number of lines logged through Logger that are
+	// recorded with a request. Later lines are only logged.
+	MaxLogLines = 100
+
+	// GoroutineLeakThreshold is the average growth in the number of
+	// goroutines across the requests of a path from which the dashboard
+	// highlights it as likely leaking goroutines.
+	GoroutineLeakThreshold = 1.0
+
+	// RepeatedCalls is the number of sequential calls to the same RPC
+	// with the same request shape from which a request's details page
+	// flags them as a likely N+1 pattern.
+	RepeatedCalls = 3
 )
 
+// serveURL is where the dashboard is served by http.DefaultServeMux.
+const serveURL = "/_ah/stats/"
+
+// Pages of the dashboard, relative to its prefix.
 const (
-	serveURL   = "/_ah/stats/"
-	detailsURL = serveURL + "details"
-	fileURL    = serveURL + "file"
-	staticURL  = serveURL + "static/"
+	detailsURL = "details"
+	fileURL    = "file"
+	staticURL  = "static/"
+
+	apiRequestsURL = "api/requests"
+	apiDetailsURL  = "api/details"
+	csvURL         = "export.csv"
+	metricsURL     = "metrics"
+	harURL         = "har"
+	flameURL       = "flame"
+	diffURL        = "diff"
+	streamURL      = "stream"
+	liveURL        = "live"
+	replayURL      = "replay"
+	compareURL     = "compare"
+	slowestURL     = "slowest"
+	apiSlowestURL  = "api/slowest"
+	usersURL       = "users"
+	rollupURL      = "rollup"
+	trendsURL      = "trends"
+	heatmapURL     = "heatmap"
+	servicesURL    = "services"
 )
 
 const (
 	statsKey  = "appstats stats"
 	headerKey = "appstats header"
+
+	// configKey holds the configuration of Middleware and Dashboard.
+	configKey = "appstats config"
+
+	// savingKey marks the context of the API calls made to save a
+	// record, which are not recorded.
+	savingKey = "appstats saving"
 )
 
 func init() {
-	http.HandleFunc(serveURL, appstatsHandler)
+	http.Handle(serveURL, defaultDashboard)
 }
 
 // DefaultShouldRecord will record a request based on RecordFraction.
 func DefaultShouldRecord(r *http.Request) bool {
-	if RecordFraction >= 1.0 {
+	return sampled(r, RecordFraction, nil)
+}
+
+// sampled reports whether r is in the fraction of requests to record,
+// by the hash of its sample key, if key is set, or at random.
+func sampled(r *http.Request, fraction float64, key func(*http.Request) string) bool {
+	if fraction >= 1.0 {
 		return true
 	}
+	if key != nil {
+		if key := key(r); key != "" {
+			sum := sha1.Sum([]byte(key))
+			return float64(binary.BigEndian.Uint64(sum[:])>>11)/(1<<53) < fraction
+		}
+	}
+	return rand.Float64() < fraction
+}
+
+// TraceKey is a sample key, for WithSampleKey, returning the trace ID of
+// a request, from its traceparent or X-Cloud-Trace-Context header.
+func TraceKey(r *http.Request) string {
+	if traceID, _, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		return traceID
+	}
+	return strings.SplitN(r.Header.Get("X-Cloud-Trace-Context"), "/", 2)[0]
+}
+
+var rpcSampler func(RPCStat) bool
 
-	return rand.Float64() < RecordFraction
+// SetRPCSampler sets the function used to determine if the details (stack
+// trace, request and response) of an RPC are kept. It is called once the
+// RPC has completed. RPCs whose details are dropped are still counted,
+// with their duration and cost, in all statistics. A nil f keeps all
+// details, which is the default.
+func SetRPCSampler(f func(r RPCStat) bool) {
+	rpcSampler = f
 }
 
-func stats(ctx context.Context) *requestStats {
-	return ctx.Value(statsKey).(*requestStats)
+func stats(ctx context.Context) *RequestStats {
+	return ctx.Value(statsKey).(*RequestStats)
 }
 
 // header will return the HTTP headers associated with the given
@@ -95,56 +425,59 @@ func header(ctx context.Context) http.Header {
 func override(ctx context.Context, service, method string, in, out proto.Message) error {
 	stats := stats(ctx)
 
-	if service == "__go__" {
+	if service == "__go__" || ctx.Value(savingKey) != nil {
 		return appengine.APICall(ctx, service, method, in, out)
 	}
 
-	stat := rpcStat{
-		Service:   service,
-		Method:    method,
-		Start:     time.Now(),
-		Offset:    time.Since(stats.Start),
-		StackData: string(debug.Stack()),
-		Pending:   true,
+	begin := time.Now()
+	stat := RPCStat{
+		Service: service,
+		Method:  method,
+		Start:   time.Now(),
+		Offset:  time.Since(stats.Start),
+		Pending: true,
+	}
+	if n := stats.config.stackFrames(); n >= 0 && !stats.rpcsFull() {
+		stat.StackData, stat.Frames = recordStack(debug.Stack(), n)
 	}
 
-	rpcIndex := len(stats.RPCStats)
-	stats.lock.Lock()
-	stats.RPCStats = append(stats.RPCStats, stat)
-	stats.lock.Unlock()
-
+	if isTaskAdd(service, method) {
+		stampTasks(in, stats.ID())
+	}
+	ref := stats.startRPC(stat, time.Since(begin))
 	err := appengine.APICall(ctx, service, method, in, out)
 	stat.Duration = time.Since(stat.Start)
+	begin = time.Now()
 	stat.In = in.String()
 	stat.Out = out.String()
 	stat.Cost = getCost(out)
-	stat.Pending = false
-
-	if len(stat.In) > ProtoMaxBytes {
-		stat.In = stat.In[:ProtoMaxBytes] + "..."
+	if service == "urlfetch" && method == "Fetch" {
+		stat.HTTP = getHTTPCall(in, out)
+	}
+	if service == "memcache" && method == "Get" && err == nil {
+		setMemcacheHits(&stat, in, out)
+	}
+	if service == "datastore_v3" && err == nil {
+		setDatastoreOps(&stat, in, out)
 	}
-	if len(stat.Out) > ProtoMaxBytes {
-		stat.Out = stat.Out[:ProtoMaxBytes] + "..."
+	if ns := rpcNamespace(in); ns != "" && ns != Namespace {
+		stats.lock.Lock()
+		if stats.Namespace == "" {
+			stats.Namespace = ns
+		}
+		stats.lock.Unlock()
 	}
 
-	stats.lock.Lock()
-	stats.RPCStats[rpcIndex] = stat
-	stats.Cost += stat.Cost
-	stats.lock.Unlock()
+	finishCall(stats, ref, stat, time.Since(begin))
 	return err
 }
 
 // newContext creates a new timing-aware context from req.
-func newContext(r *http.Request) context.Context {
+func newContext(r *http.Request, cfg *config) context.Context {
 	ctx := appengine.NewContext(r)
-
-	stats := &requestStats{
-		Method: r.Method,
-		Path:   r.URL.Path,
-		Query:  r.URL.RawQuery,
-		Start:  time.Now(),
-	}
-
+	stats := newStats(r, cfg)
+	stats.Service = appengine.ModuleName(ctx)
+	stats.Version = strings.SplitN(appengine.VersionID(ctx), ".", 2)[0]
 	if u := user.Current(ctx); u != nil {
 		stats.User = u.String()
 		stats.Admin = u.Admin
@@ -157,10 +490,57 @@ func newContext(r *http.Request) context.Context {
 	return ctx
 }
 
+// newStats returns the stats recording r with the configuration cfg.
+func newStats(r *http.Request, cfg *config) *RequestStats {
+	stats := &RequestStats{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Start:  time.Now(),
+
+		CloudTraceContext: r.Header.Get("X-Cloud-Trace-Context"),
+
+		Service: os.Getenv("GAE_SERVICE"),
+		Version: os.Getenv("GAE_VERSION"),
+		Class:   requestClass(r),
+
+		forced: forced(r, cfg.recordToken()),
+
+		config: cfg,
+	}
+	if cfg != nil && cfg.namespace != nil {
+		stats.Namespace = cfg.namespace(r)
+	}
+	if cfg != nil && cfg.name != "" {
+		stats.Route = cfg.name
+	} else if PathNormalizer != nil {
+		stats.Route = PathNormalizer(r)
+	}
+	if RecordMemory {
+		stats.memStart = readMemStats()
+	}
+	stats.GoroutinesStart = runtime.NumGoroutine()
+	if r.ContentLength > 0 {
+		stats.RequestSize = r.ContentLength
+	}
+	recordBody(r, stats)
+	stats.ReplayOf = verifiedID(cfg.recordToken(), r.Header.Get(replayHeader))
+	if isTask(r) {
+		stats.Parent, _ = strconv.ParseInt(r.Header.Get(parentHeader), 10, 64)
+	}
+
+	if traceID, spanID, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		stats.TraceID = traceID
+		stats.SpanID = spanID
+		stats.TraceState = r.Header.Get("tracestate")
+	}
+	return stats
+}
+
 // WithContext enables profiling of functions without a corresponding request,
 // as in the appengine/delay package. method and path may be empty.
 func WithContext(ctx context.Context, method, path string, f func(context.Context)) {
-	stats := &requestStats{
+	stats := &RequestStats{
 		Method: method,
 		Path:   path,
 		Start:  time.Now(),
@@ -181,8 +561,17 @@ func WithContext(ctx context.Context, method, path string, f func(context.Contex
 const bufMaxLen = 1000000
 
 func save(ctx context.Context) {
+	begin := time.Now()
 	stats := stats(ctx)
-	stats.Duration = time.Since(stats.Start)
+	stats.Duration = begin.Sub(stats.Start)
+	stats.mergeRPCs()
+	stats.GoroutinesEnd = runtime.NumGoroutine()
+	if stats.memStart != nil {
+		stats.Memory = memoryDelta(stats.memStart, readMemStats())
+	}
+	if stats.body != nil {
+		stats.body.setBody()
+	}
 
 	for i, stat := range stats.RPCStats {
 		if stat.Pending {
@@ -190,98 +579,184 @@ func save(ctx context.Context) {
 		}
 	}
 
-	var buf_part, buf_full bytes.Buffer
-	full := stats_full{
-		Header: header(ctx),
-		Stats:  stats,
+	redactRecord(stats)
+
+	// Encoding the records and writing them to storage are not counted:
+	// only the time spent recording the request and preparing its
+	// records is overhead.
+	stats.Overhead += time.Since(begin)
+
+	sctx := context.WithValue(ctx, savingKey, true)
+	if !keepRecord(stats) {
+		addStats(sctx, stats)
+		return
 	}
-	if err := gob.NewEncoder(&buf_full).Encode(&full); err != nil {
-		log.Errorf(ctx, "appstats Save error: %v", err)
+
+	h := filterHeader(header(ctx), stats.config.redact())
+	cfg := configOf(ctx)
+	full, rawSize, err := encodeFull(cfg, stats, h)
+	if err != nil {
+		logf(ctx, "ERROR", "appstats Save error: %v", err)
 		return
-	} else if buf_full.Len() > bufMaxLen {
+	} else if len(full) > maxRecordLen(storage(ctx)) {
 		// first try clearing stack traces
-		for i := range full.Stats.RPCStats {
-			full.Stats.RPCStats[i].StackData = ""
+		for i := range stats.RPCStats {
+			stats.RPCStats[i].StackData = ""
+			stats.RPCStats[i].Frames = nil
 		}
-		buf_full.Truncate(0)
-		gob.NewEncoder(&buf_full).Encode(&full)
+		full, rawSize, _ = encodeFull(cfg, stats, h)
 	}
-	part := stats_part(*stats)
-	for i := range part.RPCStats {
-		part.RPCStats[i].StackData = ""
-		part.RPCStats[i].In = ""
-		part.RPCStats[i].Out = ""
+	stats.RecordSize = int64(len(full))
+	stats.RecordRawSize = int64(rawSize)
+	partStats := stats_part(*stats)
+	partStats.RPCStats = append([]RPCStat(nil), stats.RPCStats...)
+	for i := range partStats.RPCStats {
+		partStats.RPCStats[i].StackData = ""
+		partStats.RPCStats[i].Frames = nil
+		partStats.RPCStats[i].In = ""
+		partStats.RPCStats[i].Out = ""
+		partStats.RPCStats[i].HTTP = nil
 	}
-	if err := gob.NewEncoder(&buf_part).Encode(&part); err != nil {
-		log.Errorf(ctx, "appstats Save error: %v", err)
+	partStats.Events = nil
+	partStats.Logs = nil
+	partStats.Body = nil
+	partStats.PanicStack = ""
+	part, err := encodeWith(cfg, (*RequestStats)(&partStats), nil, true)
+	if err != nil {
+		logf(ctx, "ERROR", "appstats Save error: %v", err)
 		return
 	}
 
-	item_part := &memcache.Item{
-		Key:   stats.PartKey(),
-		Value: buf_part.Bytes(),
-		Expiration: MemcacheExpiration,
-	}
-
-	item_full := &memcache.Item{
-		Key:   stats.FullKey(),
-		Value: buf_full.Bytes(),
-		Expiration: MemcacheExpiration,
+	if OverheadWarnFraction > 0 && stats.Overhead > time.Duration(float64(stats.Duration)*OverheadWarnFraction) {
+		logf(ctx, "WARNING", "appstats overhead %v exceeds %v%% of request duration %v",
+			stats.Overhead, OverheadWarnFraction*100, stats.Duration)
 	}
 
-	log.Infof(ctx, "Saved; %s: %s, %s: %s, link: %v",
-		item_part.Key,
-		byteSize(len(item_part.Value)),
-		item_full.Key,
-		byteSize(len(item_full.Value)),
+	logf(ctx, "INFO", "Saved; part: %s, full: %s, link: %v",
+		byteSize(len(part)),
+		byteSize(len(full)),
 		URL(ctx),
 	)
 
-	nc := storeContext(ctx)
-	memcache.SetMulti(nc, []*memcache.Item{item_part, item_full})
+	if !queueSave(sctx, stats.ID(), part, full) {
+		if err := storage(ctx).Save(sctx, stats.ID(), part, full); err != nil {
+			logf(ctx, "ERROR", "appstats Save error: %v", err)
+		}
+	}
+	addStats(sctx, stats)
+}
+
+// encodeFull encodes the full record of stats with header h, like
+// encodeWith, also returning its size before compression.
+func encodeFull(cfg *config, stats *RequestStats, h http.Header) ([]byte, int, error) {
+	b, err := encodeRawRecord(cfg.codec(), stats, h, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := len(b)
+	if cfg.compressRecords() {
+		b, err = compressRecord(b)
+	}
+	return b, size, err
+}
+
+// keepRecord reports whether the record of stats is stored: if it is
+// forced or a replay, which are always stored, or its configuration
+// saves it.
+func keepRecord(stats *RequestStats) bool {
+	return stats.forced || stats.ReplayOf != 0 || stats.config.shouldSave(stats)
 }
 
-// URL returns the appstats URL for the current request.
+// shouldSave reports whether the record of stats is stored, by
+// WithShouldSave, or else WithSlowThreshold and WithErrorsOnly. c may be
+// nil.
+func (c *config) shouldSave(stats *RequestStats) bool {
+	if c == nil {
+		return true
+	}
+	if c.saveFn != nil {
+		return c.saveFn(stats)
+	}
+	slow := c.slow > 0 && stats.Duration >= c.slow
+	if c.errorsOnly {
+		return slow || c.isError(stats)
+	}
+	return stats.Duration >= c.slow
+}
+
+// isError reports whether the request of stats panicked or answered with
+// one of the statuses of WithErrorsOnly.
+func (c *config) isError(stats *RequestStats) bool {
+	if stats.Panic != "" {
+		return true
+	}
+	if len(c.errorStatuses) == 0 {
+		return stats.Status >= 500 && stats.Status <= 599
+	}
+	for _, s := range c.errorStatuses {
+		if stats.Status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// addStats adds stats to the rollups, metrics and sinks, whether its
+// record is stored or not. ctx is the saving context of the request.
+func addStats(ctx context.Context, stats *RequestStats) {
+	if rollups := rollupStorage(ctx); rollups != nil {
+		r := NewRollup(stats.Start)
+		r.Add(stats)
+		if err := rollups.Add(ctx, r); err != nil {
+			logf(ctx, "ERROR", "appstats rollup error: %v", err)
+		}
+	}
+
+	recordMetrics(stats)
+	emit(ctx, stats)
+}
+
+// URL returns the appstats URL for the current request, on the
+// dashboard served at /_ah/stats/.
 func URL(ctx context.Context) string {
 	stats := stats(ctx)
 	u := url.URL{
-		Path:     detailsURL,
-		RawQuery: fmt.Sprintf("time=%v", stats.Start.Nanosecond()),
+		Path:     serveURL + detailsURL,
+		RawQuery: fmt.Sprintf("time=%v", stats.ID()),
 	}
 	return u.String()
 }
 
-func storeContext(ctx context.Context) context.Context {
-	nc, _ := appengine.Namespace(ctx, Namespace)
-	return nc
-}
-
 // handler is an http.Handler that records RPC statistics.
 type handler struct {
-	f func(context.Context, http.ResponseWriter, *http.Request)
+	f      func(context.Context, http.ResponseWriter, *http.Request)
+	config *config
 }
 
-// NewHandler returns a new Handler that will execute f.
-func NewHandler(f func(context.Context, http.ResponseWriter, *http.Request)) http.Handler {
+// NewHandler returns a new Handler that will execute f, configured by
+// opts:
+//
+//	http.Handle("/", appstats.NewHandler(f, appstats.WithSampling(0.1)))
+func NewHandler(f func(context.Context, http.ResponseWriter, *http.Request), opts ...Option) http.Handler {
 	return handler{
-		f: f,
+		f:      f,
+		config: newConfig(opts),
 	}
 }
 
-// NewHandlerFunc returns a new HandlerFunc that will execute f.
-func NewHandlerFunc(f func(context.Context, http.ResponseWriter, *http.Request)) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		h := handler{
-			f: f,
-		}
-		h.ServeHTTP(w, r)
-	}
+// NewHandlerFunc returns a new HandlerFunc that will execute f,
+// configured by opts.
+func NewHandlerFunc(f func(context.Context, http.ResponseWriter, *http.Request), opts ...Option) http.HandlerFunc {
+	return handler{
+		f:      f,
+		config: newConfig(opts),
+	}.ServeHTTP
 }
 
 type responseWriter struct {
 	http.ResponseWriter
 
-	stats *requestStats
+	stats *RequestStats
 }
 
 func (r responseWriter) Write(b []byte) (int, error) {
@@ -291,25 +766,134 @@ func (r responseWriter) Write(b []byte) (int, error) {
 		r.WriteHeader(http.StatusOK)
 	}
 
-	return r.ResponseWriter.Write(b)
+	n, err := r.ResponseWriter.Write(b)
+	r.stats.ResponseSize += int64(n)
+	return n, err
 }
 
 func (r responseWriter) WriteHeader(i int) {
 	r.stats.Status = i
+	r.stats.ContentType = r.Header().Get("Content-Type")
 	r.ResponseWriter.WriteHeader(i)
 }
 
+// Flush flushes the wrapped ResponseWriter if it is an http.Flusher.
+func (r responseWriter) Flush() {
+	f, ok := r.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+	if r.stats.Status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	f.Flush()
+}
+
+// Hijack hijacks the connection of the wrapped ResponseWriter if it is an
+// http.Hijacker.
+func (r responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("appstats: ResponseWriter does not implement http.Hijacker")
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController.
+func (r responseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if ShouldRecord(r) {
-		ctx := newContext(r)
-		rw := responseWriter{
-			ResponseWriter: w,
-			stats:          stats(ctx),
-		}
-		h.f(ctx, rw, r)
-		save(ctx)
+	if cfg := h.config.forRequest(r); shouldRecord(r, cfg) {
+		ctx := context.WithValue(newContext(r, cfg), configKey, cfg)
+		serve(ctx, w, r, h.f)
 	} else {
 		c := appengine.NewContext(r)
 		h.f(c, w, r)
 	}
 }
+
+// recordHeader carries the token of WithRecordToken to force the
+// recording of a request.
+const recordHeader = "X-Appstats-Record"
+
+// forced reports whether r carries token.
+func forced(r *http.Request, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(recordHeader)), []byte(token)) == 1
+}
+
+// signID returns id signed with token, for headers forcing the recording
+// of requests, such as replayHeader. Unsigned headers could be sent by
+// anyone to fill the storage.
+func signID(token string, id int64) string {
+	v := strconv.FormatInt(id, 10)
+	return v + ":" + hex.EncodeToString(idMAC(token, v))
+}
+
+// verifiedID returns the id of the header value v, signed by signID, or
+// 0 if it is not signed with token.
+func verifiedID(token, v string) int64 {
+	i := strings.Index(v, ":")
+	if token == "" || i < 0 {
+		return 0
+	}
+	mac, err := hex.DecodeString(v[i+1:])
+	if err != nil || !hmac.Equal(mac, idMAC(token, v[:i])) {
+		return 0
+	}
+	id, _ := strconv.ParseInt(v[:i], 10, 64)
+	return id
+}
+
+func idMAC(token, id string) []byte {
+	m := hmac.New(sha256.New, []byte(token))
+	m.Write([]byte(id))
+	return m.Sum(nil)
+}
+
+// shouldRecord reports whether r is recorded with the configuration
+// cfg: if it is forced, or its path is not ignored and it is sampled, or
+// it is a replay or a task of a recorded request.
+func shouldRecord(r *http.Request, cfg *config) bool {
+	token := cfg.recordToken()
+	if forced(r, token) {
+		return true
+	}
+	if !cfg.recordPath(r.URL.Path) {
+		return false
+	}
+	return cfg.sample(r) || verifiedID(token, r.Header.Get(replayHeader)) != 0 || isTask(r) && r.Header.Get(parentHeader) != ""
+}
+
+// serve calls f with ctx, a recording context, and saves the record of
+// the request once f returns or panics.
+func serve(ctx context.Context, w http.ResponseWriter, r *http.Request, f func(context.Context, http.ResponseWriter, *http.Request)) {
+	rw := responseWriter{
+		ResponseWriter: w,
+		stats:          stats(ctx),
+	}
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		rw.stats.Panic = fmt.Sprint(p)
+		rw.stats.PanicStack = string(debug.Stack())
+		written := rw.stats.Status != 0
+		if !written {
+			rw.stats.Status = http.StatusInternalServerError
+		}
+		save(ctx)
+		if !RecoverPanics || p == http.ErrAbortHandler {
+			panic(p)
+		}
+		if !written {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}()
+	f(ctx, rw, r)
+	rw.stats.CacheControl = w.Header().Get("Cache-Control")
+	rw.stats.Age = w.Header().Get("Age")
+	save(ctx)
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/runtime"
+)
+
+// RunInBackground runs f outside of the request of c, recorded as a
+// request of ClassBackground with path name, so that its RPCs are
+// recorded too. On first generation App Engine, f runs through
+// runtime.RunInBackground, which only manual scaling instances allow;
+// elsewhere it runs in a goroutine with a context that keeps the values
+// of c but is not canceled with it. If c is from a recorded request,
+// the background work links to it on its details page. Background work
+// is always recorded.
+func RunInBackground(c context.Context, name string, f func(context.Context)) error {
+	parent := recording(c)
+	cfg := configOf(c)
+	run := func(bc context.Context) {
+		stats := &RequestStats{
+			Path:    name,
+			Start:   time.Now(),
+			Service: os.Getenv("GAE_SERVICE"),
+			Version: os.Getenv("GAE_VERSION"),
+			Class:   ClassBackground,
+			config:  cfg,
+		}
+		if parent != nil {
+			stats.Parent = parent.ID()
+			stats.Service, stats.Version = parent.Service, parent.Version
+		}
+		bc = context.WithValue(bc, statsKey, stats)
+		if cfg != nil {
+			bc = context.WithValue(bc, configKey, cfg)
+		}
+		if onAppEngine {
+			bc = appengine.WithAPICallFunc(bc, override)
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				stats.Panic = fmt.Sprint(p)
+				stats.PanicStack = string(debug.Stack())
+				save(bc)
+				panic(p)
+			}
+		}()
+		f(bc)
+		save(bc)
+	}
+
+	if onAppEngine && !secondGen {
+		return runtime.RunInBackground(c, run)
+	}
+	go run(detached{c})
+	return nil
+}
+
+// detached is a context with the values of its parent but without its
+// deadline and cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"io"
+	"mime"
+	"net/http"
+)
+
+// bodyRecorder records up to max bytes of a request body as it is read
+// by the handler.
+type bodyRecorder struct {
+	io.ReadCloser
+	stats       *RequestStats
+	contentType string
+	max         int
+	buf         []byte
+	truncated   bool
+}
+
+// recordBody makes the body of r, if it is one of the methods that
+// carry one, recorded in stats as it is read, up to RecordBody bytes or the limit
+// of WithBodyLimit.
+func recordBody(r *http.Request, stats *RequestStats) {
+	max := stats.config.recordBody()
+	if max <= 0 || r.Body == nil {
+		return
+	}
+	switch r.Method {
+	case "POST", "PUT", "PATCH":
+	default:
+		return
+	}
+	b := &bodyRecorder{
+		ReadCloser:  r.Body,
+		stats:       stats,
+		contentType: r.Header.Get("Content-Type"),
+		max:         max,
+	}
+	stats.body = b
+	r.Body = b
+}
+
+func (b *bodyRecorder) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.stats.lock.Lock()
+	if room := b.max - len(b.buf); room < n {
+		b.buf = append(b.buf, p[:room]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p[:n]...)
+	}
+	b.stats.lock.Unlock()
+	return n, err
+}
+
+// setBody sets the Body of stats to the part of the request body read
+// by the handler, redacted by the RedactBody function of its media
+// type, if any.
+func (b *bodyRecorder) setBody() {
+	b.stats.lock.Lock()
+	defer b.stats.lock.Unlock()
+	body := b.buf
+	if mt, _, err := mime.ParseMediaType(b.contentType); err == nil && RedactBody[mt] != nil {
+		body = RedactBody[mt](body)
+	}
+	b.stats.Body = body
+	b.stats.BodyTruncated = b.truncated
+}
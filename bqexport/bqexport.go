@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package bqexport provides an appstats.RecordSink that streams recorded
+requests into a BigQuery table for long-term analysis.
+
+	client, err := bigquery.NewClient(ctx, projectID)
+	...
+	appstats.Sink = bqexport.New(client, "appstats", "requests")
+
+The table must exist; its schema can be created with Schema.
+*/
+package bqexport
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/mjibson/appstats"
+
+	"golang.org/x/net/context"
+)
+
+var _ appstats.RecordSink = (*Exporter)(nil)
+
+// Row is a recorded request as stored in BigQuery.
+type Row struct {
+	Start      time.Time `bigquery:"start"`
+	Method     string    `bigquery:"method"`
+	Path       string    `bigquery:"path"`
+	Query      string    `bigquery:"query"`
+	Status     int       `bigquery:"status"`
+	User       string    `bigquery:"user"`
+	DurationMs float64   `bigquery:"duration_ms"`
+	Cost       int64     `bigquery:"cost"`
+	RPCs       []RPC     `bigquery:"rpcs"`
+}
+
+// RPC is a single RPC of a Row.
+type RPC struct {
+	Name       string  `bigquery:"name"`
+	OffsetMs   float64 `bigquery:"offset_ms"`
+	DurationMs float64 `bigquery:"duration_ms"`
+	Cost       int64   `bigquery:"cost"`
+}
+
+// Schema returns the schema of the export table.
+func Schema() (bigquery.Schema, error) {
+	return bigquery.InferSchema(Row{})
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// NewRow converts r to a Row.
+func NewRow(r *appstats.RequestStats) *Row {
+	row := &Row{
+		Start:      r.Start,
+		Method:     r.Method,
+		Path:       r.Path,
+		Query:      r.Query,
+		Status:     r.Status,
+		User:       r.User,
+		DurationMs: ms(r.Duration),
+		Cost:       r.Cost,
+		RPCs:       make([]RPC, len(r.RPCStats)),
+	}
+	for i, s := range r.RPCStats {
+		row.RPCs[i] = RPC{
+			Name:       s.Name(),
+			OffsetMs:   ms(s.Offset),
+			DurationMs: ms(s.Duration),
+			Cost:       s.Cost,
+		}
+	}
+	return row
+}
+
+// Exporter is an appstats.RecordSink that inserts each record into a
+// BigQuery table.
+type Exporter struct {
+	Inserter *bigquery.Inserter
+
+	// Timeout bounds each insert, if positive.
+	Timeout time.Duration
+
+	// OnError is called with insert errors. The default logs them.
+	OnError func(error)
+}
+
+// New returns an Exporter inserting into the given table.
+func New(client *bigquery.Client, dataset, table string) *Exporter {
+	return &Exporter{
+		Inserter: client.Dataset(dataset).Table(table).Inserter(),
+		Timeout:  30 * time.Second,
+	}
+}
+
+// Emit implements appstats.RecordSink.
+func (e *Exporter) Emit(r *appstats.RequestStats) {
+	ctx := context.Background()
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	err := e.Inserter.Put(ctx, &bigquery.StructSaver{
+		Struct:   NewRow(r),
+		InsertID: fmt.Sprint(r.ID()),
+	})
+	if err != nil {
+		if e.OnError != nil {
+			e.OnError(err)
+		} else {
+			log.Printf("bqexport: %v", err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package chiroute records the requests of a chi router with appstats,
+aggregated by the patterns of the routes they match, so that /user/123
+and /user/456 are both counted under /user/{id}.
+
+	r := chi.NewRouter()
+	r.Use(chiroute.Middleware())
+	r.Get("/user/{id}", userHandler)
+*/
+package chiroute
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/mjibson/appstats"
+)
+
+// Middleware returns a router middleware recording requests with
+// appstats.Middleware configured by opts, aggregated under the pattern
+// of the route they match. Requests matching no route are aggregated by
+// path.
+func Middleware(opts ...appstats.Option) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return appstats.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			// chi matches the route while serving next, through any
+			// sub-routers, so its pattern is only known afterwards.
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				if pattern := rctx.RoutePattern(); pattern != "" {
+					appstats.FromContext(r.Context()).SetRoute(pattern)
+				}
+			}
+		}), opts...)
+	}
+}
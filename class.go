@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import "net/http"
+
+// Request classes, for RequestStats.Class, WithClassFractions and the class
+// filter of the dashboard.
+const (
+	// ClassCron is a request of the App Engine cron service.
+	ClassCron = "cron"
+	// ClassWarmup is an App Engine warmup request, starting an
+	// instance.
+	ClassWarmup = "warmup"
+	// ClassTask is a task of an App Engine push queue.
+	ClassTask = "task"
+	// ClassBackground is work recorded by RunInBackground.
+	ClassBackground = "background"
+)
+
+// requestClass returns the class of r. App Engine removes the headers
+// identifying cron and task requests from outside requests.
+func requestClass(r *http.Request) string {
+	switch {
+	case r.Header.Get("X-Appengine-Cron") == "true":
+		return ClassCron
+	case r.URL.Path == "/_ah/warmup":
+		return ClassWarmup
+	case r.Header.Get("X-Appengine-Queuename") != "":
+		return ClassTask
+	}
+	return ""
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package cloudtrace provides an appstats.RecordSink that sends recorded
+requests to Google Cloud Trace. Each request becomes a span of the trace
+given by its X-Cloud-Trace-Context header, or else its W3C traceparent
+header, or of a new trace if it has neither, with a child span for every
+RPC.
+
+The client must be authorized for the Cloud Trace API, for example:
+
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/trace.append")
+	...
+	appstats.Sink = cloudtrace.New("my-project", client)
+*/
+package cloudtrace
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjibson/appstats"
+)
+
+const endpoint = "https://cloudtrace.googleapis.com/v1/projects/%s/traces"
+
+// DefaultTimeout bounds the writes of traces made with a Client that has
+// no timeout of its own.
+const DefaultTimeout = 10 * time.Second
+
+// errNoClient is returned by the writes of an Exporter without a Client.
+var errNoClient = errors.New("cloudtrace: Exporter.Client is nil, it must be authorized for the Cloud Trace API")
+
+var _ appstats.RecordSink = (*Exporter)(nil)
+
+// Exporter is an appstats.RecordSink that writes traces with the Cloud
+// Trace API.
+type Exporter struct {
+	ProjectID string
+
+	// Client writes the traces. It must be authorized for the Cloud
+	// Trace API: no traces are written without it.
+	Client *http.Client
+
+	// OnError is called with write errors. The default logs them.
+	OnError func(error)
+}
+
+// New returns an Exporter writing traces of projectID with client. It
+// panics if client is nil.
+func New(projectID string, client *http.Client) *Exporter {
+	if client == nil {
+		panic("cloudtrace: New needs a client authorized for the Cloud Trace API")
+	}
+	return &Exporter{
+		ProjectID: projectID,
+		Client:    client,
+	}
+}
+
+type traces struct {
+	Traces []trace `json:"traces"`
+}
+
+type trace struct {
+	ProjectID string `json:"projectId"`
+	TraceID   string `json:"traceId"`
+	Spans     []span `json:"spans"`
+}
+
+type span struct {
+	SpanID       string            `json:"spanId"`
+	Kind         string            `json:"kind"`
+	Name         string            `json:"name"`
+	StartTime    string            `json:"startTime"`
+	EndTime      string            `json:"endTime"`
+	ParentSpanID string            `json:"parentSpanId,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+func randomID(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func spanID() string {
+	// Span IDs must be nonzero.
+	return strconv.FormatUint(binary.BigEndian.Uint64(randomID(8))|1, 10)
+}
+
+// parseHeader parses an X-Cloud-Trace-Context header of the form
+// TRACE_ID/SPAN_ID;o=OPTIONS.
+func parseHeader(h string) (traceID, spanID string) {
+	if i := strings.Index(h, ";"); i >= 0 {
+		h = h[:i]
+	}
+	traceID = h
+	if i := strings.Index(h, "/"); i >= 0 {
+		traceID, spanID = h[:i], h[i+1:]
+	}
+	return traceID, spanID
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// trace converts r to a Cloud Trace trace.
+func (e *Exporter) trace(r *appstats.RequestStats) trace {
+	traceID, parentID := parseHeader(r.CloudTraceContext)
+	if len(traceID) != 32 && r.TraceID != "" {
+		// Cloud Trace span IDs are decimal, traceparent ones hex.
+		traceID, parentID = r.TraceID, ""
+		if id, err := strconv.ParseUint(r.SpanID, 16, 64); err == nil {
+			parentID = strconv.FormatUint(id, 10)
+		}
+	}
+	if len(traceID) != 32 {
+		traceID = hex.EncodeToString(randomID(16))
+		parentID = ""
+	}
+	root := span{
+		SpanID:       spanID(),
+		Kind:         "RPC_SERVER",
+		Name:         r.Path,
+		StartTime:    timestamp(r.Start),
+		EndTime:      timestamp(r.Start.Add(r.Duration)),
+		ParentSpanID: parentID,
+		Labels: map[string]string{
+			"/http/method":      r.Method,
+			"/http/status_code": strconv.Itoa(r.Status),
+			"appstats/cost":     strconv.FormatInt(r.Cost, 10),
+		},
+	}
+	t := trace{
+		ProjectID: e.ProjectID,
+		TraceID:   traceID,
+		Spans:     []span{root},
+	}
+	for _, s := range r.RPCStats {
+		t.Spans = append(t.Spans, span{
+			SpanID:       spanID(),
+			Kind:         "RPC_CLIENT",
+			Name:         s.Name(),
+			StartTime:    timestamp(s.Start),
+			EndTime:      timestamp(s.Start.Add(s.Duration + s.ExtraDuration)),
+			ParentSpanID: root.SpanID,
+			Labels: map[string]string{
+				"appstats/cost": strconv.FormatInt(s.Cost, 10),
+			},
+		})
+	}
+	return t
+}
+
+// Emit implements appstats.RecordSink.
+func (e *Exporter) Emit(r *appstats.RequestStats) {
+	if err := e.write(r); err != nil {
+		if e.OnError != nil {
+			e.OnError(err)
+		} else {
+			log.Printf("cloudtrace: %v", err)
+		}
+	}
+}
+
+func (e *Exporter) write(r *appstats.RequestStats) error {
+	if e.Client == nil {
+		return errNoClient
+	}
+	b, err := json.Marshal(traces{Traces: []trace{e.trace(r)}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PATCH", fmt.Sprintf(endpoint, e.ProjectID), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := e.Client
+	if client.Timeout == 0 {
+		c := *client
+		c.Timeout = DefaultTimeout
+		client = &c
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return nil
+}
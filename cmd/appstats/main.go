@@ -0,0 +1,359 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Command appstats prints reports of the requests recorded by appstats,
+for triage from a terminal and performance checks in CI.
+
+Usage:
+
+	appstats [flags] command [args]
+
+The commands are:
+
+	paths       requests, errors and latency by path
+	rpcs        calls, cost and latency by RPC
+	slow        the slowest requests
+	details id  the RPCs of a request
+	check       fail if a path exceeds the -max-p95 or -max-errors limits
+
+Stats are fetched from the JSON API of the dashboard given by -url, such
+as https://app.appspot.com/_ah/stats/. The dashboard requires an admin
+login; pass its cookie with -cookie, or any other credentials with
+-header. Alternatively, -f reads a dump of /_ah/stats/api/requests
+saved earlier. The -filter flag takes the filter query parameters of the
+dashboard, such as "path=/api&min=100ms".
+
+For example, to fail a CI job if any path has a p95 latency above
+500ms:
+
+	appstats -url https://staging.example.com/_ah/stats/ -max-p95 500ms check
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	flagURL       = flag.String("url", "", "dashboard URL")
+	flagFile      = flag.String("f", "", "read requests from an api/requests dump instead of -url")
+	flagFilter    = flag.String("filter", "", "dashboard filter query parameters")
+	flagCookie    = flag.String("cookie", "", "cookie sent with requests")
+	flagHeader    = flag.String("header", "", "header sent with requests, as \"Name: value\"")
+	flagN         = flag.Int("n", 10, "number of slowest requests")
+	flagMaxP95    = flag.Duration("max-p95", 0, "check: maximum p95 latency of a path")
+	flagMaxErrors = flag.Float64("max-errors", 0, "check: maximum error percentage of a path")
+	flagMin       = flag.Int("min", 1, "check: minimum number of requests of a path to check it")
+)
+
+// The types below mirror the JSON API of the dashboard.
+
+type request struct {
+	User        string
+	Method      string
+	Path, Query string
+	Status      int
+	Cost        int64
+	Start       time.Time
+	Duration    time.Duration
+	RPCStats    []rpc
+}
+
+func (r *request) ID() int64 {
+	return r.Start.UnixNano()
+}
+
+func (r *request) line() string {
+	s := r.Method + " " + r.Path
+	if r.Query != "" {
+		s += "?" + r.Query
+	}
+	return s
+}
+
+type rpc struct {
+	Service, Method string
+	Offset          time.Duration
+	Duration        time.Duration
+	Cost            int64
+}
+
+type stat struct {
+	Name          string
+	Count         int
+	Cost          int64
+	Requests      int
+	Errors        int
+	P50, P95, P99 time.Duration
+	SubStats      []*stat
+}
+
+func (s *stat) errorPercent() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return 100 * float64(s.Errors) / float64(s.Requests)
+}
+
+type requests struct {
+	Requests  []*request
+	RPCStats  []*stat
+	PathStats []*stat
+	Total     int
+}
+
+type details struct {
+	Request *request
+	Header  http.Header
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: appstats [flags] paths|rpcs|slow|details id|check\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd := flag.Arg(0); cmd {
+	case "paths":
+		err = paths(os.Stdout)
+	case "rpcs":
+		err = rpcs(os.Stdout)
+	case "slow":
+		err = slow(os.Stdout)
+	case "details":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = showDetails(os.Stdout, flag.Arg(1))
+	case "check":
+		var failed bool
+		if failed, err = check(os.Stdout); err == nil && failed {
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "appstats: unknown command %q\n", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "appstats: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// fetch decodes the JSON served by the dashboard at page, with the
+// filter and extra query parameters, into v.
+func fetch(page string, extra url.Values, v interface{}) error {
+	if *flagURL == "" {
+		return fmt.Errorf("-url or -f is required")
+	}
+	base, err := url.Parse(*flagURL)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	u, err := base.Parse(page)
+	if err != nil {
+		return err
+	}
+	q, err := url.ParseQuery(*flagFilter)
+	if err != nil {
+		return fmt.Errorf("bad -filter: %v", err)
+	}
+	for k, vs := range extra {
+		q[k] = vs
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if *flagCookie != "" {
+		req.Header.Set("Cookie", *flagCookie)
+	}
+	if *flagHeader != "" {
+		i := strings.Index(*flagHeader, ":")
+		if i < 0 {
+			return fmt.Errorf("bad -header: %q", *flagHeader)
+		}
+		req.Header.Set(strings.TrimSpace((*flagHeader)[:i]), strings.TrimSpace((*flagHeader)[i+1:]))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s: %s", u, resp.Status, strings.TrimSpace(string(b)))
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		return fmt.Errorf("%s: got %s instead of JSON; is the login cookie set?", u, ct)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// loadRequests returns the requests and their statistics, from the
+// -f dump or the API. Only the aggregates of all pages are needed, so
+// a single request is fetched.
+func loadRequests() (*requests, error) {
+	var rs requests
+	if *flagFile != "" {
+		b, err := ioutil.ReadFile(*flagFile)
+		if err != nil {
+			return nil, err
+		}
+		return &rs, json.Unmarshal(b, &rs)
+	}
+	err := fetch("api/requests", url.Values{"size": {"1"}}, &rs)
+	return &rs, err
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
+
+func paths(w io.Writer) error {
+	rs, err := loadRequests()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Requests\tErrors\tRPCs\tCost\tp50\tp95\tp99\t\tPath")
+	for _, s := range rs.PathStats {
+		fmt.Fprintf(tw, "%d\t%.1f%%\t%d\t%d\t%s\t%s\t%s\t\t%s\n",
+			s.Requests, s.errorPercent(), s.Count, s.Cost, ms(s.P50), ms(s.P95), ms(s.P99), s.Name)
+	}
+	return tw.Flush()
+}
+
+func rpcs(w io.Writer) error {
+	rs, err := loadRequests()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Calls\tCost\tp50\tp95\tp99\t\tRPC")
+	for _, s := range rs.RPCStats {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t\t%s\n",
+			s.Count, s.Cost, ms(s.P50), ms(s.P95), ms(s.P99), s.Name)
+		for _, sub := range s.SubStats {
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t\t  %s\n",
+				sub.Count, sub.Cost, ms(sub.P50), ms(sub.P95), ms(sub.P99), sub.Name)
+		}
+	}
+	return tw.Flush()
+}
+
+func slow(w io.Writer) error {
+	var slowest []*request
+	if *flagFile != "" {
+		rs, err := loadRequests()
+		if err != nil {
+			return err
+		}
+		slowest = rs.Requests
+		sort.SliceStable(slowest, func(i, j int) bool {
+			return slowest[i].Duration > slowest[j].Duration
+		})
+		if len(slowest) > *flagN {
+			slowest = slowest[:*flagN]
+		}
+	} else {
+		var s struct{ Requests []*request }
+		if err := fetch("api/slowest", url.Values{"n": {fmt.Sprint(*flagN)}}, &s); err != nil {
+			return err
+		}
+		slowest = s.Requests
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Duration\tStatus\tRPCs\tCost\tID\t\tRequest")
+	for _, r := range slowest {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t\t%s\n",
+			ms(r.Duration), r.Status, len(r.RPCStats), r.Cost, r.ID(), r.line())
+	}
+	return tw.Flush()
+}
+
+func showDetails(w io.Writer, id string) error {
+	var d details
+	if err := fetch("api/details", url.Values{"time": {id}}, &d); err != nil {
+		return err
+	}
+	r := d.Request
+	fmt.Fprintf(w, "%s %s %d %s cost=%d", r.Start.Format(time.RFC3339), r.line(), r.Status, ms(r.Duration), r.Cost)
+	if r.User != "" {
+		fmt.Fprintf(w, " user=%s", r.User)
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Offset\tDuration\tCost\t\tRPC")
+	for _, s := range r.RPCStats {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t\t%s.%s\n", ms(s.Offset), ms(s.Duration), s.Cost, s.Service, s.Method)
+	}
+	return tw.Flush()
+}
+
+// check reports the paths with at least -min requests exceeding the
+// -max-p95 or -max-errors limits, and whether there were any.
+func check(w io.Writer) (failed bool, err error) {
+	if *flagMaxP95 == 0 && *flagMaxErrors == 0 {
+		return false, fmt.Errorf("check needs -max-p95 or -max-errors")
+	}
+	rs, err := loadRequests()
+	if err != nil {
+		return false, err
+	}
+	for _, s := range rs.PathStats {
+		if s.Requests < *flagMin {
+			continue
+		}
+		if *flagMaxP95 != 0 && s.P95 > *flagMaxP95 {
+			fmt.Fprintf(w, "FAIL %s: p95 %s exceeds %s\n", s.Name, ms(s.P95), ms(*flagMaxP95))
+			failed = true
+		}
+		if *flagMaxErrors != 0 && s.errorPercent() > *flagMaxErrors {
+			fmt.Fprintf(w, "FAIL %s: %.1f%% errors exceeds %.1f%%\n", s.Name, s.errorPercent(), *flagMaxErrors)
+			failed = true
+		}
+	}
+	if !failed {
+		fmt.Fprintf(w, "ok: %d paths, %d requests\n", len(rs.PathStats), rs.Total)
+	}
+	return failed, nil
+}
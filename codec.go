@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// A Codec encodes the records of requests for storage. A stored record
+// is the marker of its codec, given to RegisterCodec, followed by the
+// encoded record, so that records are decoded by the codec that encoded
+// them whatever the codec in use. Gob records have no marker.
+type Codec interface {
+	// Encode encodes the record of r with header h. part reports
+	// whether it is a part record, without RPC stacks and payloads
+	// and with a nil header.
+	Encode(r *RequestStats, h http.Header, part bool) ([]byte, error)
+
+	// Decode decodes a record encoded by Encode, without its marker.
+	Decode(b []byte, part bool) (*RequestStats, http.Header, error)
+}
+
+var (
+	// GobCodec encodes records with encoding/gob. It is the default.
+	GobCodec Codec = gobCodec{}
+
+	// ProtoCodec encodes records as Record messages of
+	// internal/recordpb/record.proto. Records are smaller and faster to
+	// encode.
+	ProtoCodec Codec = protoCodec{}
+)
+
+var codecs = struct {
+	sync.RWMutex
+	m map[byte]Codec
+}{m: map[byte]Codec{
+	protoMarker:  ProtoCodec,
+	pythonMarker: pythonCodec{},
+}}
+
+// RegisterCodec registers c to encode and decode the records starting
+// with marker, which must be between 3 and 15: a gob record never starts
+// with those. Codecs are told apart by their types, so only one codec of
+// a type can be registered. It is typically called in the init function
+// of the package providing c.
+func RegisterCodec(marker byte, c Codec) {
+	if marker <= gzipMarker || marker > 15 {
+		panic(fmt.Sprintf("appstats: invalid codec marker %d", marker))
+	}
+	codecs.Lock()
+	defer codecs.Unlock()
+	if _, dup := codecs.m[marker]; dup {
+		panic(fmt.Sprintf("appstats: codec marker %d registered twice", marker))
+	}
+	for _, rc := range codecs.m {
+		if reflect.TypeOf(rc) == reflect.TypeOf(c) {
+			panic(fmt.Sprintf("appstats: codec %T registered twice", c))
+		}
+	}
+	codecs.m[marker] = c
+}
+
+// codecMarker returns the marker of the codec of the type of c, and
+// false if it has none. Types are compared rather than the codecs, which
+// may not be comparable.
+func codecMarker(c Codec) (byte, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	for m, rc := range codecs.m {
+		if reflect.TypeOf(rc) == reflect.TypeOf(c) {
+			return m, true
+		}
+	}
+	return 0, false
+}
+
+// markedCodec returns the codec of the marker b starts with, or nil if
+// b is a gob record.
+func markedCodec(b []byte) Codec {
+	if len(b) == 0 {
+		return nil
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	return codecs.m[b[0]]
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(r *RequestStats, h http.Header, part bool) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if part {
+		p := stats_part(*r)
+		err = gob.NewEncoder(&buf).Encode(&p)
+	} else {
+		err = gob.NewEncoder(&buf).Encode(&stats_full{Header: h, Stats: r})
+	}
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Decode(b []byte, part bool) (*RequestStats, http.Header, error) {
+	if part {
+		p := stats_part{}
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&p); err != nil {
+			return nil, nil, err
+		}
+		return (*RequestStats)(&p), nil, nil
+	}
+	full := stats_full{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&full); err != nil {
+		return nil, nil, err
+	}
+	return full.Stats, full.Header, nil
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Encode(r *RequestStats, h http.Header, part bool) ([]byte, error) {
+	return marshalRecord(r, h)
+}
+
+func (protoCodec) Decode(b []byte, part bool) (*RequestStats, http.Header, error) {
+	return unmarshalRecord(b)
+}
+
+// pythonCodec decodes the records of the Python appstats module, read
+// with PythonRecords.
+type pythonCodec struct{}
+
+func (pythonCodec) Encode(r *RequestStats, h http.Header, part bool) ([]byte, error) {
+	return nil, errors.New("appstats: cannot encode Python records")
+}
+
+func (pythonCodec) Decode(b []byte, part bool) (*RequestStats, http.Header, error) {
+	return unmarshalPython(b)
+}
@@ -2,6 +2,8 @@ package appstats
 
 import (
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
 )
@@ -58,3 +60,49 @@ func extractCost(v reflect.Value) int64 {
 
 	return cost * cost_Write
 }
+
+// dollar is a dollar in micropennies, the unit of costs.
+const dollar = 1e8
+
+// A CostModel estimates the cost of RPCs, in micropennies (millionths
+// of a cent), from the datastore operations and calls they make.
+type CostModel interface {
+	RPCCost(stat *RPCStat) int64
+}
+
+// A PriceTable is a CostModel with prices in dollars.
+type PriceTable struct {
+	// Read, Write and IndexWrite price each datastore entity read and
+	// written, including deletes, and each index entry written.
+	Read, Write, IndexWrite float64
+
+	// Calls prices each call to the RPCs it names, such as
+	// urlfetch.Fetch or http.GET.
+	Calls map[string]float64
+}
+
+// CloudDatastorePrices is a PriceTable with the list prices of Cloud
+// Firestore in Datastore mode, whose writes include their index entries.
+var CloudDatastorePrices = PriceTable{
+	Read:  0.06 / 100000,
+	Write: 0.18 / 100000,
+}
+
+// RPCCost implements CostModel.
+func (t PriceTable) RPCCost(stat *RPCStat) int64 {
+	d := float64(stat.Reads)*t.Read +
+		float64(stat.Writes)*t.Write +
+		float64(stat.IndexWrites)*t.IndexWrite +
+		t.Calls[stat.Name()]
+	return int64(d*dollar + 0.5)
+}
+
+// dollars formats cost, in micropennies, in dollars.
+func dollars(cost int64) string {
+	s := strconv.FormatFloat(float64(cost)/dollar, 'f', 8, 64)
+	s = strings.TrimRight(s, "0")
+	if i := strings.IndexByte(s, '.'); len(s)-i < 3 {
+		s += strings.Repeat("0", 3-(len(s)-i))
+	}
+	return "$" + s
+}
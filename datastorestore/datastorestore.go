@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package datastorestore provides an appstats.Storage that keeps recorded
+requests as Cloud Datastore entities. Unlike memcache, records survive
+eviction and are not overwritten by later requests, so they can be kept
+and inspected over days.
+
+	appstats.Store = &datastorestore.Store{Full: true}
+
+Records are never deleted by the store itself; call Purge periodically,
+for example from a cron handler, to remove old ones.
+*/
+package datastorestore
+
+import (
+	"time"
+
+	"github.com/mjibson/appstats"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	// DefaultKind is the entity kind used when Store.Kind is empty.
+	DefaultKind = "AppstatsRecord"
+
+	// DefaultMaxRecords is the number of records listed when
+	// Store.MaxRecords is zero.
+	DefaultMaxRecords = 1000
+)
+
+var _ appstats.Storage = (*Store)(nil)
+
+// Store is an appstats.Storage backed by Cloud Datastore. Part records
+// are stored as entities of Kind, and full records as entities of Kind
+// with a "Full" suffix, both keyed by request ID, in the
+// appstats.Namespace namespace.
+type Store struct {
+	// Kind is the entity kind of part records.
+	Kind string
+
+	// Full enables storing full records, with RPC stacks and payloads.
+	// Without them, the details page of a request is not available.
+	Full bool
+
+	// MaxRecords is the maximum number of most recent records returned
+	// by List.
+	MaxRecords int
+}
+
+type record struct {
+	Start time.Time
+	Value []byte `datastore:",noindex"`
+}
+
+func (s *Store) kind() string {
+	if s.Kind == "" {
+		return DefaultKind
+	}
+	return s.Kind
+}
+
+func (s *Store) fullKind() string {
+	return s.kind() + "Full"
+}
+
+func (s *Store) maxRecords() int {
+	if s.MaxRecords == 0 {
+		return DefaultMaxRecords
+	}
+	return s.MaxRecords
+}
+
+func namespace(c context.Context) (context.Context, error) {
+	return appengine.Namespace(c, appstats.Namespace)
+}
+
+// Save implements appstats.Storage.
+func (s *Store) Save(c context.Context, id int64, part, full []byte) error {
+	nc, err := namespace(c)
+	if err != nil {
+		return err
+	}
+	start := time.Unix(0, id)
+	keys := []*datastore.Key{datastore.NewKey(nc, s.kind(), "", id, nil)}
+	records := []*record{{Start: start, Value: part}}
+	if s.Full {
+		keys = append(keys, datastore.NewKey(nc, s.fullKind(), "", id, nil))
+		records = append(records, &record{Start: start, Value: full})
+	}
+	_, err = datastore.PutMulti(nc, keys, records)
+	return err
+}
+
+func (s *Store) load(c context.Context, kind string, id int64) ([]byte, error) {
+	nc, err := namespace(c)
+	if err != nil {
+		return nil, err
+	}
+	var r record
+	if err := datastore.Get(nc, datastore.NewKey(nc, kind, "", id, nil), &r); err != nil {
+		return nil, err
+	}
+	return r.Value, nil
+}
+
+// LoadPart implements appstats.Storage.
+func (s *Store) LoadPart(c context.Context, id int64) ([]byte, error) {
+	return s.load(c, s.kind(), id)
+}
+
+// LoadFull implements appstats.Storage. If s.Full is not set, it
+// returns datastore.ErrNoSuchEntity.
+func (s *Store) LoadFull(c context.Context, id int64) ([]byte, error) {
+	return s.load(c, s.fullKind(), id)
+}
+
+// List implements appstats.Storage.
+func (s *Store) List(c context.Context) ([][]byte, error) {
+	nc, err := namespace(c)
+	if err != nil {
+		return nil, err
+	}
+	var records []*record
+	q := datastore.NewQuery(s.kind()).Order("-Start").Limit(s.maxRecords())
+	if _, err := q.GetAll(nc, &records); err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(records))
+	for i, r := range records {
+		values[i] = r.Value
+	}
+	return values, nil
+}
+
+// Ping implements appstats.Storage by running a keys-only query.
+func (s *Store) Ping(c context.Context) error {
+	nc, err := namespace(c)
+	if err != nil {
+		return err
+	}
+	_, err = datastore.NewQuery(s.kind()).KeysOnly().Limit(1).GetAll(nc, nil)
+	return err
+}
+
+// Purge deletes all records of requests started before t.
+func (s *Store) Purge(c context.Context, t time.Time) error {
+	nc, err := namespace(c)
+	if err != nil {
+		return err
+	}
+	for _, kind := range []string{s.kind(), s.fullKind()} {
+		keys, err := datastore.NewQuery(kind).Filter("Start <", t).KeysOnly().GetAll(nc, nil)
+		if err != nil {
+			return err
+		}
+		// Datastore limits the number of entities per call.
+		for len(keys) > 0 {
+			n := len(keys)
+			if n > 500 {
+				n = 500
+			}
+			if err := datastore.DeleteMulti(nc, keys[:n]); err != nil {
+				return err
+			}
+			keys = keys[n:]
+		}
+	}
+	return nil
+}
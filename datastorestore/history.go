@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package datastorestore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	"github.com/mjibson/appstats"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// DefaultHistoryKind is the entity kind used when History.Kind is empty.
+const DefaultHistoryKind = "AppstatsHistory"
+
+var _ appstats.HistoryStorage = (*History)(nil)
+
+// History is an appstats.HistoryStorage backed by Cloud Datastore, for
+// trends over longer than memcache keeps rollups:
+//
+//	appstats.History = &datastorestore.History{}
+//
+// Each hourly rollup is an entity of Kind keyed by the Unix time of its
+// hour, in the appstats.Namespace namespace.
+type History struct {
+	// Kind is the entity kind of the rollups.
+	Kind string
+}
+
+type rollup struct {
+	Start time.Time
+	Value []byte `datastore:",noindex"`
+}
+
+func (h *History) kind() string {
+	if h.Kind == "" {
+		return DefaultHistoryKind
+	}
+	return h.Kind
+}
+
+// Save implements appstats.HistoryStorage.
+func (h *History) Save(c context.Context, r *appstats.Rollup) error {
+	nc, err := namespace(c)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return err
+	}
+	key := datastore.NewKey(nc, h.kind(), "", r.Start.Unix(), nil)
+	_, err = datastore.Put(nc, key, &rollup{Start: r.Start, Value: buf.Bytes()})
+	return err
+}
+
+// Load implements appstats.HistoryStorage.
+func (h *History) Load(c context.Context, start, end time.Time) ([]*appstats.Rollup, error) {
+	nc, err := namespace(c)
+	if err != nil {
+		return nil, err
+	}
+	var entities []*rollup
+	q := datastore.NewQuery(h.kind()).Filter("Start >=", start).Filter("Start <", end)
+	if _, err := q.GetAll(nc, &entities); err != nil {
+		return nil, err
+	}
+	rollups := make([]*appstats.Rollup, 0, len(entities))
+	for _, e := range entities {
+		r := &appstats.Rollup{}
+		if err := gob.NewDecoder(bytes.NewReader(e.Value)).Decode(r); err != nil {
+			continue
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, nil
+}
+
+// Purge deletes the rollups of hours starting before t.
+func (h *History) Purge(c context.Context, t time.Time) error {
+	nc, err := namespace(c)
+	if err != nil {
+		return err
+	}
+	keys, err := datastore.NewQuery(h.kind()).Filter("Start <", t).KeysOnly().GetAll(nc, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(nc, keys)
+}
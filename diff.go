@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+)
+
+// diffTimeFormat is the format of the window bounds of the comparison
+// page, as used by datetime-local inputs, in UTC.
+const diffTimeFormat = "2006-01-02T15:04"
+
+// diffWindow is a time window of the comparison page.
+type diffWindow struct {
+	From, To time.Time
+}
+
+func (w diffWindow) FromValue() string { return w.From.UTC().Format(diffTimeFormat) }
+func (w diffWindow) ToValue() string   { return w.To.UTC().Format(diffTimeFormat) }
+
+// parseWindow returns the window given by the from and to parameters
+// of r, or def if they are missing or invalid.
+func parseWindow(r *http.Request, from, to string, def diffWindow) diffWindow {
+	f, err1 := time.Parse(diffTimeFormat, r.FormValue(from))
+	t, err2 := time.Parse(diffTimeFormat, r.FormValue(to))
+	if err1 != nil || err2 != nil || !f.Before(t) {
+		return def
+	}
+	return diffWindow{f, t}
+}
+
+// loadWindow returns the merged rollup of w.
+func loadWindow(c context.Context, w diffWindow) (*Rollup, error) {
+	rollups, err := rollupStorage(c).Load(c, w.From, w.To)
+	if err != nil {
+		return nil, err
+	}
+	total := NewRollup(w.From)
+	for _, r := range rollups {
+		total.Merge(r)
+	}
+	return total, nil
+}
+
+// diffRow compares the totals of a path or RPC in two windows.
+type diffRow struct {
+	Name string
+	A, B Aggregate
+}
+
+// change formats the relative change from a to b.
+func change(a, b float64) string {
+	if a == 0 {
+		if b == 0 {
+			return "0%"
+		}
+		return "new"
+	}
+	return fmt.Sprintf("%+.0f%%", 100*(b-a)/a)
+}
+
+func (d diffRow) CountChange() string {
+	return change(float64(d.A.Count), float64(d.B.Count))
+}
+
+func (d diffRow) LatencyChange() string {
+	return change(float64(d.A.AvgDuration()), float64(d.B.AvgDuration()))
+}
+
+func (d diffRow) CostChange() string {
+	return change(d.A.AvgCost(), d.B.AvgCost())
+}
+
+// Regressed reports whether the average latency or cost rose by more
+// than DiffThreshold.
+func (d diffRow) Regressed() bool {
+	if d.A.Count == 0 || d.B.Count == 0 {
+		return false
+	}
+	limit := 1 + DiffThreshold
+	return float64(d.B.AvgDuration()) > float64(d.A.AvgDuration())*limit ||
+		d.B.AvgCost() > d.A.AvgCost()*limit
+}
+
+// diffRows compares a and b, busiest in b first.
+func diffRows(a, b map[string]*Aggregate) []diffRow {
+	rows := make(map[string]*diffRow)
+	row := func(name string) *diffRow {
+		r := rows[name]
+		if r == nil {
+			r = &diffRow{Name: name}
+			rows[name] = r
+		}
+		return r
+	}
+	for k, v := range a {
+		row(k).A = *v
+	}
+	for k, v := range b {
+		row(k).B = *v
+	}
+	list := make([]diffRow, 0, len(rows))
+	for _, r := range rows {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].B.Count != list[j].B.Count {
+			return list[i].B.Count > list[j].B.Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// diffPage compares the rollups of two time windows, by default the
+// last hour and the one before.
+func diffPage(c context.Context, w http.ResponseWriter, r *http.Request) {
+	if rollupStorage(c) == nil {
+		http.Error(w, "rollups are disabled", http.StatusNotFound)
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Minute)
+	b := parseWindow(r, "b_from", "b_to", diffWindow{now.Add(-time.Hour), now})
+	a := parseWindow(r, "a_from", "a_to", diffWindow{b.From.Add(-b.To.Sub(b.From)), b.From})
+
+	ra, err := loadWindow(c, a)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	rb, err := loadWindow(c, b)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+
+	v := struct {
+		Env       map[string]string
+		A, B      diffWindow
+		Threshold float64
+		Paths     []diffRow
+		RPCs      []diffRow
+	}{
+		Env: map[string]string{
+			"APPLICATION_ID": appengine.AppID(c),
+		},
+		A:         a,
+		B:         b,
+		Threshold: DiffThreshold * 100,
+		Paths:     diffRows(ra.Paths, rb.Paths),
+		RPCs:      diffRows(ra.RPCs, rb.RPCs),
+	}
+
+	_ = templates.ExecuteTemplate(w, "diff", v)
+}
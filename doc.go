@@ -25,6 +25,28 @@ To use this package, change your HTTP handler functions to use this signature:
 
 Register them in the usual way, wrapping them with NewHandler.
 
+Options given to NewHandler, Middleware and Dashboard configure one
+handler, so that handlers in the same app may be configured
+differently:
+
+	http.Handle("/api/", appstats.NewHandler(API, appstats.WithSampling(0.1), appstats.WithPayloadLimit(1000)))
+
+WithRoute applies options to some routes of a handler only.
+DashboardOptions configures the dashboard registered at /_ah/stats/,
+which needs the storage, and any record token, of the recorded
+handlers. The package variables with option equivalents are
+deprecated.
+
+Capturing the call stack of each RPC is a large part of the cost of
+recording requests with many RPCs. WithStacks, WithStackFrames and
+WithStackThreshold turn stacks off, keep their top frames only, or keep
+those of slow RPCs only.
+
+The requests and responses of RPCs are truncated to WithPayloadLimit
+each, and to WithPayloadBudget in total per request, so that the records of
+requests making many large calls fit in storage. The dashboard marks
+truncated payloads.
+
 Classic App Engine packages are available at https://godoc.org/gopkg.in/mjibson/v1/appstats.
 
 
@@ -55,10 +77,124 @@ Usage
 
 Use your app, and view the appstats interface at http://localhost:8080/_ah/stats/, or your production URL.
 
+The requests shown can be filtered with the query parameters q (text
+in the request line, user or RPC names), path, status, user, min (a
+minimum duration, such as 100ms), rpc, ctype (a content type
+prefix), ns (an App Engine namespace), class (the request classes cron,
+warmup or task, or -cron to exclude one) and failed (only requests
+answered with a status other than 2xx). The filters apply to the
+aggregate tables and exports too, so that the tenants of an app using
+namespaces can be analyzed separately.
+
+Requests are recorded with their App Engine service and version, also
+available as the filters service and version. The services page
+totals the requests by service, including those of the other services
+of the WithServices option, fetched from their dashboards.
+
+
+Other servers
+
+Middleware records the requests of any net/http server, on or off App
+Engine. Off App Engine, keep records in a storage that does not need
+its APIs, and mount the dashboard, which is then only served to the
+local host:
+
+	store := appstats.WithStorage(appstats.NewMemoryStorage(100))
+	http.Handle("/", appstats.Middleware(mux, store))
+	http.Handle("/debug/stats/", appstats.Dashboard("/debug/stats/", store))
+
+Handlers find the recording context in their request, for StartSpan,
+RecordCall, Annotate, Logger and outbound calls through Transport.
+FromContext returns a Recorder with the same functions, for code that
+keeps it instead of the context.
+
+On second generation runtimes (go111 and later), wrap the app with
+Middleware or NewHandler as well. App Engine API calls made with the
+request context are recorded if the app uses appengine.Main and bundled
+services, which the default memcache storage also needs; otherwise use
+another storage, such as a redisstore.Store. Logs are written to standard
+error as structured entries tied to the request trace. There is no
+users API to sign in with, so the dashboard is only shown to users App
+Engine identifies as admins.
+
+To restrict the dashboard with other logic, such as a session check or
+an SSO claim, use the WithAuthorize option. For apps behind
+Identity-Aware Proxy, a googleauth.Verifier checks IAP assertions and
+Google OAuth2 access tokens.
+
+As a second layer, the WithAllowedNetworks option serves the dashboard
+to clients in the given CIDR blocks only.
+
+Records can hold personal data. The WithRedactRules option replaces the
+matches of regular expressions, such as RedactEmails, and the values at
+JSON paths in all the recorded fields of requests, such as their paths,
+query strings, headers, bodies, logs and RPC payloads, before they are
+saved or exported.
+
+Records are gob encoded by default. The WithCodec option stores them
+with ProtoCodec or another Codec, such as a msgpackcodec.Codec, and
+WithCompression gzips them. The dashboard reads records whatever their
+codec and compression.
+
+
+Trends
+
+Per-minute rollups of recorded requests are kept in memcache for
+RollupExpiration. To keep hourly totals beyond that and chart them on
+the trends page, set History, for example to a datastorestore.History,
+and schedule the rollup job in cron.yaml:
+
+	cron:
+	- description: appstats rollups
+	  url: /_ah/stats/rollup
+	  schedule: every 1 hours
+
 
 Configuration
 
-Refer to the variables section of the documentation: http://godoc.org/github.com/mjibson/appstats#pkg-variables.
+Refer to the options and the variables section of the documentation: http://godoc.org/github.com/mjibson/appstats#pkg-variables.
+
+
+JSON API
+
+The recorded data is also available as JSON and CSV, for use by other tools:
+
+	/_ah/stats/api/requests
+		Recent requests, without RPC stacks and payloads, their
+		statistics aggregated by RPC and by path, and the repeated
+		calls that could be batched. Accepts the same
+		query parameters as the dashboard. Requests are paginated by
+		the page and size parameters, PageSize per page by default.
+	/_ah/stats/api/details?time=<id>
+		The full record of a request. The id is found in the dashboard
+		links, or as the Start time of a request in nanoseconds since
+		the Unix epoch.
+	/_ah/stats/api/slowest?n=<n>
+		The n slowest requests, SlowestRequests by default, overall
+		and for each path. Accepts the filter parameters of the
+		dashboard.
+	/_ah/stats/export.csv?table=rpc|path
+		The RPC or path statistics table of the dashboard as CSV.
+	/_ah/stats/metrics
+		Request and RPC counts, costs and latency histograms of the
+		requests recorded by the serving instance since it started, in
+		the Prometheus text format. Requests are counted by route, or
+		else by the first segment of their path, and at most 500
+		paths and RPCs have their own series. Like the other
+		endpoints, it is only served to the users allowed to see the
+		dashboard, so that on App Engine a scraper needs to be let in
+		by WithAuthorize, such as with a bearer token of its own.
+	/_ah/stats/stream
+		Newly recorded requests as server-sent events, one JSON
+		summary per event. Accepts the filter parameters of the
+		dashboard. Requires a runtime that does not buffer responses.
+	/_ah/stats/har?time=<id>
+		The urlfetch calls of a request as an HTTP Archive (HAR), for
+		inspection in browser developer tools.
+
+
+The appstats command, in cmd/appstats, prints reports from this API in
+a terminal, and can fail CI jobs on latency or error rate limits.
 
 
 Routing
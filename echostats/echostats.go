@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package echostats records the requests of an Echo server with appstats,
+aggregated by the paths of the routes they match, so that /user/123 and
+/user/456 are both counted under /user/:id.
+
+	e := echo.New()
+	e.Use(echostats.Middleware())
+	e.GET("/user/:id", userHandler)
+
+The handlers get the recording context from their request, for use with
+appstats.StartSpan, appstats.Annotate and the other recording functions.
+*/
+package echostats
+
+import (
+	"github.com/labstack/echo"
+	"github.com/mjibson/appstats"
+)
+
+// Middleware returns a middleware recording requests with
+// appstats.StartRequest configured by opts. Requests matching no route
+// are aggregated by path. Errors returned by handlers
+// are handled by the error handler of the server, so that the responses
+// sent for them are recorded.
+func Middleware(opts ...appstats.Option) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			ctx, stats := appstats.StartRequest(r.Context(), r, opts...)
+			if stats == nil {
+				return next(c)
+			}
+			if route := c.Path(); route != "" {
+				appstats.FromContext(ctx).SetRoute(route)
+			}
+			c.SetRequest(r.WithContext(ctx))
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+			res := c.Response()
+			stats.Status = res.Status
+			stats.ResponseSize = res.Size
+			stats.ContentType = res.Header().Get("Content-Type")
+			appstats.SaveRequest(ctx)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filter selects the recorded requests shown by the dashboard and
+// returned by the API. The zero filter matches all requests.
+type filter struct {
+	// Search is matched against the method, path and query, the user,
+	// and the RPC names of a request.
+	Search string
+	// ContentType is a prefix of the content type.
+	ContentType string
+	// Path is a substring of the path.
+	Path string
+	// Status is the response status, if not 0.
+	Status int
+	// User is a substring of the user.
+	User string
+	// MinDuration is the shortest duration of the request.
+	MinDuration time.Duration
+	// RPC is a substring of the name of an RPC made by the request.
+	RPC string
+	// Failed selects only the requests that failed.
+	Failed bool
+	// Namespace is the App Engine namespace of the request, if not
+	// empty.
+	Namespace string
+	// Service and Version are the App Engine service and version of
+	// the request, if not empty.
+	Service, Version string
+	// Class is a comma separated list of request classes to select,
+	// or to exclude if prefixed with a minus, such as -cron,-warmup.
+	Class string
+}
+
+// newFilter returns the filter given by the query parameters of r.
+// Invalid values are ignored.
+func newFilter(r *http.Request) *filter {
+	f := &filter{
+		Search:      r.FormValue("q"),
+		ContentType: r.FormValue("ctype"),
+		Path:        r.FormValue("path"),
+		User:        r.FormValue("user"),
+		RPC:         r.FormValue("rpc"),
+	}
+	f.Status, _ = strconv.Atoi(r.FormValue("status"))
+	f.MinDuration, _ = time.ParseDuration(r.FormValue("min"))
+	f.Failed, _ = strconv.ParseBool(r.FormValue("failed"))
+	f.Namespace = r.FormValue("ns")
+	f.Service = r.FormValue("service")
+	f.Version = r.FormValue("version")
+	f.Class = r.FormValue("class")
+	return f
+}
+
+// Active reports whether f excludes any requests.
+func (f *filter) Active() bool {
+	return *f != filter{}
+}
+
+// Params returns the query parameters of f, to keep it in links.
+func (f *filter) Params() template.URL {
+	return template.URL(f.values().Encode())
+}
+
+func (f *filter) values() url.Values {
+	v := url.Values{}
+	set := func(k, s string) {
+		if s != "" {
+			v.Set(k, s)
+		}
+	}
+	set("q", f.Search)
+	set("ctype", f.ContentType)
+	set("path", f.Path)
+	if f.Status != 0 {
+		v.Set("status", strconv.Itoa(f.Status))
+	}
+	set("user", f.User)
+	if f.MinDuration != 0 {
+		v.Set("min", f.MinDuration.String())
+	}
+	set("rpc", f.RPC)
+	if f.Failed {
+		v.Set("failed", "1")
+	}
+	set("ns", f.Namespace)
+	set("service", f.Service)
+	set("version", f.Version)
+	set("class", f.Class)
+	return v
+}
+
+// match reports whether f selects r.
+func (f *filter) match(r *RequestStats) bool {
+	if !strings.HasPrefix(r.ContentType, f.ContentType) ||
+		!strings.Contains(r.Path, f.Path) ||
+		!strings.Contains(r.User, f.User) ||
+		r.Duration < f.MinDuration ||
+		(f.Status != 0 && r.Status != f.Status) ||
+		(f.Failed && !r.Failed()) ||
+		(f.Namespace != "" && r.Namespace != f.Namespace) ||
+		(f.Service != "" && r.Service != f.Service) ||
+		(f.Version != "" && r.Version != f.Version) ||
+		!matchClass(f.Class, r.Class) {
+		return false
+	}
+	if f.RPC != "" && !r.madeRPC(f.RPC) {
+		return false
+	}
+	if f.Search != "" {
+		line := r.Method + " " + r.Path + "?" + r.Query
+		if !strings.Contains(line, f.Search) &&
+			!strings.Contains(r.User, f.Search) &&
+			!r.madeRPC(f.Search) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchClass reports whether the comma separated list of classes
+// selects class: it is not excluded by a -class item, and it is one of
+// the other items, if any.
+func matchClass(list, class string) bool {
+	if list == "" {
+		return true
+	}
+	included, including := false, false
+	for _, c := range strings.Split(list, ",") {
+		c = strings.TrimSpace(c)
+		if strings.HasPrefix(c, "-") {
+			if c[1:] == class {
+				return false
+			}
+		} else if c != "" {
+			including = true
+			included = included || c == class
+		}
+	}
+	return included || !including
+}
+
+// apply returns the requests of ars that f selects.
+func (f *filter) apply(ars allrequestStats) allrequestStats {
+	selected := allrequestStats{}
+	for _, r := range ars {
+		if f.match(r) {
+			selected = append(selected, r)
+		}
+	}
+	return selected
+}
+
+// madeRPC reports whether r made an RPC whose name contains s.
+func (r *RequestStats) madeRPC(s string) bool {
+	for _, rpc := range r.RPCStats {
+		if strings.Contains(rpc.Name(), s) {
+			return true
+		}
+	}
+	return false
+}
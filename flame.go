@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+)
+
+// flameSep separates the frames of a flame graph path.
+const flameSep = ";"
+
+// flameNode is a call path of a flame graph, with the RPCs made under
+// it.
+type flameNode struct {
+	Name     string
+	Path     string
+	Count    int
+	Duration time.Duration
+	Children []*flameNode
+
+	// Width is the share, in percent, of the node in its parent.
+	Width float64
+	// Link zooms into the node.
+	Link string
+
+	children map[string]*flameNode
+}
+
+func (n *flameNode) child(name string) *flameNode {
+	if c, ok := n.children[name]; ok {
+		return c
+	}
+	c := &flameNode{
+		Name: name,
+		Path: name,
+	}
+	if n.Path != "" {
+		c.Path = n.Path + flameSep + name
+	}
+	if n.children == nil {
+		n.children = make(map[string]*flameNode)
+	}
+	n.children[name] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// frameName returns the function of f, without call arguments.
+func frameName(f *frame) string {
+	if i := strings.LastIndex(f.Call, "("); i > 0 {
+		return f.Call[:i]
+	}
+	return f.Call
+}
+
+// add adds s under the path of its stack, outermost call first, ending
+// in the RPC itself.
+func (n *flameNode) add(s *RPCStat) {
+	d := s.Duration + s.ExtraDuration
+	n.Count++
+	n.Duration += d
+	frames := s.Stack()
+	for i := len(frames) - 1; i >= 0; i-- {
+		n = n.child(frameName(frames[i]))
+		n.Count++
+		n.Duration += d
+	}
+	n = n.child(s.Name())
+	n.Count++
+	n.Duration += d
+}
+
+// find returns the node at path below n, or nil.
+func (n *flameNode) find(path string) *flameNode {
+	if path == "" {
+		return n
+	}
+	for _, name := range strings.Split(path, flameSep) {
+		if n = n.children[name]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// layout sorts the children of n and sets their widths, by count if
+// byCount is set or else by duration, and their links, which keep the
+// query parameters v.
+func (n *flameNode) layout(byCount bool, v url.Values) {
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, c := range n.Children {
+		if byCount {
+			c.Width = 100 * float64(c.Count) / float64(n.Count)
+		} else if n.Duration > 0 {
+			c.Width = 100 * float64(c.Duration) / float64(n.Duration)
+		}
+		c.Link = flameLink(c.Path, v)
+		c.layout(byCount, v)
+	}
+}
+
+func flameLink(path string, v url.Values) string {
+	q := url.Values{}
+	for k, vs := range v {
+		q[k] = vs
+	}
+	q.Set("focus", path)
+	return "?" + q.Encode()
+}
+
+// flamePage aggregates the RPC stacks of the last FlameRequests
+// requests into a flame graph.
+func flamePage(c context.Context, w http.ResponseWriter, r *http.Request) {
+	ars, err := loadRequests(c, r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	if len(ars) > FlameRequests {
+		ars = ars[:FlameRequests]
+	}
+
+	root := &flameNode{Name: "all"}
+	stacks := make(stackCache)
+	for _, req := range ars {
+		b, err := storage(c).LoadFull(c, req.ID())
+		if err != nil {
+			continue
+		}
+		full, err := decodeFull(b)
+		if err != nil {
+			continue
+		}
+		full.Stats.shareStacks(stacks)
+		for i := range full.Stats.RPCStats {
+			root.add(&full.Stats.RPCStats[i])
+		}
+	}
+
+	byCount := r.FormValue("by") == "count"
+	f := newFilter(r)
+	v := f.values()
+	v.Set("by", r.FormValue("by"))
+
+	focus := root.find(r.FormValue("focus"))
+	if focus == nil {
+		focus = root
+	}
+	focus.Width = 100
+	focus.Link = flameLink(focus.Path, v)
+	focus.layout(byCount, v)
+
+	// The path from the root to the focus, to zoom out.
+	crumbs := []*flameNode{{Name: root.Name, Link: flameLink("", v)}}
+	if focus != root {
+		for n, names := root, strings.Split(focus.Path, flameSep); len(names) > 0; names = names[1:] {
+			n = n.children[names[0]]
+			crumbs = append(crumbs, &flameNode{Name: n.Name, Link: flameLink(n.Path, v)})
+		}
+	}
+
+	data := struct {
+		Env      map[string]string
+		Requests int
+		Filter   *filter
+		ByCount  bool
+		Focus    *flameNode
+		Crumbs   []*flameNode
+	}{
+		Env: map[string]string{
+			"APPLICATION_ID": appengine.AppID(c),
+		},
+		Requests: len(ars),
+		Filter:   f,
+		ByCount:  byCount,
+		Focus:    focus,
+		Crumbs:   crumbs,
+	}
+
+	_ = templates.ExecuteTemplate(w, "flame", data)
+}
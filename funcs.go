@@ -61,9 +61,16 @@ func lt(a, b int) bool {
 	return a < b
 }
 
+// list returns its arguments, to pass several values to a template.
+func list(args ...interface{}) []interface{} {
+	return args
+}
+
 var funcs = template.FuncMap{
-	"add":   add,
-	"eq":    eq,
-	"lt":    lt,
-	"rjust": rjust,
+	"add":     add,
+	"dollars": dollars,
+	"eq":      eq,
+	"list":    list,
+	"lt":      lt,
+	"rjust":   rjust,
 }
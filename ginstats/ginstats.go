@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package ginstats records the requests of a Gin engine with appstats,
+aggregated by the paths of the routes they match, so that /user/123 and
+/user/456 are both counted under /user/:id.
+
+	r := gin.New()
+	r.Use(ginstats.Middleware())
+	r.GET("/user/:id", userHandler)
+
+The handlers get the recording context from c.Request, for use with
+appstats.StartSpan, appstats.Annotate and the other recording functions.
+*/
+package ginstats
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mjibson/appstats"
+)
+
+// Middleware returns a middleware recording requests with
+// appstats.StartRequest configured by opts. Requests matching no route
+// are aggregated by path.
+func Middleware(opts ...appstats.Option) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, stats := appstats.StartRequest(c.Request.Context(), c.Request, opts...)
+		if stats == nil {
+			c.Next()
+			return
+		}
+		if route := c.FullPath(); route != "" {
+			appstats.FromContext(ctx).SetRoute(route)
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		stats.Status = c.Writer.Status()
+		if c.Writer.Written() {
+			stats.ResponseSize = int64(c.Writer.Size())
+		}
+		stats.ContentType = c.Writer.Header().Get("Content-Type")
+		appstats.SaveRequest(ctx)
+	}
+}
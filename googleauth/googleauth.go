@@ -0,0 +1,344 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package googleauth restricts the appstats dashboard and its JSON API to
+the users signed in by Google Identity-Aware Proxy, or to the holders of
+Google OAuth2 access tokens, for apps that are not protected by App
+Engine admin checks:
+
+	appstats.DashboardOptions(appstats.WithAuthorize((&googleauth.Verifier{
+		Audiences: []string{"/projects/123456789/apps/my-app"},
+		Emails:    []string{"alice@example.com", "@ops.example.com"},
+	}).Authorize))
+
+A request is authorized by a valid X-Goog-IAP-JWT-Assertion header,
+whose signature is checked with the public keys of IAP, or by an
+Authorization: Bearer header with an access token issued to one of
+ClientIDs, checked with the Google tokeninfo endpoint.
+*/
+package googleauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The endpoints are variables for tests.
+var (
+	iapKeysURL   = "https://www.gstatic.com/iap/verify/public_key-jwk"
+	tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+)
+
+const (
+	iapHeader = "X-Goog-IAP-JWT-Assertion"
+	iapIssuer = "https://cloud.google.com/iap"
+
+	// keysTTL is how long the public keys of IAP are cached.
+	keysTTL = time.Hour
+
+	// keysRefetch is the minimum time between fetches of the keys.
+	keysRefetch = time.Minute
+
+	// skew is the clock skew allowed when checking times.
+	skew = 30 * time.Second
+
+	// maxTokens is the number of access tokens whose verification is
+	// cached.
+	maxTokens = 1000
+)
+
+// A Verifier authorizes the dashboard requests of allowed users signed
+// in by IAP or carrying an OAuth2 access token. Its zero value denies all
+// requests.
+type Verifier struct {
+	// Audiences are the accepted audiences of IAP assertions, such as
+	// /projects/PROJECT_NUMBER/apps/PROJECT_ID on App Engine. IAP
+	// assertions are rejected if it is empty.
+	Audiences []string
+
+	// ClientIDs are the OAuth2 client IDs whose access tokens are
+	// accepted. Bearer tokens are rejected if it is empty.
+	ClientIDs []string
+
+	// Emails are the allowed users. An entry starting with "@" allows
+	// all the users of its domain. If empty, all the users signed in
+	// with an accepted audience or client ID are allowed.
+	Emails []string
+
+	// HTTPClient returns the client fetching the keys of IAP and the
+	// information of tokens for request r. If nil, http.DefaultClient
+	// is used. On first generation App Engine, return a urlfetch.Client.
+	HTTPClient func(r *http.Request) *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*ecdsa.PublicKey
+	keysExpire  time.Time
+	keysFetched time.Time
+	tokens      map[string]token
+}
+
+// A token is the verification of an access token.
+type token struct {
+	email  string
+	expiry time.Time
+}
+
+// Authorize reports whether r is from an allowed user. It is meant to be
+// used with appstats.WithAuthorize.
+func (v *Verifier) Authorize(r *http.Request) bool {
+	email, err := v.Email(r)
+	return err == nil && v.allowed(email)
+}
+
+// Email returns the verified email address of the user of r.
+func (v *Verifier) Email(r *http.Request) (string, error) {
+	if a := r.Header.Get(iapHeader); a != "" {
+		return v.verifyIAP(r, a)
+	}
+	if t := r.Header.Get("Authorization"); strings.HasPrefix(t, "Bearer ") {
+		return v.verifyToken(r, strings.TrimPrefix(t, "Bearer "))
+	}
+	return "", errors.New("googleauth: no credentials")
+}
+
+func (v *Verifier) allowed(email string) bool {
+	if len(v.Emails) == 0 {
+		return true
+	}
+	email = strings.ToLower(email)
+	for _, e := range v.Emails {
+		e = strings.ToLower(e)
+		if e == email || strings.HasPrefix(e, "@") && strings.HasSuffix(email, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Verifier) client(r *http.Request) *http.Client {
+	if v.HTTPClient != nil {
+		return v.HTTPClient(r)
+	}
+	return http.DefaultClient
+}
+
+// verifyIAP returns the email of the IAP assertion a, a JWT signed with
+// ES256.
+func (v *Verifier) verifyIAP(r *http.Request, a string) (string, error) {
+	parts := strings.Split(a, ".")
+	if len(parts) != 3 {
+		return "", errors.New("googleauth: malformed IAP assertion")
+	}
+	var header struct {
+		Alg, Kid string
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "ES256" {
+		return "", fmt.Errorf("googleauth: unexpected IAP algorithm %q", header.Alg)
+	}
+	key, err := v.key(r, header.Kid)
+	if err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return "", errors.New("googleauth: malformed IAP signature")
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	rs, ss := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(key, h[:], rs, ss) {
+		return "", errors.New("googleauth: bad IAP signature")
+	}
+
+	var claims struct {
+		Iss, Aud, Email string
+		Exp, Iat        int64
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	switch {
+	case claims.Iss != iapIssuer:
+		return "", fmt.Errorf("googleauth: unexpected IAP issuer %q", claims.Iss)
+	case !contains(v.Audiences, claims.Aud):
+		return "", fmt.Errorf("googleauth: unexpected IAP audience %q", claims.Aud)
+	case now.After(time.Unix(claims.Exp, 0).Add(skew)):
+		return "", errors.New("googleauth: expired IAP assertion")
+	case now.Add(skew).Before(time.Unix(claims.Iat, 0)):
+		return "", errors.New("googleauth: IAP assertion issued in the future")
+	case claims.Email == "":
+		return "", errors.New("googleauth: IAP assertion without email")
+	}
+	return claims.Email, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("googleauth: malformed JWT segment: %v", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("googleauth: malformed JWT segment: %v", err)
+	}
+	return nil
+}
+
+// key returns the IAP public key with ID kid. The keys are fetched when
+// they expire or kid is unknown, at most once per keysRefetch, so that
+// assertions with made-up key IDs cannot make every request wait for a
+// fetch. Expired keys are used while fetching fails.
+func (v *Verifier) key(r *http.Request, kid string) (*ecdsa.PublicKey, error) {
+	now := time.Now()
+	v.mu.Lock()
+	k, ok := v.keys[kid]
+	fetch := (!ok || now.After(v.keysExpire)) && now.Sub(v.keysFetched) >= keysRefetch
+	if fetch {
+		v.keysFetched = now
+	}
+	v.mu.Unlock()
+	if !fetch {
+		if ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("googleauth: unknown IAP key %q", kid)
+	}
+
+	keys, err := v.fetchKeys(r)
+	if err != nil {
+		if ok {
+			return k, nil
+		}
+		return nil, err
+	}
+	v.mu.Lock()
+	v.keys, v.keysExpire = keys, time.Now().Add(keysTTL)
+	v.mu.Unlock()
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("googleauth: unknown IAP key %q", kid)
+}
+
+// fetchKeys fetches the public keys of IAP, by ID.
+func (v *Verifier) fetchKeys(r *http.Request) (map[string]*ecdsa.PublicKey, error) {
+	resp, err := v.client(r).Get(iapKeysURL)
+	if err != nil {
+		return nil, fmt.Errorf("googleauth: fetching IAP keys: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("googleauth: fetching IAP keys: %s", resp.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kid, Kty, Crv, X, Y string
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("googleauth: decoding IAP keys: %v", err)
+	}
+	keys := make(map[string]*ecdsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "EC" || k.Crv != "P-256" {
+			continue
+		}
+		x, errx := base64.RawURLEncoding.DecodeString(k.X)
+		y, erry := base64.RawURLEncoding.DecodeString(k.Y)
+		if errx != nil || erry != nil {
+			continue
+		}
+		keys[k.Kid] = &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+	}
+	return keys, nil
+}
+
+// verifyToken returns the email of the OAuth2 access token t, asking the
+// tokeninfo endpoint once per token.
+func (v *Verifier) verifyToken(r *http.Request, t string) (string, error) {
+	if len(v.ClientIDs) == 0 {
+		return "", errors.New("googleauth: bearer tokens not accepted")
+	}
+	now := time.Now()
+	v.mu.Lock()
+	tok, ok := v.tokens[t]
+	v.mu.Unlock()
+	if ok && now.Before(tok.expiry) {
+		return tok.email, nil
+	}
+
+	resp, err := v.client(r).Get(tokenInfoURL + "?" + url.Values{"access_token": {t}}.Encode())
+	if err != nil {
+		return "", fmt.Errorf("googleauth: fetching token info: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("googleauth: invalid token: %s", resp.Status)
+	}
+	var info struct {
+		Aud, Azp, Email string
+		EmailVerified   string `json:"email_verified"`
+		ExpiresIn       string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("googleauth: decoding token info: %v", err)
+	}
+	expiresIn, _ := strconv.Atoi(info.ExpiresIn)
+	switch {
+	case !contains(v.ClientIDs, info.Aud) && !contains(v.ClientIDs, info.Azp):
+		return "", fmt.Errorf("googleauth: token issued to unexpected client %q", info.Aud)
+	case info.Email == "" || info.EmailVerified != "true":
+		return "", errors.New("googleauth: token without verified email")
+	case expiresIn <= 0:
+		return "", errors.New("googleauth: expired token")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.tokens == nil || len(v.tokens) >= maxTokens {
+		v.tokens = make(map[string]token)
+	}
+	v.tokens[t] = token{info.Email, now.Add(time.Duration(expiresIn) * time.Second)}
+	return info.Email, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
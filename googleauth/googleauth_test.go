@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package googleauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// server is a fake of the IAP key and tokeninfo endpoints.
+type server struct {
+	*httptest.Server
+	key        *ecdsa.PrivateKey
+	keyFetches int32
+}
+
+func newServer(t *testing.T) *server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{key: key}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/keys":
+			atomic.AddInt32(&s.keyFetches, 1)
+			fmt.Fprintf(w, `{"keys":[{"kid":"k1","kty":"EC","crv":"P-256","x":%q,"y":%q}]}`,
+				b64(pad(key.X.Bytes())), b64(pad(key.Y.Bytes())))
+		case "/tokeninfo":
+			switch r.FormValue("access_token") {
+			case "good":
+				fmt.Fprint(w, `{"aud":"cid","email":"bob@ops.example.com","email_verified":"true","expires_in":"300"}`)
+			case "other-client":
+				fmt.Fprint(w, `{"aud":"x","azp":"y","email":"bob@ops.example.com","email_verified":"true","expires_in":"300"}`)
+			case "unverified":
+				fmt.Fprint(w, `{"aud":"cid","email":"bob@ops.example.com","email_verified":"false","expires_in":"300"}`)
+			default:
+				http.Error(w, `{"error":"invalid_token"}`, http.StatusBadRequest)
+			}
+		}
+	}))
+	oldKeys, oldInfo := iapKeysURL, tokenInfoURL
+	iapKeysURL, tokenInfoURL = s.URL+"/keys", s.URL+"/tokeninfo"
+	t.Cleanup(func() {
+		s.Close()
+		iapKeysURL, tokenInfoURL = oldKeys, oldInfo
+	})
+	return s
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func pad(b []byte) []byte {
+	p := make([]byte, 32)
+	copy(p[32-len(b):], b)
+	return p
+}
+
+func segment(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return b64(b)
+}
+
+// assertion returns an IAP assertion with header and claims, signed
+// with the key of s.
+func (s *server) assertion(header, claims map[string]interface{}) string {
+	signed := segment(header) + "." + segment(claims)
+	h := sha256.Sum256([]byte(signed))
+	r, ss, _ := ecdsa.Sign(rand.Reader, s.key, h[:])
+	return signed + "." + b64(append(pad(r.Bytes()), pad(ss.Bytes())...))
+}
+
+func claims(aud, email string, exp time.Duration) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":   iapIssuer,
+		"aud":   aud,
+		"email": email,
+		"iat":   now.Unix(),
+		"exp":   now.Add(exp).Unix(),
+	}
+}
+
+// forge returns the assertion a with the signature of b.
+func forge(a, b string) string {
+	return a[:strings.LastIndex(a, ".")] + b[strings.LastIndex(b, "."):]
+}
+
+var es256 = map[string]interface{}{"alg": "ES256", "kid": "k1"}
+
+func TestAuthorize(t *testing.T) {
+	s := newServer(t)
+	otherIssuer := claims("aud", "alice@example.com", time.Minute)
+	otherIssuer["iss"] = "https://example.com"
+	tests := []struct {
+		name          string
+		header, value string
+		want          bool
+	}{
+		{"iap", iapHeader, s.assertion(es256, claims("aud", "alice@example.com", time.Minute)), true},
+		{"iap domain", iapHeader, s.assertion(es256, claims("aud", "Carol@Ops.Example.com", time.Minute)), true},
+		{"iap audience", iapHeader, s.assertion(es256, claims("other", "alice@example.com", time.Minute)), false},
+		{"iap email", iapHeader, s.assertion(es256, claims("aud", "eve@example.com", time.Minute)), false},
+		{"iap expired", iapHeader, s.assertion(es256, claims("aud", "alice@example.com", -time.Hour)), false},
+		{"iap issuer", iapHeader, s.assertion(es256, otherIssuer), false},
+		{"iap algorithm", iapHeader, s.assertion(map[string]interface{}{"alg": "none", "kid": "k1"}, claims("aud", "alice@example.com", time.Minute)), false},
+		{"iap signature", iapHeader, forge(s.assertion(es256, claims("aud", "alice@example.com", time.Minute)), s.assertion(es256, claims("aud", "eve@example.com", time.Minute))), false},
+		{"iap signature length", iapHeader, s.assertion(es256, claims("aud", "alice@example.com", time.Minute)) + "AA", false},
+		{"iap malformed", iapHeader, "a.b", false},
+		{"bearer", "Authorization", "Bearer good", true},
+		{"bearer client", "Authorization", "Bearer other-client", false},
+		{"bearer unverified", "Authorization", "Bearer unverified", false},
+		{"bearer invalid", "Authorization", "Bearer bad", false},
+		{"none", "X-Other", "x", false},
+	}
+	v := &Verifier{
+		Audiences: []string{"aud"},
+		ClientIDs: []string{"cid"},
+		Emails:    []string{"alice@example.com", "@ops.example.com"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/_ah/stats/", nil)
+		r.Header.Set(test.header, test.value)
+		if got := v.Authorize(r); got != test.want {
+			email, err := v.Email(r)
+			t.Errorf("%s: Authorize = %v, want %v (%q, %v)", test.name, got, test.want, email, err)
+		}
+	}
+}
+
+func TestZeroVerifier(t *testing.T) {
+	s := newServer(t)
+	var v Verifier
+	for _, h := range []http.Header{
+		{iapHeader: {s.assertion(es256, claims("aud", "alice@example.com", time.Minute))}},
+		{"Authorization": {"Bearer good"}},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header = h
+		if v.Authorize(r) {
+			t.Errorf("zero Verifier authorized %v", h)
+		}
+	}
+}
+
+func TestKeyRefetch(t *testing.T) {
+	s := newServer(t)
+	v := &Verifier{Audiences: []string{"aud"}}
+	unknown := map[string]interface{}{"alg": "ES256", "kid": "unknown"}
+	for i := 0; i < 5; i++ {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(iapHeader, s.assertion(unknown, claims("aud", "alice@example.com", time.Minute)))
+		if v.Authorize(r) {
+			t.Fatal("authorized an unknown key")
+		}
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(iapHeader, s.assertion(es256, claims("aud", "alice@example.com", time.Minute)))
+	if !v.Authorize(r) {
+		t.Error("known key not authorized")
+	}
+	if n := atomic.LoadInt32(&s.keyFetches); n != 1 {
+		t.Errorf("keys fetched %d times, want 1", n)
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	s := newServer(t)
+	v := &Verifier{ClientIDs: []string{"cid"}}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer good")
+	if !v.Authorize(r) {
+		t.Fatal("token not authorized")
+	}
+	s.Close()
+	if !v.Authorize(r) {
+		t.Error("cached token not authorized")
+	}
+}
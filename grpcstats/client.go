@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package grpcstats
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjibson/appstats"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RecordMessages makes the client interceptors record the request and
+// response messages of calls, truncated to appstats.ProtoMaxBytes.
+// Otherwise only the status of calls is recorded.
+var RecordMessages = false
+
+// service is the service of the recorded outbound calls.
+const service = "grpc"
+
+// UnaryClientInterceptor returns an interceptor recording the unary
+// calls made with the context of a recorded request as its RPCs, named
+// grpc.pkg.Service/Method:
+//
+//	conn, err := grpc.Dial(addr, grpc.WithUnaryInterceptor(grpcstats.UnaryClientInterceptor()))
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		appstats.RecordCall(ctx, service, callName(method), start, input(req), output(reply, err))
+		return err
+	}
+}
+
+// StreamClientInterceptor returns an interceptor recording the
+// streaming calls made with the context of a recorded request as its
+// RPCs. A call is recorded when its stream ends, once a receive fails,
+// with the last message received.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			appstats.RecordCall(ctx, service, callName(method), start, "", output(nil, err))
+			return cs, err
+		}
+		return &clientStream{ClientStream: cs, ctx: ctx, method: method, start: start}, nil
+	}
+}
+
+// clientStream records its call when a receive fails.
+type clientStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	method string
+	start  time.Time
+
+	once     sync.Once
+	req, msg interface{}
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	if s.req == nil {
+		s.req = m
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.msg = m
+		return nil
+	}
+	s.once.Do(func() {
+		end := err
+		if end == io.EOF {
+			end = nil
+		}
+		appstats.RecordCall(s.ctx, service, callName(s.method), s.start, input(s.req), output(s.msg, end))
+	})
+	return err
+}
+
+// callName returns the RPC method name of the gRPC method, without its
+// leading slash.
+func callName(method string) string {
+	return strings.TrimPrefix(method, "/")
+}
+
+func input(req interface{}) string {
+	if !RecordMessages || req == nil {
+		return ""
+	}
+	return fmt.Sprint(req)
+}
+
+func output(reply interface{}, err error) string {
+	if err != nil {
+		s, _ := status.FromError(err)
+		return s.Code().String() + ": " + s.Message()
+	}
+	if !RecordMessages || reply == nil {
+		return codes.OK.String()
+	}
+	return fmt.Sprint(reply)
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package grpcstats records gRPC calls with appstats. Its server
+interceptors record each call served as a request, shown on the
+dashboard with the HTTP requests of the app:
+
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcstats.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(grpcstats.StreamServerInterceptor()),
+	)
+
+Calls are recorded as POST requests to their full method name, such as
+/pkg.Service/Method, with the HTTP status corresponding to their gRPC
+status code, which is also attached as the grpc-status annotation. The
+request and response sizes are those of the messages received and sent.
+
+Its client interceptors record the calls made with the context of a
+recorded request as RPCs of that request, so that calls to other
+services appear on its timeline:
+
+	conn, err := grpc.Dial(addr,
+		grpc.WithUnaryInterceptor(grpcstats.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(grpcstats.StreamClientInterceptor()),
+	)
+*/
+package grpcstats
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mjibson/appstats"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor returns an interceptor recording the unary
+// calls of a server, configured by opts.
+func UnaryServerInterceptor(opts ...appstats.Option) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, stats := appstats.StartRequest(ctx, request(ctx, info.FullMethod), opts...)
+		if stats == nil {
+			return handler(ctx, req)
+		}
+		stats.RequestSize = size(req)
+		resp, err := handler(ctx, req)
+		if err == nil {
+			stats.ResponseSize = size(resp)
+		}
+		finish(ctx, stats, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns an interceptor recording the
+// streaming calls of a server, configured by opts.
+func StreamServerInterceptor(opts ...appstats.Option) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, stats := appstats.StartRequest(ss.Context(), request(ss.Context(), info.FullMethod), opts...)
+		if stats == nil {
+			return handler(srv, ss)
+		}
+		err := handler(srv, &serverStream{ss, ctx, stats})
+		finish(ctx, stats, err)
+		return err
+	}
+}
+
+// serverStream counts the sizes of the messages of a recorded stream.
+// gRPC allows one goroutine sending and one receiving at once, so each
+// size is only updated by one goroutine.
+type serverStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	stats *appstats.RequestStats
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.stats.RequestSize += size(m)
+	}
+	return err
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.stats.ResponseSize += size(m)
+	}
+	return err
+}
+
+// request returns the HTTP request describing a call of method, with
+// the metadata of ctx as headers.
+func request(ctx context.Context, method string) *http.Request {
+	r := &http.Request{
+		Method: "POST",
+		URL:    &url.URL{Path: method},
+		Proto:  "HTTP/2.0",
+		Header: make(http.Header),
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, vs := range md {
+			if strings.HasSuffix(k, "-bin") {
+				continue
+			}
+			for _, v := range vs {
+				r.Header.Add(k, v)
+			}
+		}
+	}
+	return r.WithContext(ctx)
+}
+
+// finish saves the record of a call ending with err.
+func finish(ctx context.Context, stats *appstats.RequestStats, err error) {
+	code := status.Code(err)
+	stats.Status = http.StatusInternalServerError
+	if s, ok := httpStatus[code]; ok {
+		stats.Status = s
+	}
+	appstats.Annotate(ctx, "grpc-status", code.String())
+	appstats.SaveRequest(ctx)
+}
+
+func size(m interface{}) int64 {
+	if p, ok := m.(proto.Message); ok {
+		return int64(proto.Size(p))
+	}
+	return 0
+}
+
+// httpStatus maps gRPC status codes to HTTP statuses, as gRPC gateways
+// do.
+var httpStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+}
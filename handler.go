@@ -17,12 +17,11 @@
 package appstats
 
 import (
-	"bytes"
-	"encoding/gob"
-	"fmt"
 	"html/template"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -31,7 +30,6 @@ import (
 	"golang.org/x/net/context"
 
 	"google.golang.org/appengine"
-	"google.golang.org/appengine/memcache"
 	"google.golang.org/appengine/user"
 )
 
@@ -44,6 +42,15 @@ func init() {
 	templates.Parse(htmlMain)
 	templates.Parse(htmlDetails)
 	templates.Parse(htmlFile)
+	templates.Parse(htmlFlame)
+	templates.Parse(htmlDiff)
+	templates.Parse(htmlLive)
+	templates.Parse(htmlCompare)
+	templates.Parse(htmlSlowest)
+	templates.Parse(htmlUsers)
+	templates.Parse(htmlTrends)
+	templates.Parse(htmlHeatmap)
+	templates.Parse(htmlServices)
 
 	staticFiles = map[string][]byte{
 		"app_engine_logo_sm.gif": app_engine_logo_sm_gif,
@@ -60,58 +67,307 @@ func serveError(w http.ResponseWriter, err error) {
 	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
 
-func appstatsHandler(w http.ResponseWriter, r *http.Request) {
-	c := storeContext(appengine.NewContext(r))
-	if appengine.IsDevAppServer() {
+// Dashboard returns a handler serving the appstats dashboard under
+// prefix, for use with any mux:
+//
+//	mux.Handle("/debug/stats/", appstats.Dashboard("/debug/stats/"))
+//
+// The links of the dashboard are relative to prefix. Requests whose path
+// does not start with prefix, such as those passed through
+// http.StripPrefix, are served relative to the root. The dashboard is
+// registered at /_ah/stats/ on http.DefaultServeMux.
+//
+// On App Engine, the dashboard is restricted to admins of the app.
+// Elsewhere, where the users API is not available, it is only served to
+// the local host. The WithAuthorize option replaces these checks. It
+// shows the requests of the storage given by opts, as those of
+// Middleware.
+func Dashboard(prefix string, opts ...Option) http.Handler {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &dashboard{prefix: prefix, config: newConfig(opts)}
+}
+
+// defaultDashboard is the dashboard registered at serveURL on
+// http.DefaultServeMux.
+var defaultDashboard = Dashboard(serveURL).(*dashboard)
+
+// DashboardOptions configures the dashboard registered at /_ah/stats/ on
+// http.DefaultServeMux with opts, such as the WithStorage,
+// WithAuthorize and WithRecordToken options of the recorded handlers.
+// Call it from an init function, before requests are served.
+func DashboardOptions(opts ...Option) {
+	defaultDashboard.config = newConfig(opts)
+}
+
+type dashboard struct {
+	prefix string
+	config *config
+}
+
+// allow reports whether r may be served, replying to it otherwise.
+func (d *dashboard) allow(c context.Context, w http.ResponseWriter, r *http.Request) bool {
+	authorize := d.config.authorize()
+	if onAppEngine && r.Header.Get("X-Appengine-Cron") == "true" {
+		// Cron jobs, such as the rollup job. App Engine removes the
+		// header from outside requests.
+	} else if onAppEngine && r.Header.Get("X-Appengine-Inbound-Appid") == appengine.AppID(c) && strings.HasSuffix(r.URL.Path, "/"+apiRequestsURL) {
+		// The services page of another service of the app. App
+		// Engine sets the header on URL Fetch requests between apps.
+	} else if nets := d.config.allowedNetworks(); nets != nil && !inNetworks(clientIP(r), nets) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	} else if authorize != nil {
+		if !authorize(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return false
+		}
+	} else if !onAppEngine {
+		if !isLoopback(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return false
+		}
+	} else if appengine.IsDevAppServer() {
 		// noop
+	} else if secondGen {
+		// There is no users API to log in with, but App Engine still
+		// identifies the users of handlers requiring a login.
+		if u := user.Current(c); u == nil || !u.Admin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return false
+		}
 	} else if u := user.Current(c); u == nil {
 		if loginURL, err := user.LoginURL(c, r.URL.String()); err == nil {
 			http.Redirect(w, r, loginURL, http.StatusTemporaryRedirect)
 		} else {
 			serveError(w, err)
 		}
-		return
+		return false
 	} else if !u.Admin {
 		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (d *dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var c context.Context
+	if onAppEngine {
+		c = appengine.NewContext(r)
+	} else {
+		c = r.Context()
+	}
+	if !d.allow(c, w, r) {
+		return
+	}
+
+	c = context.WithValue(c, configKey, d.config)
+
+	var page string
+	switch p := r.URL.Path; {
+	case strings.HasPrefix(p, d.prefix):
+		page = p[len(d.prefix):]
+	case p+"/" == d.prefix:
+		// Relative links need the trailing slash.
+		http.Redirect(w, r, d.prefix, http.StatusMovedPermanently)
 		return
+	default:
+		page = strings.TrimPrefix(p, "/")
 	}
 
-	if detailsURL == r.URL.Path {
-		details(c, w, r)
-	} else if fileURL == r.URL.Path {
+	switch {
+	case page == detailsURL:
+		detailsPage(c, w, r)
+	case page == apiRequestsURL:
+		apiRequests(c, w, r)
+	case page == apiDetailsURL:
+		apiDetails(c, w, r)
+	case page == csvURL:
+		exportCSV(c, w, r)
+	case page == metricsURL:
+		serveMetrics(w, r)
+	case page == harURL:
+		exportHAR(c, w, r)
+	case page == flameURL:
+		flamePage(c, w, r)
+	case page == diffURL:
+		diffPage(c, w, r)
+	case page == streamURL:
+		stream(c, w, r)
+	case page == liveURL:
+		livePage(c, w, r)
+	case page == replayURL:
+		replay(c, w, r)
+	case page == compareURL:
+		comparePage(c, w, r)
+	case page == slowestURL:
+		slowestPage(c, w, r)
+	case page == apiSlowestURL:
+		apiSlowest(c, w, r)
+	case page == usersURL:
+		usersPage(c, w, r)
+	case page == rollupURL:
+		compactRollups(c, w, r)
+	case page == trendsURL:
+		trendsPage(c, w, r)
+	case page == heatmapURL:
+		heatmapPage(c, w, r)
+	case page == servicesURL:
+		servicesPage(c, w, r)
+	case page == fileURL:
 		file(c, w, r)
-	} else if strings.HasPrefix(r.URL.Path, staticURL) {
+	case strings.HasPrefix(page, staticURL):
 		static(w, r)
-	} else {
+	default:
 		index(c, w, r)
 	}
 }
 
-func index(c context.Context, w http.ResponseWriter, r *http.Request) {
-	keys := make([]string, modulus)
-	for i := range keys {
-		keys[i] = fmt.Sprintf(keyPart, i*distance)
+// loadRequests returns the recorded requests matching the filters in r,
+// most recent first.
+func loadRequests(c context.Context, r *http.Request) (allrequestStats, error) {
+	all, err := listRequests(c)
+	if err != nil {
+		return nil, err
 	}
+	return newFilter(r).apply(all), nil
+}
 
-	items, err := memcache.GetMulti(c, keys)
+// listRequests returns all recorded requests, most recent first.
+func listRequests(c context.Context) (allrequestStats, error) {
+	records, err := storage(c).List(c)
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	ars := allrequestStats{}
-	for _, v := range items {
-		t := stats_part{}
-		err := gob.NewDecoder(bytes.NewBuffer(v.Value)).Decode(&t)
+	for _, v := range records {
+		t, err := decodePart(v)
 		if err != nil {
 			continue
 		}
-		r := requestStats(t)
-		ars = append(ars, &r)
+		ars = append(ars, t)
 	}
 	sort.Sort(reverse{ars})
+	return ars, nil
+}
+
+// namespaces returns the namespaces of ars, sorted.
+func namespaces(ars allrequestStats) []string {
+	seen := make(map[string]bool)
+	var ns []string
+	for _, r := range ars {
+		if r.Namespace != "" && !seen[r.Namespace] {
+			seen[r.Namespace] = true
+			ns = append(ns, r.Namespace)
+		}
+	}
+	sort.Strings(ns)
+	return ns
+}
 
-	requestById := make(map[int]*requestStats, len(ars))
-	idByRequest := make(map[*requestStats]int, len(ars))
+// storageStats holds the sizes of the stored full records of a set of
+// requests.
+type storageStats struct {
+	// Records is the number of requests with known record sizes, of
+	// which Compressed have compressed records.
+	Records, Compressed int
+	// Size and RawSize are the total stored and uncompressed sizes.
+	Size, RawSize byteSize
+}
+
+// newStorageStats totals the record sizes of ars.
+func newStorageStats(ars allrequestStats) storageStats {
+	var s storageStats
+	for _, r := range ars {
+		if r.RecordSize == 0 {
+			continue
+		}
+		s.Records++
+		if r.RecordSize != r.RecordRawSize {
+			s.Compressed++
+		}
+		s.Size += byteSize(r.RecordSize)
+		s.RawSize += byteSize(r.RecordRawSize)
+	}
+	return s
+}
+
+// AvgSize returns the average stored size of a record.
+func (s storageStats) AvgSize() byteSize {
+	if s.Records == 0 {
+		return 0
+	}
+	return s.Size / byteSize(s.Records)
+}
+
+// Ratio returns the stored size as a percentage of the uncompressed size.
+func (s storageStats) Ratio() float64 {
+	if s.RawSize == 0 {
+		return 100
+	}
+	return float64(100 * s.Size / s.RawSize)
+}
+
+// overview holds the statistics of a set of requests, aggregated by
+// request, RPC and path.
+type overview struct {
+	// Requests maps request numbers, starting at 1, to the requests.
+	Requests         map[int]*statByName
+	AllStatsByCount  statsByName
+	PathStatsByCount statsByName
+	SlowestRPCs      slowRPCs
+	CostByService    []serviceCost
+	Batching         []batchOpportunity
+}
+
+// serviceCost is the total cost of the RPCs to an API service.
+type serviceCost struct {
+	Service string
+	Count   int
+	Cost    int64
+	// Percent is the share of Cost in the cost of all RPCs.
+	Percent float64
+}
+
+// costByService totals the cost of the RPCs of ars by service, most
+// expensive first.
+func costByService(ars allrequestStats) []serviceCost {
+	byService := make(map[string]*serviceCost)
+	var total int64
+	for _, r := range ars {
+		for _, s := range r.RPCStats {
+			sc := byService[s.Service]
+			if sc == nil {
+				sc = &serviceCost{Service: s.Service}
+				byService[s.Service] = sc
+			}
+			sc.Count++
+			sc.Cost += s.Cost
+			total += s.Cost
+		}
+	}
+	costs := make([]serviceCost, 0, len(byService))
+	for _, sc := range byService {
+		if total > 0 {
+			sc.Percent = 100 * float64(sc.Cost) / float64(total)
+		}
+		costs = append(costs, *sc)
+	}
+	sort.Slice(costs, func(i, j int) bool {
+		if costs[i].Cost != costs[j].Cost {
+			return costs[i].Cost > costs[j].Cost
+		}
+		return costs[i].Service < costs[j].Service
+	})
+	return costs
+}
+
+// newOverview aggregates ars, which are sorted most recent first.
+func newOverview(ars allrequestStats) *overview {
+	requestById := make(map[int]*RequestStats, len(ars))
+	idByRequest := make(map[*RequestStats]int, len(ars))
 	requests := make(map[int]*statByName)
 	byRequest := make(map[int]map[string]cVal)
 	for i, v := range ars {
@@ -125,15 +381,36 @@ func index(c context.Context, w http.ResponseWriter, r *http.Request) {
 	}
 
 	requestByPath := make(map[string][]int)
+	pathDurations := make(map[string][]time.Duration)
+	pathErrors := make(map[string]int)
+	pathMemcache := make(map[string]cacheStats)
+	pathBytes := make(map[string]int64)
+	pathGoroutines := make(map[string]int)
 	byCount := make(map[string]cVal)
 	byRPC := make(map[skey]cVal)
+	var slowest slowRPCs
 	for _, t := range ars {
 		id := idByRequest[t]
+		path := t.aggregatePath()
 
-		requestByPath[t.Path] = append(requestByPath[t.Path], id)
+		requestByPath[path] = append(requestByPath[path], id)
+		pathDurations[path] = append(pathDurations[path], t.Duration)
+		if t.Failed() {
+			pathErrors[path]++
+		}
+		mc := pathMemcache[path]
+		mc.merge(t.Memcache())
+		pathMemcache[path] = mc
+		pathBytes[path] += t.ResponseSize
+		pathGoroutines[path] += t.GoroutineGrowth()
 
-		for _, r := range t.RPCStats {
+		for i, r := range t.RPCStats {
 			rpc := r.Name()
+			slowest = slowest.add(slowRPC{
+				RPCStat: r,
+				Index:   i,
+				Request: t,
+			}, SlowestRPCs)
 
 			v := byRequest[id][rpc]
 			v.count++
@@ -143,12 +420,16 @@ func index(c context.Context, w http.ResponseWriter, r *http.Request) {
 			v = byCount[rpc]
 			v.count++
 			v.cost += r.Cost
+			v.ops.merge(r.DatastoreOps())
+			v.durations = append(v.durations, r.Duration)
 			byCount[rpc] = v
 
-			v = byRPC[skey{rpc, t.Path}]
+			v = byRPC[skey{rpc, path}]
 			v.count++
 			v.cost += r.Cost
-			byRPC[skey{rpc, t.Path}] = v
+			v.ops.merge(r.DatastoreOps())
+			v.durations = append(v.durations, r.Duration)
+			byRPC[skey{rpc, path}] = v
 		}
 	}
 
@@ -168,15 +449,22 @@ func index(c context.Context, w http.ResponseWriter, r *http.Request) {
 	statsByRPC := make(map[string]statsByName)
 	pathStats := make(map[string]statsByName)
 	for k, v := range byRPC {
-		statsByRPC[k.a] = append(statsByRPC[k.a], &statByName{
-			Name:  k.b,
-			Count: v.count,
-			Cost:  v.cost,
-		})
+		s := &statByName{
+			Name:      k.b,
+			Count:     v.count,
+			Cost:      v.cost,
+			Datastore: v.ops,
+		}
+		s.setPercentiles(v.durations)
+		statsByRPC[k.a] = append(statsByRPC[k.a], s)
 		pathStats[k.b] = append(pathStats[k.b], &statByName{
-			Name:  k.a,
-			Count: v.count,
-			Cost:  v.cost,
+			Name:      k.a,
+			Count:     v.count,
+			Cost:      v.cost,
+			P50:       s.P50,
+			P95:       s.P95,
+			P99:       s.P99,
+			Datastore: v.ops,
 		})
 	}
 	for k, v := range statsByRPC {
@@ -185,84 +473,199 @@ func index(c context.Context, w http.ResponseWriter, r *http.Request) {
 	}
 
 	pathStatsByCount := statsByName{}
-	for k, v := range pathStats {
+	for k, reqs := range requestByPath {
+		v := pathStats[k]
 		total := 0
 		var cost int64
+		var ops datastoreOps
 		for _, stat := range v {
 			total += stat.Count
 			cost += stat.Cost
+			ops.merge(stat.Datastore)
 		}
 		sort.Sort(reverse{v})
 
-		pathStatsByCount = append(pathStatsByCount, &statByName{
+		s := &statByName{
 			Name:       k,
 			Count:      total,
 			Cost:       cost,
 			SubStats:   v,
-			Requests:   len(requestByPath[k]),
-			RecentReqs: requestByPath[k],
-		})
+			Requests:   len(reqs),
+			RecentReqs: reqs,
+			Errors:     pathErrors[k],
+			Memcache:   pathMemcache[k],
+			Datastore:  ops,
+			Bytes:      pathBytes[k],
+			Goroutines: pathGoroutines[k],
+		}
+		s.setPercentiles(pathDurations[k])
+		pathStatsByCount = append(pathStatsByCount, s)
 	}
 	sort.Sort(reverse{pathStatsByCount})
 
 	allStatsByCount := statsByName{}
 	for k, v := range byCount {
-		allStatsByCount = append(allStatsByCount, &statByName{
-			Name:     k,
-			Count:    v.count,
-			Cost:     v.cost,
-			SubStats: statsByRPC[k],
-		})
+		s := &statByName{
+			Name:      k,
+			Count:     v.count,
+			Cost:      v.cost,
+			SubStats:  statsByRPC[k],
+			Datastore: v.ops,
+		}
+		s.setPercentiles(v.durations)
+		s.Histogram = latencyHistogram(v.durations)
+		allStatsByCount = append(allStatsByCount, s)
 	}
 	sort.Sort(reverse{allStatsByCount})
 
-	v := struct {
-		Env                 map[string]string
-		Requests            map[int]*statByName
-		RequestStatsByCount map[int]*statByName
-		AllStatsByCount     statsByName
-		PathStatsByCount    statsByName
-	}{
-		Env: map[string]string{
-			"APPLICATION_ID": appengine.AppID(c),
-		},
+	return &overview{
 		Requests:         requests,
 		AllStatsByCount:  allStatsByCount,
 		PathStatsByCount: pathStatsByCount,
+		SlowestRPCs:      slowest,
+		CostByService:    costByService(ars),
+		Batching:         batchingOpportunities(ars),
 	}
-
-	_ = templates.ExecuteTemplate(w, "main", v)
 }
 
-func details(c context.Context, w http.ResponseWriter, r *http.Request) {
-	i, _ := strconv.Atoi(r.FormValue("time"))
-	qtime := roundTime(i)
-	key := fmt.Sprintf(keyFull, qtime)
+func index(c context.Context, w http.ResponseWriter, r *http.Request) {
+	all, err := listRequests(c)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	ars := newFilter(r).apply(all)
 
 	v := struct {
-		Env             map[string]string
-		Record          *requestStats
-		Header          http.Header
-		AllStatsByCount statsByName
-		Real            time.Duration
+		Env map[string]string
+		*overview
+		Filter  *filter
+		Refresh int
+		Page    *pager
+		// History holds the Requests of the page.
+		History     map[int]*statByName
+		Regressions []regression
+		// Namespaces are those of all recorded requests, for the
+		// namespace filter.
+		Namespaces []string
+		// Storage holds the record sizes of all recorded requests.
+		Storage storageStats
 	}{
 		Env: map[string]string{
 			"APPLICATION_ID": appengine.AppID(c),
 		},
+		overview: newOverview(ars),
+		Filter:   newFilter(r),
+		Refresh:  int(DashboardRefresh / time.Second),
+		Page:     newPager(r, len(ars)),
+
+		Namespaces: namespaces(all),
+		Storage:    newStorageStats(all),
+	}
+	start, end := v.Page.bounds()
+	v.History = make(map[int]*statByName, end-start)
+	for i := start + 1; i <= end; i++ {
+		v.History[i] = v.Requests[i]
+	}
+	if refresh := r.FormValue("refresh"); refresh != "" {
+		v.Refresh, _ = strconv.Atoi(refresh)
+	}
+	if v.Regressions, err = findRegressions(c, ars); err != nil {
+		logf(c, "ERROR", "appstats regressions: %v", err)
+	}
+
+	_ = templates.ExecuteTemplate(w, "main", v)
+}
+
+// details holds a single request with its full record, as shown on its
+// details page.
+type details struct {
+	Record          *RequestStats
+	Header          http.Header
+	AllStatsByCount statsByName
+	Real            time.Duration
+
+	// Overlaps holds, for each RPC, the number of other RPCs running at
+	// some point during it.
+	Overlaps []int
+	// Busy is the time during which at least one RPC was running, and
+	// Concurrent the time during which more than one was, up to
+	// MaxConcurrent at once.
+	Busy, Concurrent time.Duration
+	MaxConcurrent    int
+
+	// Repeated lists the likely N+1 patterns of the request.
+	Repeated []repeatedCall
+
+	// Tasks are the recorded tasks enqueued by the request.
+	Tasks []*RequestStats
+
+	// Log is the log of the request interleaved with its RPCs.
+	Log []logEntry
+}
+
+// concurrency sets the RPC overlap statistics of d.
+func (d *details) concurrency() {
+	rpcs := d.Record.RPCStats
+	end := func(s RPCStat) time.Duration {
+		return s.Offset + s.Duration + s.ExtraDuration
+	}
+
+	d.Overlaps = make([]int, len(rpcs))
+	for i := range rpcs {
+		for j := i + 1; j < len(rpcs); j++ {
+			if rpcs[i].Offset < end(rpcs[j]) && rpcs[j].Offset < end(rpcs[i]) {
+				d.Overlaps[i]++
+				d.Overlaps[j]++
+			}
+		}
+	}
+
+	type event struct {
+		at    time.Duration
+		delta int
 	}
+	events := make([]event, 0, 2*len(rpcs))
+	for _, s := range rpcs {
+		events = append(events, event{s.Offset, 1}, event{end(s), -1})
+	}
+	// Ends sort before starts at the same time, so that back to back
+	// RPCs do not count as concurrent.
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at != events[j].at {
+			return events[i].at < events[j].at
+		}
+		return events[i].delta < events[j].delta
+	})
+	active := 0
+	var last time.Duration
+	for _, e := range events {
+		if active > 0 {
+			d.Busy += e.at - last
+		}
+		if active > 1 {
+			d.Concurrent += e.at - last
+		}
+		active += e.delta
+		last = e.at
+		if active > d.MaxConcurrent {
+			d.MaxConcurrent = active
+		}
+	}
+}
 
-	item, err := memcache.Get(c, key)
+// loadDetails loads the full record of request id.
+func loadDetails(c context.Context, id int64) (*details, error) {
+	b, err := storage(c).LoadFull(c, id)
 	if err != nil {
-		templates.ExecuteTemplate(w, "details", v)
-		return
+		return nil, err
 	}
 
-	full := stats_full{}
-	err = gob.NewDecoder(bytes.NewBuffer(item.Value)).Decode(&full)
+	full, err := decodeFull(b)
 	if err != nil {
-		templates.ExecuteTemplate(w, "details", v)
-		return
+		return nil, err
 	}
+	full.Stats.shareStacks(make(stackCache))
 
 	byCount := make(map[string]cVal)
 	durationCount := make(map[string]time.Duration)
@@ -293,10 +696,38 @@ func details(c context.Context, w http.ResponseWriter, r *http.Request) {
 	}
 	sort.Sort(allStatsByCount)
 
-	v.Record = full.Stats
-	v.Header = full.Header
-	v.AllStatsByCount = allStatsByCount
-	v.Real = _real
+	d := &details{
+		Record:          full.Stats,
+		Header:          full.Header,
+		AllStatsByCount: allStatsByCount,
+		Real:            _real,
+	}
+	d.concurrency()
+	d.Repeated = findRepeated(full.Stats.RPCStats)
+	d.Log = interleaveLog(full.Stats)
+	return d, nil
+}
+
+func detailsPage(c context.Context, w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.FormValue("time"), 10, 64)
+
+	v := struct {
+		Env map[string]string
+		*details
+	}{
+		Env: map[string]string{
+			"APPLICATION_ID": appengine.AppID(c),
+		},
+		details: &details{},
+	}
+	if d, err := loadDetails(c, id); err == nil {
+		v.details = d
+		if addsTasks(d.Record) {
+			if d.Tasks, err = findTasks(c, id); err != nil {
+				logf(c, "ERROR", "appstats tasks: %v", err)
+			}
+		}
+	}
 
 	_ = templates.ExecuteTemplate(w, "details", v)
 }
@@ -306,6 +737,10 @@ func file(c context.Context, w http.ResponseWriter, r *http.Request) {
 	n := r.URL.Query().Get("n")
 	lineno, _ := strconv.Atoi(n)
 
+	if !sourceFile(fname) {
+		http.NotFound(w, r)
+		return
+	}
 	f, err := ioutil.ReadFile(fname)
 	if err != nil {
 		serveError(w, err)
@@ -334,6 +769,28 @@ func file(c context.Context, w http.ResponseWriter, r *http.Request) {
 	_ = templates.ExecuteTemplate(w, "file", v)
 }
 
+// sourceFile reports whether name is a Go source file in the working
+// directory of the app or below it, the only files the file page shows.
+func sourceFile(name string) bool {
+	if filepath.Ext(name) != ".go" || !filepath.IsAbs(name) {
+		return false
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	root, err := filepath.EvalSymlinks(wd)
+	if err != nil {
+		return false
+	}
+	name, err = filepath.EvalSymlinks(name)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func static(w http.ResponseWriter, r *http.Request) {
 	fname := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
 	if v, present := staticFiles[fname]; present {
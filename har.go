@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// The types below are a subset of the HTTP Archive 1.2 format.
+
+type harLog struct {
+	Log struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Pages   []harPage  `json:"pages"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harPage struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	ID              string    `json:"id"`
+	Title           string    `json:"title"`
+	PageTimings     struct {
+		OnLoad float64 `json:"onLoad"`
+	} `json:"pageTimings"`
+}
+
+type harEntry struct {
+	PageRef         string      `json:"pageref"`
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func harMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	nv := []harNameValue{}
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			nv = append(nv, harNameValue{k, v})
+		}
+	}
+	return nv
+}
+
+func harQuery(u string) []harNameValue {
+	nv := []harNameValue{}
+	if pu, err := url.Parse(u); err == nil {
+		q := pu.Query()
+		for k, vs := range q {
+			for _, v := range vs {
+				nv = append(nv, harNameValue{k, v})
+			}
+		}
+	}
+	return nv
+}
+
+// newHAR converts the urlfetch calls of d to an HTTP archive.
+func newHAR(d *details) *harLog {
+	h := &harLog{}
+	h.Log.Version = "1.2"
+	h.Log.Creator = harCreator{Name: "appstats", Version: "1"}
+	page := harPage{
+		StartedDateTime: d.Record.Start,
+		ID:              strconv.FormatInt(d.Record.ID(), 10),
+		Title:           d.Record.Method + " " + d.Record.Path,
+	}
+	page.PageTimings.OnLoad = harMillis(d.Record.Duration)
+	h.Log.Pages = []harPage{page}
+	h.Log.Entries = []harEntry{}
+	for _, s := range d.Record.RPCStats {
+		c := s.HTTP
+		if c == nil {
+			continue
+		}
+		duration := s.Duration + s.ExtraDuration
+		h.Log.Entries = append(h.Log.Entries, harEntry{
+			PageRef:         page.ID,
+			StartedDateTime: s.Start,
+			Time:            harMillis(duration),
+			Request: harRequest{
+				Method:      c.Method,
+				URL:         c.URL,
+				HTTPVersion: "HTTP/1.1",
+				Cookies:     []harNameValue{},
+				Headers:     harHeaders(c.RequestHeader),
+				QueryString: harQuery(c.URL),
+				HeadersSize: -1,
+				BodySize:    c.RequestSize,
+			},
+			Response: harResponse{
+				Status:      c.Status,
+				StatusText:  http.StatusText(c.Status),
+				HTTPVersion: "HTTP/1.1",
+				Cookies:     []harNameValue{},
+				Headers:     harHeaders(c.ResponseHeader),
+				Content: harContent{
+					Size:     c.ResponseSize,
+					MimeType: c.ResponseHeader.Get("Content-Type"),
+				},
+				HeadersSize: -1,
+				BodySize:    c.ResponseSize,
+			},
+			// Only the total duration of a call is known.
+			Timings: harTimings{Wait: harMillis(duration)},
+		})
+	}
+	return h
+}
+
+// exportHAR serves the urlfetch calls of the request given by the time
+// parameter as an HTTP archive.
+func exportHAR(c context.Context, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.FormValue("time"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad time parameter", http.StatusBadRequest)
+		return
+	}
+	d, err := loadDetails(c, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=appstats-%d.har", id))
+	serveJSON(w, newHAR(d))
+}
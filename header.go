@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"net/http"
+)
+
+// redacted replaces the values of redacted headers.
+const redacted = "[redacted]"
+
+// filterHeader returns the headers of h to record: those in
+// RecordHeaders, if set, and not in IgnoreHeaders or carrying the token
+// of WithRecordToken, redacted with rules.
+func filterHeader(h http.Header, rules []RedactRule) http.Header {
+	if h == nil {
+		return nil
+	}
+	f := make(http.Header, len(h))
+	for k, v := range h {
+		if RecordHeaders != nil && !hasHeader(RecordHeaders, k) || hasHeader(IgnoreHeaders, k) || k == recordHeader {
+			continue
+		}
+		f[k] = v
+	}
+	return redactHeader(rules, f)
+}
+
+// redactHeader returns h with the values of the headers in RedactHeaders
+// or matching RedactHeaderPattern replaced, and rules applied to the
+// others, leaving h unchanged.
+func redactHeader(rules []RedactRule, h http.Header) http.Header {
+	var r http.Header
+	for k, v := range h {
+		var values []string
+		if hasHeader(RedactHeaders, k) || RedactHeaderPattern != nil && RedactHeaderPattern.MatchString(k) {
+			values = make([]string, len(v))
+			for i := range values {
+				values[i] = redacted
+			}
+		} else {
+			for i, s := range v {
+				if rs := redactString(rules, s); rs != s {
+					if values == nil {
+						values = append([]string(nil), v...)
+					}
+					values[i] = rs
+				}
+			}
+			if values == nil {
+				continue
+			}
+		}
+		if r == nil {
+			r = make(http.Header, len(h))
+			for name, values := range h {
+				r[name] = values
+			}
+		}
+		r[k] = values
+	}
+	if r == nil {
+		return h
+	}
+	return r
+}
+
+// hasHeader reports whether names contains the header name k.
+func hasHeader(names []string, k string) bool {
+	for _, n := range names {
+		if http.CanonicalHeaderKey(n) == http.CanonicalHeaderKey(k) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+)
+
+// heatmapColumns is the largest number of time columns of the heatmap.
+const heatmapColumns = 48
+
+// heatmapRow is a latency bucket of the heatmap, across time.
+type heatmapRow struct {
+	Label string
+	Cells []heatmapCell
+}
+
+// heatmapCell counts the requests of a latency bucket and time column.
+type heatmapCell struct {
+	Start time.Time
+	Count int
+	// Opacity is Count relative to the fullest cell.
+	Opacity float64
+}
+
+// heatmap returns the rows of the latency heatmap of path, or of all
+// requests if empty, from rollups over columns of width starting at
+// start, slowest bucket first.
+func heatmap(rollups []*Rollup, path string, start time.Time, width time.Duration, columns int) []heatmapRow {
+	counts := make([][]int, len(latencyBounds)+1)
+	for i := range counts {
+		counts[i] = make([]int, columns)
+	}
+	for _, r := range rollups {
+		col := int(r.Start.Sub(start) / width)
+		if col < 0 || col >= columns {
+			continue
+		}
+		for k, a := range r.Paths {
+			if path != "" && k != path {
+				continue
+			}
+			for i, n := range a.Latency {
+				if i < len(counts) {
+					counts[i][col] += n
+				}
+			}
+		}
+	}
+
+	max := 0
+	for _, row := range counts {
+		for _, n := range row {
+			if n > max {
+				max = n
+			}
+		}
+	}
+	rows := make([]heatmapRow, len(counts))
+	for i, row := range counts {
+		b := latencyBucket{}
+		if i < len(latencyBounds) {
+			b.Le = latencyBounds[i]
+		}
+		hr := heatmapRow{Label: b.Label(), Cells: make([]heatmapCell, columns)}
+		for col, n := range row {
+			hr.Cells[col] = heatmapCell{Start: start.Add(time.Duration(col) * width), Count: n}
+			if max > 0 {
+				hr.Cells[col].Opacity = float64(n) / float64(max)
+			}
+		}
+		rows[len(counts)-1-i] = hr
+	}
+	return rows
+}
+
+// heatmapPage shows the number of requests by latency bucket over the
+// last hours, for all requests or the path given by the path parameter.
+func heatmapPage(c context.Context, w http.ResponseWriter, r *http.Request) {
+	if rollupStorage(c) == nil && History == nil {
+		http.Error(w, "rollups are disabled", http.StatusNotFound)
+		return
+	}
+	hours, err := strconv.Atoi(r.FormValue("hours"))
+	if err != nil || hours <= 0 {
+		hours = HeatmapHours
+	}
+	path := r.FormValue("path")
+	window := time.Duration(hours) * time.Hour
+	end := time.Now().UTC().Truncate(RollupWidth).Add(RollupWidth)
+	start := end.Add(-window)
+	rollups, hourly, err := loadRollups(c, start, end)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+
+	unit := RollupWidth
+	if hourly {
+		unit = time.Hour
+		start = start.Truncate(time.Hour)
+	}
+	width := (window/heatmapColumns + unit - 1) / unit * unit
+	columns := int((end.Sub(start) + width - 1) / width)
+
+	v := struct {
+		Env   map[string]string
+		Hours int
+		Path  string
+		Width time.Duration
+		Rows  []heatmapRow
+		// Times label every sixth column.
+		Times []string
+	}{
+		Env: map[string]string{
+			"APPLICATION_ID": appengine.AppID(c),
+		},
+		Hours: hours,
+		Path:  path,
+		Width: width,
+		Rows:  heatmap(rollups, path, start, width, columns),
+		Times: make([]string, columns),
+	}
+	for i := 0; i < columns; i += 6 {
+		v.Times[i] = start.Add(time.Duration(i) * width).Format("01-02 15:04")
+	}
+
+	_ = templates.ExecuteTemplate(w, "heatmap", v)
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+)
+
+// HistoryStorage durably stores hourly rollups.
+type HistoryStorage interface {
+	// Save stores r, replacing the stored rollup of its hour.
+	Save(c context.Context, r *Rollup) error
+	// Load returns the stored rollups of the hours starting in
+	// [start, end).
+	Load(c context.Context, start, end time.Time) ([]*Rollup, error)
+}
+
+// compactHour merges the rollups of the hour starting at t.
+func compactHour(c context.Context, t time.Time) (*Rollup, error) {
+	rollups, err := rollupStorage(c).Load(c, t, t.Add(time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	h := newRollupAt(t)
+	for _, r := range rollups {
+		h.Merge(r)
+	}
+	return h, nil
+}
+
+// compactRollups is the rollup job. It saves to History the rollups of
+// the last HistoryCompactHours complete hours, merged from Rollups. An
+// hour already stored with at least as many requests, such as one whose
+// rollups have since expired from memcache, is left alone, so the job
+// may run any number of times. Schedule it hourly in cron.yaml:
+//
+//	cron:
+//	- description: appstats rollups
+//	  url: /_ah/stats/rollup
+//	  schedule: every 1 hours
+func compactRollups(c context.Context, w http.ResponseWriter, r *http.Request) {
+	if rollupStorage(c) == nil || History == nil {
+		http.Error(w, "rollup history is disabled", http.StatusNotFound)
+		return
+	}
+	end := time.Now().UTC().Truncate(time.Hour)
+	start := end.Add(-time.Duration(HistoryCompactHours) * time.Hour)
+	stored, err := History.Load(c, start, end)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	counts := make(map[int64]int)
+	for _, s := range stored {
+		counts[s.Start.Unix()] = s.Total().Count
+	}
+
+	saved := 0
+	for t := start; t.Before(end); t = t.Add(time.Hour) {
+		h, err := compactHour(c, t)
+		if err != nil {
+			serveError(w, err)
+			return
+		}
+		if n := h.Total().Count; n == 0 || n <= counts[t.Unix()] {
+			continue
+		}
+		if err := History.Save(c, h); err != nil {
+			serveError(w, err)
+			return
+		}
+		saved++
+	}
+	fmt.Fprintf(w, "saved %d hourly rollups\n", saved)
+}
+
+// trendPoint is an hour of the trends page.
+type trendPoint struct {
+	Start time.Time
+	Aggregate
+	// Percent is Count as a percentage of the busiest hour.
+	Percent float64
+}
+
+// trendPath is a path listed on the trends page.
+type trendPath struct {
+	Path string
+	Aggregate
+}
+
+// trendsPage shows the hourly totals stored in History over the last
+// days, most recent first, for all requests or the path given by the
+// path parameter.
+func trendsPage(c context.Context, w http.ResponseWriter, r *http.Request) {
+	if History == nil {
+		http.Error(w, "rollup history is disabled", http.StatusNotFound)
+		return
+	}
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil || days <= 0 {
+		days = TrendDays
+	}
+	path := r.FormValue("path")
+	end := time.Now().UTC().Truncate(time.Hour)
+	start := end.Add(-time.Duration(days) * 24 * time.Hour)
+	rollups, err := History.Load(c, start, end)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].Start.After(rollups[j].Start)
+	})
+
+	byPath := make(map[string]*Aggregate)
+	points := make([]trendPoint, 0, len(rollups))
+	busiest := 0
+	for _, h := range rollups {
+		for k, a := range h.Paths {
+			aggregate(byPath, k).merge(a)
+		}
+		p := trendPoint{Start: h.Start}
+		if path == "" {
+			p.Aggregate = h.Total()
+		} else if a := h.Paths[path]; a != nil {
+			p.Aggregate = *a
+		}
+		if p.Count > busiest {
+			busiest = p.Count
+		}
+		points = append(points, p)
+	}
+	for i := range points {
+		if busiest > 0 {
+			points[i].Percent = 100 * float64(points[i].Count) / float64(busiest)
+		}
+	}
+	paths := make([]trendPath, 0, len(byPath))
+	for k, a := range byPath {
+		paths = append(paths, trendPath{k, *a})
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Count != paths[j].Count {
+			return paths[i].Count > paths[j].Count
+		}
+		return paths[i].Path < paths[j].Path
+	})
+
+	v := struct {
+		Env    map[string]string
+		Days   int
+		Path   string
+		Points []trendPoint
+		Paths  []trendPath
+	}{
+		Env: map[string]string{
+			"APPLICATION_ID": appengine.AppID(c),
+		},
+		Days:   days,
+		Path:   path,
+		Points: points,
+		Paths:  paths,
+	}
+
+	_ = templates.ExecuteTemplate(w, "trends", v)
+}
@@ -70,6 +70,31 @@ const htmlBase = `
 <script src="static/appstats_js.js"></script>
 {{ end }}
 
+{{ define "filters" }}
+  <label>Search: <input name="q" value="{{.Search}}"></label>
+  <label>Path: <input name="path" value="{{.Path}}" size="12"></label>
+  <label>Status: <input name="status" value="{{ if .Status }}{{.Status}}{{ end }}" size="3"></label>
+  <label>User: <input name="user" value="{{.User}}" size="12"></label>
+  <label>Min duration: <input name="min" value="{{ if .MinDuration }}{{.MinDuration}}{{ end }}" size="6" placeholder="100ms"></label>
+  <label>RPC: <input name="rpc" value="{{.RPC}}" size="12"></label>
+  <label>Content-Type: <input name="ctype" value="{{.ContentType}}" size="12"></label>
+  <label>Namespace: <input name="ns" value="{{.Namespace}}" size="10" list="ae-namespaces"></label>
+  <label>Service: <input name="service" value="{{.Service}}" size="10"></label>
+  <label>Version: <input name="version" value="{{.Version}}" size="10"></label>
+  <label>Class: <input name="class" value="{{.Class}}" size="10" placeholder="-cron,-warmup"></label>
+  <label><input type="checkbox" name="failed" value="1"{{ if .Failed }} checked{{ end }}> Failed only</label>
+{{ end }}
+
+{{ define "pager" }}
+{{ if gt .Pages 1 }}
+<div class="ae-pager">
+  {{ if gt .Page 1 }}<a href="{{ .Link 1 }}">&laquo; First</a> <a href="{{ .Link (add .Page -1) }}">&lsaquo; Newer</a>{{ end }}
+  Page {{.Page}} of {{.Pages}} ({{.Total}} requests)
+  {{ if lt .Page .Pages }}<a href="{{ .Link (add .Page 1) }}">Older &rsaquo;</a> <a href="{{ .Link .Pages }}">Last &raquo;</a>{{ end }}
+</div>
+{{ end }}
+{{ end }}
+
 {{ define "footer" }}
 </body>
 </html>
@@ -79,10 +104,28 @@ const htmlBase = `
 const htmlMain = `
 {{ define "main" }}
 {{ template "top" . }}
+{{ if gt .Refresh 0 }}
+  <meta http-equiv="refresh" content="{{.Refresh}}">
+{{ end }}
 {{ template "body" . }}
 
 <form id="ae-stats-refresh" action=".">
   <button id="ae-refresh">Refresh Now</button>
+  {{ template "filters" .Filter }}
+  <label>Auto-refresh (seconds): <input name="refresh" value="{{.Refresh}}" size="4"></label>
+  {{ with .Namespaces }}
+  <datalist id="ae-namespaces">
+    {{ range . }}<option value="{{.}}">{{ end }}
+  </datalist>
+  {{ end }}
+  <a href="flame?{{.Filter.Params}}">Flame graph</a>
+  <a href="slowest?{{.Filter.Params}}">Slowest requests</a>
+  <a href="users?{{.Filter.Params}}">Users</a>
+  <a href="diff">Compare windows</a>
+  <a href="trends">Trends</a>
+  <a href="heatmap">Heatmap</a>
+  <a href="services?{{.Filter.Params}}">Services</a>
+  <a href="live?{{.Filter.Params}}">Live</a>
 </form>
 
 {{ if .Requests }}
@@ -92,7 +135,7 @@ const htmlMain = `
     <div class="ae-table-wrapper-left">
       <div class="ae-table-title">
         <div class="g-section g-tpl-50-50 g-split">
-          <div class="g-unit g-first"><h2>RPC Stats</h2></div>
+          <div class="g-unit g-first"><h2>RPC Stats</h2> <a href="export.csv?table=rpc&amp;{{.Filter.Params}}">CSV</a></div>
           <div id="ae-rpc-expand-all" class="g-unit"></div>
         </div>
       </div>
@@ -107,6 +150,10 @@ const htmlMain = `
             <th>Count</th>
             <th>Cost</th>
             <th>Cost&nbsp;%</th>
+            <th title="Datastore entity reads/writes/index writes">DS ops</th>
+            <th>p50</th>
+            <th>p95</th>
+            <th>p99</th>
           </tr>
         </thead>
         {{ range $index, $item := .AllStatsByCount }}
@@ -117,8 +164,12 @@ const htmlMain = `
               {{$item.Name}}
             </td>
             <td>{{$item.Count}}</td>
-            <td title="">{{$item.Cost}}</td>
+            <td title="{{dollars $item.Cost}}">{{$item.Cost}}</td>
             <td>{{/*$item.CostPct*/}}</td>
+            <td>{{ if $item.Datastore.Total }}{{$item.Datastore}}{{ end }}</td>
+            <td>{{$item.P50}}</td>
+            <td>{{$item.P95}}</td>
+            <td>{{$item.P99}}</td>
           </tr>
         </tbody>
         <tbody class="ae-rpc-detail" id="ae-rpc-expand-{{$index}}-detail">
@@ -126,8 +177,12 @@ const htmlMain = `
           <tr>
             <td class="rpc-req">{{$subitem.Name}}</td>
             <td>{{$subitem.Count}}</td>
-            <td title="">{{$subitem.Cost}}</td>
+            <td title="{{dollars $subitem.Cost}}">{{$subitem.Cost}}</td>
             <td>{{/*$subitem.CostPct*/}}</td>
+            <td>{{ if $subitem.Datastore.Total }}{{$subitem.Datastore}}{{ end }}</td>
+            <td>{{$subitem.P50}}</td>
+            <td>{{$subitem.P95}}</td>
+            <td>{{$subitem.P99}}</td>
           </tr>
           {{ end }}
         </tbody>
@@ -141,7 +196,7 @@ const htmlMain = `
     <div class="ae-table-wrapper-right">
       <div class="ae-table-title">
         <div class="g-section g-tpl-50-50 g-split">
-          <div class="g-unit g-first"><h2>Path Stats</h2></div>
+          <div class="g-unit g-first"><h2>Path Stats</h2> <a href="export.csv?table=path&amp;{{.Filter.Params}}">CSV</a></div>
           <div class="g-unit" id="ae-path-expand-all"></div>
         </div>
       </div>
@@ -158,7 +213,17 @@ const htmlMain = `
             <th>#RPCs</th>
             <th>Cost</th>
             <th>Cost%</th>
+            <th title="Datastore entity reads/writes/index writes">DS ops</th>
             <th>#Requests</th>
+            <th>Errors</th>
+            <th>Error%</th>
+            <th>Cache hit%</th>
+            <th>Avg size</th>
+            <th>Bytes</th>
+            <th title="Average growth in goroutines during the requests">Goroutines</th>
+            <th>p50</th>
+            <th>p95</th>
+            <th>p99</th>
             <th>Most Recent requests</th>
           </tr>
         </thead>
@@ -171,13 +236,23 @@ const htmlMain = `
           <td>
             {{$item.Count}}
           </td>
-          <td title="">{{$item.Cost}}</td>
+          <td title="{{dollars $item.Cost}}">{{$item.Cost}}</td>
           <td>{{/*$item.CostPct*/}}</td>
+          <td>{{ if $item.Datastore.Total }}{{$item.Datastore}}{{ end }}</td>
           <td>{{$item.Requests}}</td>
+          <td>{{$item.Errors}}</td>
+          <td>{{ printf "%.1f" $item.ErrorPercent }}%</td>
+          <td>{{ if $item.Memcache.Lookups }}<span title="{{$item.Memcache.Hits}} hits, {{$item.Memcache.Misses}} misses">{{ printf "%.1f" $item.Memcache.HitRate }}%</span>{{ end }}</td>
+          <td>{{$item.AvgBytes}}</td>
+          <td>{{$item.TotalBytes}}</td>
+          <td{{ if $item.Leaking }} class="ae-leaking" title="Likely leaking goroutines"{{ end }}>{{ printf "%+.1f" $item.GoroutineGrowth }}</td>
+          <td>{{$item.P50}}</td>
+          <td>{{$item.P95}}</td>
+          <td>{{$item.P99}}</td>
           <td>
             {{ range $index, $element := $item.RecentReqs }}
                 {{ if lt $index 10 }}
-                    <a href="#req-{{$element}}">({{$element}})</a>
+                    <a href="{{$.Page.RequestLink $element}}">({{$element}})</a>
                 {{ end }}
                 {{ if eq $index 10 }}
                     ...
@@ -189,10 +264,20 @@ const htmlMain = `
             <tr>
               <td class="rpc-req">{{$subitem.Name}}</td>
               <td>{{$subitem.Count}}</td>
-              <td title="">{{$subitem.Cost}}</td>
+              <td title="{{dollars $subitem.Cost}}">{{$subitem.Cost}}</td>
               <td>{{/*$subitem.CostPct*/}}</td>
+              <td>{{ if $subitem.Datastore.Total }}{{$subitem.Datastore}}{{ end }}</td>
+              <td></td>
+              <td></td>
               <td></td>
               <td></td>
+              <td></td>
+              <td></td>
+              <td></td>
+              <td>{{$subitem.P50}}</td>
+              <td>{{$subitem.P95}}</td>
+              <td>{{$subitem.P99}}</td>
+              <td></td>
             </tr>
             {{ end }}
           </tbody>
@@ -202,6 +287,194 @@ const htmlMain = `
     {{/* Path stats table end */}}
   </div>
 </div>
+{{ if .SlowestRPCs }}
+<div id="ae-slowest-rpcs">
+  <div class="ae-table-title">
+    <h2>Slowest RPCs</h2>
+  </div>
+  <table cellspacing="0" cellpadding="0" class="ae-table ae-stripe" id="ae-table-slowest">
+    <thead>
+      <tr>
+        <th>RPC</th>
+        <th>real</th>
+        <th>Cost</th>
+        <th>Request</th>
+      </tr>
+    </thead>
+    <tbody>
+      {{ range $item := .SlowestRPCs }}
+      <tr>
+        <td>{{$item.Name}}</td>
+        <td>{{$item.Duration}}</td>
+        <td>{{$item.Cost}}</td>
+        <td>
+          <a href="details?time={{$item.Request.ID}}#rpc{{$item.Index}}">
+            {{$item.Request.Start}}
+            "{{$item.Request.Method}} {{$item.Request.Path}}"
+          </a>
+        </td>
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+</div>
+{{ end }}
+{{ if .Regressions }}
+<div id="ae-regressions">
+  <div class="ae-table-title">
+    <h2>Regressions</h2>
+  </div>
+  <table cellspacing="0" cellpadding="0" class="ae-table ae-stripe" id="ae-table-regressions">
+    <thead>
+      <tr>
+        <th>Path</th>
+        <th>#Requests</th>
+        <th>Avg real</th>
+        <th>Baseline</th>
+        <th>Avg cost</th>
+        <th>Baseline</th>
+      </tr>
+    </thead>
+    <tbody>
+      {{ range $r := .Regressions }}
+      <tr>
+        <td>{{$r.Path}}</td>
+        <td>{{$r.Recent.Count}}</td>
+        <td>{{ if $r.LatencyRegressed }}<b>{{$r.Recent.AvgDuration}}</b> ({{ printf "%.1f" $r.LatencyFactor }}x){{ else }}{{$r.Recent.AvgDuration}}{{ end }}</td>
+        <td>{{$r.Baseline.AvgDuration}}</td>
+        <td>{{ if $r.CostRegressed }}<b>{{ printf "%.0f" $r.Recent.AvgCost }}</b> ({{ printf "%.1f" $r.CostFactor }}x){{ else }}{{ printf "%.0f" $r.Recent.AvgCost }}{{ end }}</td>
+        <td>{{ printf "%.0f" $r.Baseline.AvgCost }}</td>
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+</div>
+{{ end }}
+{{ if .CostByService }}
+<div id="ae-service-costs">
+  <div class="ae-table-title">
+    <h2>Cost by Service</h2>
+  </div>
+  <table cellspacing="0" cellpadding="0" class="ae-table ae-stripe" id="ae-table-service-costs">
+    <thead>
+      <tr>
+        <th>Service</th>
+        <th>RPCs</th>
+        <th>Cost</th>
+        <th style="width: 50%">Share</th>
+      </tr>
+    </thead>
+    <tbody>
+      {{ range $s := .CostByService }}
+      <tr>
+        <td>{{$s.Service}}</td>
+        <td>{{$s.Count}}</td>
+        <td title="{{dollars $s.Cost}}">{{$s.Cost}}</td>
+        <td>
+          <div style="background-color: #7777ff; height: 1em; width: {{ printf "%.1f" $s.Percent }}%; min-width: 1px; display: inline-block; vertical-align: middle"></div>
+          {{ printf "%.1f" $s.Percent }}%
+        </td>
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+</div>
+{{ end }}
+{{ if .Batching }}
+<div id="ae-batching">
+  <div class="ae-table-title">
+    <h2>Batching Opportunities</h2>
+  </div>
+  <table cellspacing="0" cellpadding="0" class="ae-table ae-stripe" id="ae-table-batching">
+    <thead>
+      <tr>
+        <th>Path</th>
+        <th>RPC</th>
+        <th>Requests</th>
+        <th>Calls per Request</th>
+        <th>Potential Savings</th>
+        <th>Suggestion</th>
+      </tr>
+    </thead>
+    <tbody>
+      {{ range $b := .Batching }}
+      <tr>
+        <td>{{$b.Path}}</td>
+        <td>{{$b.Name}}</td>
+        <td>{{$b.Requests}}</td>
+        <td>{{ printf "%.1f" $b.AvgCalls }}</td>
+        <td>{{$b.Savings}}</td>
+        <td>use {{$b.Batch}}</td>
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+</div>
+{{ end }}
+{{ with .Storage }}{{ if .Records }}
+<div id="ae-storage">
+  <div class="ae-table-title">
+    <h2>Storage</h2>
+  </div>
+  <table cellspacing="0" cellpadding="0" class="ae-table ae-stripe" id="ae-table-storage">
+    <thead>
+      <tr>
+        <th>Records</th>
+        <th>Compressed</th>
+        <th>Stored Size</th>
+        <th>Uncompressed Size</th>
+        <th>Average Stored Size</th>
+        <th>Ratio</th>
+      </tr>
+    </thead>
+    <tbody>
+      <tr>
+        <td>{{.Records}}</td>
+        <td>{{.Compressed}}</td>
+        <td>{{.Size}}</td>
+        <td>{{.RawSize}}</td>
+        <td>{{.AvgSize}}</td>
+        <td>{{ printf "%.1f" .Ratio }}%</td>
+      </tr>
+    </tbody>
+  </table>
+</div>
+{{ end }}{{ end }}
+<div id="ae-rpc-histograms">
+  <div class="ae-table-title">
+    <h2>RPC Latency Histograms</h2>
+  </div>
+  <style>
+    .ae-histogram { display: flex; align-items: flex-end; height: 40px; }
+    .ae-histogram div { width: 12px; margin-right: 1px; background-color: #7777ff; }
+    .ae-histogram-axis { font-size: 80%; color: grey; }
+    .ae-leaking { background-color: #fdd; font-weight: bold; }
+    .ae-panic { color: #c00; font-weight: bold; }
+  </style>
+  <table cellspacing="0" cellpadding="0" class="ae-table ae-stripe" id="ae-table-histograms">
+    <thead>
+      <tr>
+        <th>RPC</th>
+        <th>Latency</th>
+      </tr>
+    </thead>
+    <tbody>
+      {{ range $item := .AllStatsByCount }}
+      <tr>
+        <td>{{$item.Name}}</td>
+        <td>
+          <div class="ae-histogram">
+            {{ range $b := $item.Histogram }}
+            <div style="height: {{ printf "%.1f" $b.Height }}%; min-height: 1px" title="{{$b.Label}}: {{$b.Count}}"></div>
+            {{ end }}
+          </div>
+        </td>
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+  <div class="ae-histogram-axis">Buckets from 1ms to over 10s; hover for counts.</div>
+</div>
 <div id="ae-req-history">
   <div class="ae-table-title">
     <div class="g-section g-tpl-50-50 g-split">
@@ -209,6 +482,7 @@ const htmlMain = `
       <div class="g-unit" id="ae-request-expand-all"></div>
     </div>
   </div>
+  {{ template "pager" .Page }}
 
   <table cellspacing="0" cellpadding="0" class="ae-table" id='ae-table-request'>
     <colgroup>
@@ -219,26 +493,31 @@ const htmlMain = `
         <th colspan="4">Request</th>
       </tr>
     </thead>
-    {{ range $index, $r := .Requests }}
+    {{ range $index, $r := .History }}
     <tbody>
       <tr>
         <td colspan="4" class="ae-hanging-indent">
           <span class="goog-inline-block ae-zippy ae-zippy-expand" id="ae-path-requests-{{$index}}"></span>
           ({{$index}})
-          <a name="req-{{$index}}" href="details?time={{$r.RequestStats.Start.Nanosecond}}" class="ae-stats-request-link">
+          <a name="req-{{$index}}" href="details?time={{$r.RequestStats.ID}}" class="ae-stats-request-link">
             {{$r.RequestStats.Start}}
             "{{$r.RequestStats.Method}}
             {{$r.RequestStats.Path}}{{if $r.RequestStats.Query}}?{{$r.RequestStats.Query}}{{end}}"
             {{if $r.RequestStats.Status}}{{$r.RequestStats.Status}}{{end}}
           </a>
+          {{if $r.RequestStats.Panic}}<span class="ae-panic">panic: {{$r.RequestStats.Panic}}</span>{{end}}
+          {{if $r.RequestStats.Class}}({{$r.RequestStats.Class}}){{end}}
+          {{if $r.RequestStats.ContentType}}[{{$r.RequestStats.ContentType}}]{{end}}
+          {{if $r.RequestStats.CacheControl}}cache-control={{$r.RequestStats.CacheControl}}{{end}}
+          {{if $r.RequestStats.Age}}age={{$r.RequestStats.Age}}{{end}}
           real={{$r.RequestStats.Duration}}
+          overhead={{$r.RequestStats.Overhead}}
           {{/*
-          overhead={{$r.overhead_walltime_milliseconds}}ms
           ({{$r.combined_rpc_count}} RPC{{$r.combined_rpc_count}},
             billed_ops=[{{$r.combined_rpc_billed_ops}}])
           */}}
           ({{$r.RequestStats.RPCStats | len}} RPCs,
-            cost={{$r.RequestStats.Cost}})
+            cost={{$r.RequestStats.Cost}} ({{dollars $r.RequestStats.Cost}}))
         </td>
       </tr>
     </tbody>
@@ -255,6 +534,11 @@ const htmlMain = `
     </tbody>
     {{ end }}
   </table>
+  {{ template "pager" .Page }}
+</div>
+{{ else if .Filter.Active }}
+<div>
+  No recorded requests match the filter.
 </div>
 {{ else }}
 <div>
@@ -279,8 +563,37 @@ const htmlMain = `
 `
 
 const htmlDetails = `
+{{ define "protonodes" }}
+{{ range $n := . }}
+  {{ if $n.Message }}
+  <details class="ae-pb" open>
+    <summary><span class="ae-pb-field">{{$n.Name}}</span></summary>
+    {{ template "protonodes" $n.Children }}
+  </details>
+  {{ else }}
+  <div class="ae-pb"><span class="ae-pb-field">{{$n.Name}}</span>: <span class="ae-pb-{{$n.Kind}}">{{$n.Value}}</span></div>
+  {{ end }}
+{{ end }}
+{{ end }}
+
+{{ define "payload" }}
+<div class="ae-pb-tree">
+  {{ template "protonodes" index . 0 }}
+  <details><summary>raw</summary><code>{{ index . 1 }}</code></details>
+</div>
+{{ end }}
+
 {{ define "details" }}
 {{ template "top" . }}
+<style>
+  .ae-pb-tree { font-family: monospace; }
+  .ae-pb { margin-left: 1.5em; }
+  .ae-pb-field { color: #881391; }
+  .ae-pb-string { color: #c41a16; }
+  .ae-pb-number { color: #1c00cf; }
+  .ae-pb-ident { color: #0b6e0b; }
+  .ae-panic { color: #c00; font-weight: bold; }
+</style>
 {{ template "body" . }}
 
 {{ if not .Record }}
@@ -293,17 +606,50 @@ const htmlDetails = `
         <span class="ae-stats-response ae-stats-response-{{.Record.Status}}">
           {{.Record.Status}}
         </span>
+        {{ if .Record.Route }}<br>Route: {{.Record.Route}}{{ end }}
+        {{ if .Record.Class }}<br>Class: {{.Record.Class}}{{ end }}
+        {{ if .Record.Namespace }}<br>Namespace: {{.Record.Namespace}}{{ end }}
+        {{ if .Record.Service }}<br>Service: {{.Record.Service}}{{ if .Record.Version }} ({{.Record.Version}}){{ end }}{{ end }}
+        {{ if .Record.ContentType }}<br>{{.Record.ContentType}}{{ end }}
+        {{ if .Record.CacheControl }}<br>Cache-Control: {{.Record.CacheControl}}{{ end }}
+        {{ if .Record.Age }}<br>Age: {{.Record.Age}}{{ end }}
       </dt>
       <dd>
         <a {{ if eq .Record.Method "GET" }}target="_new" title="Resubmit the original request to the server" href="{{.Record.Path}}?{{.Record.Query}}" {{ end }}>
           {{.Record.Method}}  {{.Record.Path}}{{if .Record.Query}}?{{.Record.Query}}{{end}}
         </a>
         <br>
+        {{ if .Record.TraceID }}
+        trace={{.Record.TraceID}} parent={{.Record.SpanID}}{{ if .Record.TraceState }} state={{.Record.TraceState}}{{ end }}
+        <br>
+        {{ end }}
         {{.Record.User}}{{ if .Record.Admin }}*{{ end }}
         real={{.Record.Duration}}
-        cost={{.Record.Cost}}
+        cost={{.Record.Cost}} ({{dollars .Record.Cost}})
+        overhead={{.Record.Overhead}}
+        {{ if .Record.RequestSize }}request_size={{.Record.RequestBytes}}{{ end }}
+        size={{.Record.ResponseBytes}}
+        goroutines={{.Record.GoroutinesStart}}&rarr;{{.Record.GoroutinesEnd}}
+        {{ with .Record.Memory }}
+        <br>
+        <span title="Changes in the runtime.MemStats of the instance, including concurrent requests">
+          memory: {{.Mallocs}} allocs, {{.Allocated}} allocated, heap {{.Growth}},
+          {{.NumGC}} GCs{{ if .NumGC }} pausing {{.GCPause}}{{ end }}
+        </span>
+        {{ end }}
+        {{ with .Record.Memcache }}{{ if .Lookups }}
+        <br>
+        memcache: {{.Hits}} hits, {{.Misses}} misses ({{ printf "%.0f" .HitRate }}%)
+        {{ end }}{{ end }}
+        {{ with .Record.DatastoreOps }}{{ if .Total }}
+        <br>
+        datastore: {{.Reads}} entity reads, {{.Writes}} entity writes, {{.IndexWrites}} index writes
+        {{ end }}{{ end }}
+        {{ range .Record.Annotations }}
+        <br>
+        {{.Key}}: {{.Value}}
+        {{ end }}
         {{/*
-        overhead={{.Record.overhead_walltime_milliseconds}}ms
         <br>
         billed_ops={{.Record.combined_rpc_billed_ops}}
         */}}
@@ -311,8 +657,102 @@ const htmlDetails = `
     </dl>
   </div>
 
+  {{ if .Record.Body }}
+  <div id="ae-stats-details-body">
+    <h2>Request body</h2>
+    <pre>{{ printf "%s" .Record.Body }}{{ if .Record.BodyTruncated }}...{{ end }}</pre>
+    {{ if .Record.BodyTruncated }}<p>Truncated to the first {{ len .Record.Body }} bytes.</p>{{ end }}
+  </div>
+  {{ end }}
+
+  {{ if .Record.Panic }}
+  <div id="ae-stats-details-panic">
+    <h2>Panic</h2>
+    <p class="ae-panic">{{.Record.Panic}}</p>
+    <pre>{{.Record.PanicStack}}</pre>
+  </div>
+  {{ end }}
+
   <div id="ae-stats-details-timeline">
     <h2>Timeline</h2>
+    <a href="har?time={{.Record.ID}}">Download HAR</a>
+    <form action="replay" method="post" style="display: inline">
+      <input type="hidden" name="time" value="{{.Record.ID}}">
+      <button title="Reissue this request, with its body if it was recorded whole, and compare the traces">Replay</button>
+    </form>
+    {{ if .Record.ReplayOf }}
+    Replay of <a href="details?time={{.Record.ReplayOf}}">{{.Record.ReplayOf}}</a>
+    (<a href="compare?a={{.Record.ReplayOf}}&amp;b={{.Record.ID}}">compare</a>)
+    {{ end }}
+    {{ if .Record.Parent }}
+    {{ if eq .Record.Class "background" }}Run in the background by{{ else }}Task enqueued by{{ end }}
+    <a href="details?time={{.Record.Parent}}">{{.Record.Parent}}</a>
+    {{ end }}
+    {{ if .Tasks }}
+    <p>
+      Enqueued tasks and background work:
+      {{ range .Tasks }}
+      <a href="details?time={{.ID}}">{{.Path}}</a> ({{.Status}}, {{.Duration}})
+      {{ end }}
+    </p>
+    {{ end }}
+    {{ if .Record.RPCStats }}
+    <p>
+      RPCs were running for {{.Busy}}{{ if .Concurrent }}, {{.Concurrent}} of it
+      with up to {{.MaxConcurrent}} at once. Green bars overlap other RPCs{{ end }}.
+    </p>
+    {{ end }}
+    {{ if .Record.Events }}
+    <table cellspacing="0" cellpadding="0" class="ae-table ae-stripe" id="ae-table-events">
+      <thead>
+        <tr>
+          <th>Offset</th>
+          <th>Event</th>
+        </tr>
+      </thead>
+      <tbody>
+        {{ range .Record.Events }}
+        <tr>
+          <td>@{{.Offset}}</td>
+          <td>{{.Message}}</td>
+        </tr>
+        {{ end }}
+      </tbody>
+    </table>
+    {{ end }}
+    {{ if .Log }}
+    <table cellspacing="0" cellpadding="0" class="ae-table ae-stripe" id="ae-table-log">
+      <thead>
+        <tr>
+          <th>Offset</th>
+          <th>Level</th>
+          <th>Log</th>
+        </tr>
+      </thead>
+      <tbody>
+        {{ range .Log }}
+        <tr>
+          <td>@{{.Offset}}</td>
+          {{ if .Call }}
+          <td>RPC</td>
+          <td><a href="#rpc{{.RPC}}">{{.Call.Name}}</a> ({{.Call.Duration}})</td>
+          {{ else }}
+          <td>{{.Level}}</td>
+          <td>{{.Message}}</td>
+          {{ end }}
+        </tr>
+        {{ end }}
+      </tbody>
+    </table>
+    {{ end }}
+    {{ range .Repeated }}
+    <p class="ae-nplusone">
+      Likely N+1: <b>{{.Name}}</b> was called {{len .Calls}} times in sequence
+      with requests of the same shape
+      ({{ range $i, $c := .Calls }}{{ if $i }}, {{ end }}<a href="#rpc{{$c}}">#{{$c}}</a>{{ end }}),
+      taking {{.Duration}}. To save up to {{.Savings}}, {{.Batch}}.
+    </p>
+    {{ end }}
     <div id="ae-body-timeline">
       <div id="ae-rpc-chart">[Chart goes here]</div>
     </div>
@@ -339,6 +779,9 @@ const htmlDetails = `
                 <b>{{$t.Name}}</b>
                 real={{$t.Duration}}
                 cost={{$t.Cost}}
+                {{ if or $t.Hits $t.Misses }}hits={{$t.Hits}} misses={{$t.Misses}}{{ end }}
+                {{ with $t.DatastoreOps }}{{ if .Total }}reads={{.Reads}} writes={{.Writes}} index_writes={{.IndexWrites}}{{ end }}{{ end }}
+                {{ if $t.Truncated }}<i>payloads truncated</i>{{ end }}
                 {{/*
                 billed_ops=[{{t.billed_ops_str}}]
                 */}}
@@ -348,15 +791,19 @@ const htmlDetails = `
           <tbody>
             {{ if $t.In }}
             <tr>
-              <td style="padding-left: 20px"><b>Request:</b> {{$t.Request}}</td>
+              <td style="padding-left: 20px"><b>Request:</b>{{ if $t.Truncated }} <i>(truncated)</i>{{ end }}
+                {{ template "payload" (list $t.RequestTree $t.Request) }}
+              </td>
             </tr>
             {{ end }}
             {{ if $t.Out }}
             <tr>
-              <td style="padding-left: 20px"><b>Response:</b> {{$t.Response}}</td>
+              <td style="padding-left: 20px"><b>Response:</b>{{ if $t.Truncated }} <i>(truncated)</i>{{ end }}
+                {{ template "payload" (list $t.ResponseTree $t.Response) }}
+              </td>
             </tr>
             {{ end }}
-            {{ if $t.StackData }}
+            {{ if $t.Stack }}
             <tr>
               <td style="padding-left: 20px"><b>Stack:</b></td>
             </tr>
@@ -364,7 +811,7 @@ const htmlDetails = `
               <tr>
                 <td style="padding-left: 40px">
                   <span  style="padding-left: 12px; text-indent: -12px" class="goog-inline-block ae-zippy-expand" id="ae-head-stack-{{$index}}-{{$stackindex}}">&nbsp;</span>
-                  <a href="file?f={{ $f.Location }}&n={{ $f.Lineno }}#n{{ add $f.Lineno -10 }}">{{ $f.Location }}:{{ $f.Lineno }}</a> {{ $f.Call }}
+                  <a href="{{ $f.Link }}">{{ $f.Location }}:{{ $f.Lineno }}</a> {{ $f.Call }}
                 </td>
               </tr>
               {{/*
@@ -379,6 +826,13 @@ const htmlDetails = `
             {{ end }}{{/* t.call_stack_size */}}
           </tbody>
           {{ end }}{{/* .Record.individual_stats_list */}}
+          {{ with .Record.DroppedRPCs }}
+          <tbody>
+            <tr>
+              <td><i>+{{.}} more calls, total duration {{$.Record.DroppedDuration}}</i></td>
+            </tr>
+          </tbody>
+          {{ end }}
         </table>
       </div>
     {{ end }}{{/* traces */}}
@@ -475,7 +929,18 @@ function renderChart() {
       '');
   chart.add_bar('<b>Grand Total</b>', 0, {{.Record.Duration.Seconds}} * 1000, 0,
       '{{.Record.Duration}}', '');
-  document.getElementById('ae-rpc-chart').innerHTML = chart.draw();
+  var el = document.getElementById('ae-rpc-chart');
+  el.innerHTML = chart.draw();
+
+  // Highlight the RPCs that ran concurrently with others.
+  var overlaps = {{.Overlaps}} || [];
+  var rows = el.getElementsByClassName('ae-stats-gantt-datarow');
+  for (var i = 0; i < overlaps.length && i < rows.length; i++) {
+    if (overlaps[i] > 0) {
+      rows[i].getElementsByClassName('ae-stats-gantt-bar')[0].style.backgroundColor = '#44aa44';
+      rows[i].title = overlaps[i] + ' concurrent RPCs';
+    }
+  }
 }
 renderChart();
 </script>
@@ -503,3 +968,514 @@ const htmlFile = `
 {{ template "footer" . }}
 {{ end }}
 `
+
+const htmlFlame = `
+{{ define "flamenode" }}
+<div class="ae-flame-node" style="width: {{ printf "%.4f" .Width }}%">
+  <a class="ae-flame-label{{ if not .Children }} ae-flame-rpc{{ end }}" href="{{.Link}}"
+    title="{{.Name}}: {{.Count}} RPCs, {{.Duration}}">{{.Name}}</a>
+  {{ if .Children }}
+  <div class="ae-flame-children">
+    {{ range .Children }}{{ template "flamenode" . }}{{ end }}
+  </div>
+  {{ end }}
+</div>
+{{ end }}
+
+{{ define "flame" }}
+{{ template "top" . }}
+<style>
+  .ae-flame { font: 11px monospace; margin: 1em 0; }
+  .ae-flame-node { display: flex; flex-direction: column-reverse; box-sizing: border-box; min-width: 0; }
+  .ae-flame-children { display: flex; align-items: flex-end; }
+  .ae-flame-label { display: block; height: 16px; line-height: 16px; padding: 0 2px;
+    overflow: hidden; white-space: nowrap; text-overflow: ellipsis;
+    border: 1px solid #fff; background: #f8b45e; color: #000; text-decoration: none; }
+  .ae-flame-rpc { background: #e8684a; }
+</style>
+{{ template "body" . }}
+
+<h2>RPC Flame Graph</h2>
+<form action="flame">
+  {{ template "filters" .Filter }}
+  <label><input type="radio" name="by" value=""{{ if not .ByCount }} checked{{ end }}> By time</label>
+  <label><input type="radio" name="by" value="count"{{ if .ByCount }} checked{{ end }}> By count</label>
+  <button>Show</button>
+</form>
+<p>
+  {{.Requests}} recent requests.
+  {{ range $i, $c := .Crumbs }}{{ if $i }} &gt; {{ end }}<a href="{{$c.Link}}">{{$c.Name}}</a>{{ end }}
+</p>
+
+{{ if .Focus.Count }}
+<div class="ae-flame">
+  {{ template "flamenode" .Focus }}
+</div>
+{{ else }}
+<p>No RPC stacks recorded.</p>
+{{ end }}
+
+{{ template "end" . }}
+{{ template "footer" . }}
+{{ end }}
+`
+
+const htmlDiff = `
+{{ define "diffrows" }}
+  <table cellspacing="0" cellpadding="0" class="ae-table ae-stripe">
+    <thead>
+      <tr>
+        <th>{{ index . 0 }}</th>
+        <th>Count A</th>
+        <th>Count B</th>
+        <th>Change</th>
+        <th>Avg real A</th>
+        <th>Avg real B</th>
+        <th>Change</th>
+        <th>Avg cost A</th>
+        <th>Avg cost B</th>
+        <th>Change</th>
+      </tr>
+    </thead>
+    <tbody>
+      {{ range $r := index . 1 }}
+      <tr{{ if $r.Regressed }} class="ae-diff-regressed"{{ end }}>
+        <td>{{$r.Name}}</td>
+        <td>{{$r.A.Count}}</td>
+        <td>{{$r.B.Count}}</td>
+        <td>{{$r.CountChange}}</td>
+        <td>{{$r.A.AvgDuration}}</td>
+        <td>{{$r.B.AvgDuration}}</td>
+        <td>{{$r.LatencyChange}}</td>
+        <td>{{ printf "%.0f" $r.A.AvgCost }}</td>
+        <td>{{ printf "%.0f" $r.B.AvgCost }}</td>
+        <td>{{$r.CostChange}}</td>
+      </tr>
+      {{ end }}
+    </tbody>
+  </table>
+{{ end }}
+
+{{ define "diff" }}
+{{ template "top" . }}
+<style>
+  .ae-diff-regressed td { background-color: #fdd; }
+</style>
+{{ template "body" . }}
+
+<h2>Compare Time Windows</h2>
+<form action="diff">
+  <label>A: <input type="datetime-local" name="a_from" value="{{.A.FromValue}}">
+    to <input type="datetime-local" name="a_to" value="{{.A.ToValue}}"></label>
+  <label>B: <input type="datetime-local" name="b_from" value="{{.B.FromValue}}">
+    to <input type="datetime-local" name="b_to" value="{{.B.ToValue}}"></label>
+  (UTC)
+  <button>Compare</button>
+</form>
+<p>
+  Highlighted rows got over {{ printf "%.0f" .Threshold }}% slower or more
+  expensive on average from A to B.
+</p>
+
+{{ if or .Paths .RPCs }}
+<h2>Paths</h2>
+{{ template "diffrows" (list "Path" .Paths) }}
+<h2>RPCs</h2>
+{{ template "diffrows" (list "RPC" .RPCs) }}
+{{ else }}
+<p>No requests were recorded in either window.</p>
+{{ end }}
+
+{{ template "end" . }}
+{{ template "footer" . }}
+{{ end }}
+`
+
+const htmlLive = `
+{{ define "live" }}
+{{ template "top" . }}
+{{ template "body" . }}
+
+<h2>Live Requests</h2>
+<form action="live">
+  {{ template "filters" .Filter }}
+  <button>Filter</button>
+</form>
+<p id="ae-live-status">Connecting...</p>
+<table cellspacing="0" cellpadding="0" class="ae-table ae-stripe">
+  <thead>
+    <tr>
+      <th>Start</th>
+      <th>Request</th>
+      <th>Status</th>
+      <th>real</th>
+      <th>RPCs</th>
+      <th>Cost</th>
+    </tr>
+  </thead>
+  <tbody id="ae-live-requests"></tbody>
+</table>
+
+{{ template "end" . }}
+<script>
+(function() {
+  var body = document.getElementById('ae-live-requests');
+  var status = document.getElementById('ae-live-status');
+  var source = new EventSource('stream?{{.Filter.Params}}');
+  source.onopen = function() { status.textContent = 'Watching for new requests.'; };
+  source.onerror = function() { status.textContent = 'Reconnecting...'; };
+  source.onmessage = function(e) {
+    var r = JSON.parse(e.data);
+    var tr = document.createElement('tr');
+    var a = document.createElement('a');
+    a.href = 'details?time=' + r.id;
+    a.textContent = r.method + ' ' + r.path + (r.query ? '?' + r.query : '');
+    var cells = [new Date(r.start).toLocaleTimeString(), a, r.status, r.real, r.rpcs, r.cost];
+    for (var i = 0; i < cells.length; i++) {
+      var td = document.createElement('td');
+      if (cells[i] instanceof Node) {
+        td.appendChild(cells[i]);
+      } else {
+        td.textContent = cells[i];
+      }
+      tr.appendChild(td);
+    }
+    body.insertBefore(tr, body.firstChild);
+    while (body.rows.length > 500) {
+      body.deleteRow(body.rows.length - 1);
+    }
+  };
+})();
+</script>
+{{ template "footer" . }}
+{{ end }}
+`
+
+const htmlCompare = `
+{{ define "comparerecord" }}
+<td style="vertical-align: top; width: 50%">
+  <a href="details?time={{.Record.ID}}">{{.Record.Start}}</a><br>
+  "{{.Record.Method}} {{.Record.Path}}{{ if .Record.Query }}?{{.Record.Query}}{{ end }}" {{.Record.Status}}<br>
+  real={{.Record.Duration}} RPCs={{ len .Record.RPCStats }} cost={{.Record.Cost}}<br>
+  RPCs running for {{.Busy}}{{ if .Concurrent }}, {{.Concurrent}} concurrently{{ end }}
+</td>
+{{ end }}
+
+{{ define "compare" }}
+{{ template "top" . }}
+<style>
+  .ae-diff-regressed td { background-color: #fdd; }
+</style>
+{{ template "body" . }}
+
+<h2>Compare Requests</h2>
+<table cellspacing="0" cellpadding="0" class="ae-table">
+  <thead>
+    <tr>
+      <th>A</th>
+      <th>B</th>
+    </tr>
+  </thead>
+  <tbody>
+    <tr>
+      {{ template "comparerecord" .A }}
+      {{ template "comparerecord" .B }}
+    </tr>
+  </tbody>
+</table>
+
+<h2>RPCs</h2>
+{{ template "diffrows" (list "RPC" .RPCs) }}
+
+{{ template "end" . }}
+{{ template "footer" . }}
+{{ end }}
+`
+
+const htmlSlowest = `
+{{ define "slowrequests" }}
+<table cellspacing="0" cellpadding="0" class="ae-table ae-stripe">
+  <thead>
+    <tr>
+      <th>Request</th>
+      <th>Status</th>
+      <th>real</th>
+      <th>RPCs</th>
+      <th>Cost</th>
+    </tr>
+  </thead>
+  <tbody>
+    {{ range . }}
+    <tr>
+      <td>
+        <a href="details?time={{.ID}}">
+          {{.Start}}
+          "{{.Method}} {{.Path}}{{ if .Query }}?{{.Query}}{{ end }}"
+        </a>
+      </td>
+      <td>{{.Status}}</td>
+      <td>{{.Duration}}</td>
+      <td>{{ len .RPCStats }}</td>
+      <td>{{.Cost}}</td>
+    </tr>
+    {{ end }}
+  </tbody>
+</table>
+{{ end }}
+
+{{ define "slowest" }}
+{{ template "top" . }}
+{{ template "body" . }}
+
+<h2>Slowest Requests</h2>
+<form action="slowest">
+  {{ template "filters" .Filter }}
+  <label>Top: <input name="n" value="{{.N}}" size="4"></label>
+  <button>Filter</button>
+  <a href="api/slowest?{{.Filter.Params}}">JSON</a>
+</form>
+{{ if .Requests }}
+{{ template "slowrequests" .Requests }}
+{{ range .Paths }}
+<h3>{{.Path}} ({{.Count}} requests)</h3>
+{{ template "slowrequests" .Requests }}
+{{ end }}
+{{ else }}
+<p>No requests recorded.</p>
+{{ end }}
+
+{{ template "end" . }}
+{{ template "footer" . }}
+{{ end }}
+`
+
+const htmlUsers = `
+{{ define "users" }}
+{{ template "top" . }}
+{{ template "body" . }}
+
+<h2>Requests by User</h2>
+<form action="users">
+  {{ template "filters" .Filter }}
+  <button>Filter</button>
+</form>
+{{ if .Users }}
+<table cellspacing="0" cellpadding="0" class="ae-table ae-stripe">
+  <thead>
+    <tr>
+      <th>User</th>
+      <th>#Requests</th>
+      <th>Errors</th>
+      <th>#RPCs</th>
+      <th>Cost</th>
+      <th>Total real</th>
+      <th>Avg real</th>
+      <th>p95</th>
+    </tr>
+  </thead>
+  <tbody>
+    {{ range .Users }}
+    <tr>
+      <td>
+        {{ if .User }}
+        <a href=".?user={{.User}}">{{.User}}</a>{{ if .Admin }} (admin){{ end }}
+        {{ else }}
+        (not signed in)
+        {{ end }}
+      </td>
+      <td>{{.Requests}}</td>
+      <td>{{.Errors}}</td>
+      <td>{{.RPCs}}</td>
+      <td>{{.Cost}}</td>
+      <td>{{.Duration}}</td>
+      <td>{{.AvgDuration}}</td>
+      <td>{{.P95}}</td>
+    </tr>
+    {{ end }}
+  </tbody>
+</table>
+{{ else }}
+<p>No requests recorded.</p>
+{{ end }}
+
+{{ template "end" . }}
+{{ template "footer" . }}
+{{ end }}
+`
+
+const htmlTrends = `
+{{ define "trends" }}
+{{ template "top" . }}
+{{ template "body" . }}
+
+<h2>Trends{{ if .Path }} of {{.Path}}{{ end }}</h2>
+<form action="trends">
+  <label>Days: <input name="days" value="{{.Days}}" size="3"></label>
+  <label>Path: <input name="path" value="{{.Path}}" size="20"></label>
+  <button>Show</button>
+</form>
+{{ if .Points }}
+<table cellspacing="0" cellpadding="0" class="ae-table ae-stripe">
+  <thead>
+    <tr>
+      <th>Hour (UTC)</th>
+      <th>#Requests</th>
+      <th>Avg real</th>
+      <th>Avg cost</th>
+      <th style="width: 40%"></th>
+    </tr>
+  </thead>
+  <tbody>
+    {{ range .Points }}
+    <tr>
+      <td>{{ .Start.Format "2006-01-02 15:04" }}</td>
+      <td>{{.Count}}</td>
+      <td>{{.AvgDuration}}</td>
+      <td>{{ printf "%.0f" .AvgCost }}</td>
+      <td><div style="background-color: #7777ff; height: 1em; width: {{ printf "%.1f" .Percent }}%"></div></td>
+    </tr>
+    {{ end }}
+  </tbody>
+</table>
+
+<h2>Paths</h2>
+<table cellspacing="0" cellpadding="0" class="ae-table ae-stripe">
+  <thead>
+    <tr>
+      <th>Path</th>
+      <th>#Requests</th>
+      <th>Avg real</th>
+      <th>Avg cost</th>
+    </tr>
+  </thead>
+  <tbody>
+    {{ range .Paths }}
+    <tr>
+      <td><a href="trends?days={{$.Days}}&amp;path={{.Path}}">{{.Path}}</a></td>
+      <td>{{.Count}}</td>
+      <td>{{.AvgDuration}}</td>
+      <td>{{ printf "%.0f" .AvgCost }}</td>
+    </tr>
+    {{ end }}
+  </tbody>
+</table>
+{{ else }}
+<p>No rollups stored in the last {{.Days}} days. Rollups are stored by the rollup job.</p>
+{{ end }}
+
+{{ template "end" . }}
+{{ template "footer" . }}
+{{ end }}
+`
+
+const htmlHeatmap = `
+{{ define "heatmap" }}
+{{ template "top" . }}
+<style>
+  .ae-heatmap td { width: 14px; height: 14px; padding: 0; border: 1px solid #fff; }
+  .ae-heatmap th { font-weight: normal; font-size: 80%; color: grey; text-align: right; padding-right: 4px; white-space: nowrap; }
+  .ae-heatmap-times th { text-align: left; }
+</style>
+{{ template "body" . }}
+
+<h2>Latency Heatmap{{ if .Path }} of {{.Path}}{{ end }}</h2>
+<form action="heatmap">
+  <label>Hours: <input name="hours" value="{{.Hours}}" size="3"></label>
+  <label>Path: <input name="path" value="{{.Path}}" size="20"></label>
+  <button>Show</button>
+</form>
+<p>Requests by latency over time, in columns of {{.Width}}. Darker cells hold more requests.</p>
+<table cellspacing="0" cellpadding="0" class="ae-heatmap">
+  {{ range .Rows }}
+  <tr>
+    <th>{{.Label}}</th>
+    {{ range .Cells }}
+    <td style="background-color: rgba(0, 0, 200, {{ printf "%.2f" .Opacity }})" title="{{.Start.Format "2006-01-02 15:04"}}: {{.Count}} requests"></td>
+    {{ end }}
+  </tr>
+  {{ end }}
+  <tr class="ae-heatmap-times">
+    <th></th>
+    {{ range .Times }}
+    {{ if . }}<th colspan="6">{{.}}</th>{{ end }}
+    {{ end }}
+  </tr>
+</table>
+
+{{ template "end" . }}
+{{ template "footer" . }}
+{{ end }}
+`
+
+const htmlServices = `
+{{ define "services" }}
+{{ template "top" . }}
+{{ template "body" . }}
+
+<h2>Requests by Service</h2>
+<form action="services">
+  {{ template "filters" .Filter }}
+  <button>Filter</button>
+</form>
+{{ range .Errors }}
+<p><b>Could not load</b> {{.}}</p>
+{{ end }}
+{{ if .Services }}
+<table cellspacing="0" cellpadding="0" class="ae-table ae-stripe">
+  <thead>
+    <tr>
+      <th>Service</th>
+      <th>Version</th>
+      <th>#Requests</th>
+      <th>Errors</th>
+      <th>Cost</th>
+      <th>Avg real</th>
+      <th>p95</th>
+    </tr>
+  </thead>
+  <tbody>
+    {{ range .Services }}
+    <tr>
+      <td><a href="{{ if .URL }}{{.URL}}{{ else }}.{{ end }}">{{ or .Service "(this service)" }}</a></td>
+      <td>{{.Version}}</td>
+      <td>{{.Requests}}</td>
+      <td>{{.Errors}}</td>
+      <td title="{{dollars .Cost}}">{{.Cost}}</td>
+      <td>{{.AvgDuration}}</td>
+      <td>{{.P95}}</td>
+    </tr>
+    {{ end }}
+  </tbody>
+</table>
+
+<h2>Recent Requests</h2>
+<table cellspacing="0" cellpadding="0" class="ae-table ae-stripe">
+  <thead>
+    <tr>
+      <th>Start</th>
+      <th>Service</th>
+      <th>Request</th>
+      <th>Status</th>
+      <th>Real</th>
+    </tr>
+  </thead>
+  <tbody>
+    {{ range .Recent }}
+    <tr>
+      <td>{{.Start}}</td>
+      <td>{{.Service}}</td>
+      <td><a href="{{.URL}}details?time={{.ID}}">{{.Method}} {{.Path}}</a></td>
+      <td>{{.Status}}</td>
+      <td>{{.Duration}}</td>
+    </tr>
+    {{ end }}
+  </tbody>
+</table>
+{{ else }}
+<p>No requests recorded.</p>
+{{ end }}
+
+{{ template "end" . }}
+{{ template "footer" . }}
+{{ end }}
+`
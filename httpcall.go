@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// HTTPCall describes an outbound HTTP request made through the urlfetch
+// service.
+type HTTPCall struct {
+	Method         string
+	URL            string
+	Status         int
+	RequestHeader  http.Header
+	ResponseHeader http.Header
+	RequestSize    int
+	ResponseSize   int
+}
+
+// getHTTPCall extracts the HTTP request and response from the messages
+// of a urlfetch.Fetch call.
+func getHTTPCall(in, out proto.Message) *HTTPCall {
+	req := reflect.Indirect(reflect.ValueOf(in))
+	resp := reflect.Indirect(reflect.ValueOf(out))
+	if req.Kind() != reflect.Struct || resp.Kind() != reflect.Struct {
+		return nil
+	}
+	return &HTTPCall{
+		Method:         reflectString(req.FieldByName("Method")),
+		URL:            reflectString(req.FieldByName("Url")),
+		Status:         int(reflectInt(resp.FieldByName("StatusCode"))),
+		RequestHeader:  reflectHeader(req.FieldByName("Header")),
+		ResponseHeader: reflectHeader(resp.FieldByName("Header")),
+		RequestSize:    reflectLen(req.FieldByName("Payload")),
+		ResponseSize:   reflectLen(resp.FieldByName("Content")),
+	}
+}
+
+// reflectString returns the value of a string or enum field, which may
+// be a pointer.
+func reflectString(v reflect.Value) string {
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return ""
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	v = reflect.Indirect(v)
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+	return ""
+}
+
+// reflectInt returns the value of an integer field, which may be a
+// pointer.
+func reflectInt(v reflect.Value) int64 {
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return 0
+	}
+	v = reflect.Indirect(v)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	}
+	return 0
+}
+
+func reflectLen(v reflect.Value) int {
+	switch v.Kind() {
+	case reflect.Slice, reflect.String:
+		return v.Len()
+	}
+	return 0
+}
+
+// reflectHeader converts a repeated header field, with Key and Value
+// fields, to an http.Header.
+func reflectHeader(v reflect.Value) http.Header {
+	if v.Kind() != reflect.Slice {
+		return nil
+	}
+	h := make(http.Header, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		e := reflect.Indirect(v.Index(i))
+		if e.Kind() != reflect.Struct {
+			continue
+		}
+		h.Add(reflectString(e.FieldByName("Key")), reflectString(e.FieldByName("Value")))
+	}
+	return h
+}
@@ -0,0 +1,769 @@
+// Go types of the messages of record.proto, in the form generated by
+// protoc-gen-go. Regenerate with go generate.
+// source: record.proto
+
+package recordpb
+
+import proto "github.com/golang/protobuf/proto"
+
+type Record struct {
+	User              *string       `protobuf:"bytes,1,opt,name=user" json:"user,omitempty"`
+	Admin             *bool         `protobuf:"varint,2,opt,name=admin" json:"admin,omitempty"`
+	Method            *string       `protobuf:"bytes,3,opt,name=method" json:"method,omitempty"`
+	Path              *string       `protobuf:"bytes,4,opt,name=path" json:"path,omitempty"`
+	Query             *string       `protobuf:"bytes,5,opt,name=query" json:"query,omitempty"`
+	Status            *int32        `protobuf:"varint,6,opt,name=status" json:"status,omitempty"`
+	ContentType       *string       `protobuf:"bytes,7,opt,name=content_type,json=contentType" json:"content_type,omitempty"`
+	CacheControl      *string       `protobuf:"bytes,8,opt,name=cache_control,json=cacheControl" json:"cache_control,omitempty"`
+	Age               *string       `protobuf:"bytes,9,opt,name=age" json:"age,omitempty"`
+	Cost              *int64        `protobuf:"varint,10,opt,name=cost" json:"cost,omitempty"`
+	Start             *int64        `protobuf:"varint,11,opt,name=start" json:"start,omitempty"`
+	Duration          *int64        `protobuf:"varint,12,opt,name=duration" json:"duration,omitempty"`
+	Overhead          *int64        `protobuf:"varint,13,opt,name=overhead" json:"overhead,omitempty"`
+	Rpcs              []*RPC        `protobuf:"bytes,14,rep,name=rpcs" json:"rpcs,omitempty"`
+	CloudTraceContext *string       `protobuf:"bytes,15,opt,name=cloud_trace_context,json=cloudTraceContext" json:"cloud_trace_context,omitempty"`
+	TraceId           *string       `protobuf:"bytes,16,opt,name=trace_id,json=traceId" json:"trace_id,omitempty"`
+	SpanId            *string       `protobuf:"bytes,17,opt,name=span_id,json=spanId" json:"span_id,omitempty"`
+	TraceState        *string       `protobuf:"bytes,18,opt,name=trace_state,json=traceState" json:"trace_state,omitempty"`
+	Header            []*Header     `protobuf:"bytes,19,rep,name=header" json:"header,omitempty"`
+	Route             *string       `protobuf:"bytes,20,opt,name=route" json:"route,omitempty"`
+	ReplayOf          *int64        `protobuf:"varint,21,opt,name=replay_of,json=replayOf" json:"replay_of,omitempty"`
+	Annotations       []*Annotation `protobuf:"bytes,22,rep,name=annotations" json:"annotations,omitempty"`
+	Events            []*Event      `protobuf:"bytes,23,rep,name=events" json:"events,omitempty"`
+	ResponseSize      *int64        `protobuf:"varint,24,opt,name=response_size,json=responseSize" json:"response_size,omitempty"`
+	Memory            *Memory       `protobuf:"bytes,25,opt,name=memory" json:"memory,omitempty"`
+	GoroutinesStart   *int32        `protobuf:"varint,26,opt,name=goroutines_start,json=goroutinesStart" json:"goroutines_start,omitempty"`
+	GoroutinesEnd     *int32        `protobuf:"varint,27,opt,name=goroutines_end,json=goroutinesEnd" json:"goroutines_end,omitempty"`
+	Parent            *int64        `protobuf:"varint,28,opt,name=parent" json:"parent,omitempty"`
+	Panic             *string       `protobuf:"bytes,29,opt,name=panic" json:"panic,omitempty"`
+	PanicStack        *string       `protobuf:"bytes,30,opt,name=panic_stack,json=panicStack" json:"panic_stack,omitempty"`
+	Logs              []*Log        `protobuf:"bytes,31,rep,name=logs" json:"logs,omitempty"`
+	Body              []byte        `protobuf:"bytes,32,opt,name=body" json:"body,omitempty"`
+	BodyTruncated     *bool         `protobuf:"varint,33,opt,name=body_truncated,json=bodyTruncated" json:"body_truncated,omitempty"`
+	RequestSize       *int64        `protobuf:"varint,34,opt,name=request_size,json=requestSize" json:"request_size,omitempty"`
+	Namespace         *string       `protobuf:"bytes,35,opt,name=namespace" json:"namespace,omitempty"`
+	Service           *string       `protobuf:"bytes,36,opt,name=service" json:"service,omitempty"`
+	Version           *string       `protobuf:"bytes,37,opt,name=version" json:"version,omitempty"`
+	Class             *string       `protobuf:"bytes,38,opt,name=class" json:"class,omitempty"`
+	// Only in part records: the stored and uncompressed sizes of the full
+	// record.
+	RecordSize    *int64 `protobuf:"varint,39,opt,name=record_size,json=recordSize" json:"record_size,omitempty"`
+	RecordRawSize *int64 `protobuf:"varint,40,opt,name=record_raw_size,json=recordRawSize" json:"record_raw_size,omitempty"`
+	// RPCs not recorded, beyond appstats.WithMaxRPCs, and their total duration.
+	DroppedRpcs      *int32 `protobuf:"varint,41,opt,name=dropped_rpcs,json=droppedRpcs" json:"dropped_rpcs,omitempty"`
+	DroppedDuration  *int64 `protobuf:"varint,42,opt,name=dropped_duration,json=droppedDuration" json:"dropped_duration,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *Record) Reset()         { *m = Record{} }
+func (m *Record) String() string { return proto.CompactTextString(m) }
+func (*Record) ProtoMessage()    {}
+
+func (m *Record) GetUser() string {
+	if m != nil && m.User != nil {
+		return *m.User
+	}
+	return ""
+}
+
+func (m *Record) GetAdmin() bool {
+	if m != nil && m.Admin != nil {
+		return *m.Admin
+	}
+	return false
+}
+
+func (m *Record) GetMethod() string {
+	if m != nil && m.Method != nil {
+		return *m.Method
+	}
+	return ""
+}
+
+func (m *Record) GetPath() string {
+	if m != nil && m.Path != nil {
+		return *m.Path
+	}
+	return ""
+}
+
+func (m *Record) GetQuery() string {
+	if m != nil && m.Query != nil {
+		return *m.Query
+	}
+	return ""
+}
+
+func (m *Record) GetStatus() int32 {
+	if m != nil && m.Status != nil {
+		return *m.Status
+	}
+	return 0
+}
+
+func (m *Record) GetContentType() string {
+	if m != nil && m.ContentType != nil {
+		return *m.ContentType
+	}
+	return ""
+}
+
+func (m *Record) GetCacheControl() string {
+	if m != nil && m.CacheControl != nil {
+		return *m.CacheControl
+	}
+	return ""
+}
+
+func (m *Record) GetAge() string {
+	if m != nil && m.Age != nil {
+		return *m.Age
+	}
+	return ""
+}
+
+func (m *Record) GetCost() int64 {
+	if m != nil && m.Cost != nil {
+		return *m.Cost
+	}
+	return 0
+}
+
+func (m *Record) GetStart() int64 {
+	if m != nil && m.Start != nil {
+		return *m.Start
+	}
+	return 0
+}
+
+func (m *Record) GetDuration() int64 {
+	if m != nil && m.Duration != nil {
+		return *m.Duration
+	}
+	return 0
+}
+
+func (m *Record) GetOverhead() int64 {
+	if m != nil && m.Overhead != nil {
+		return *m.Overhead
+	}
+	return 0
+}
+
+func (m *Record) GetRpcs() []*RPC {
+	if m != nil {
+		return m.Rpcs
+	}
+	return nil
+}
+
+func (m *Record) GetCloudTraceContext() string {
+	if m != nil && m.CloudTraceContext != nil {
+		return *m.CloudTraceContext
+	}
+	return ""
+}
+
+func (m *Record) GetTraceId() string {
+	if m != nil && m.TraceId != nil {
+		return *m.TraceId
+	}
+	return ""
+}
+
+func (m *Record) GetSpanId() string {
+	if m != nil && m.SpanId != nil {
+		return *m.SpanId
+	}
+	return ""
+}
+
+func (m *Record) GetTraceState() string {
+	if m != nil && m.TraceState != nil {
+		return *m.TraceState
+	}
+	return ""
+}
+
+func (m *Record) GetHeader() []*Header {
+	if m != nil {
+		return m.Header
+	}
+	return nil
+}
+
+func (m *Record) GetRoute() string {
+	if m != nil && m.Route != nil {
+		return *m.Route
+	}
+	return ""
+}
+
+func (m *Record) GetReplayOf() int64 {
+	if m != nil && m.ReplayOf != nil {
+		return *m.ReplayOf
+	}
+	return 0
+}
+
+func (m *Record) GetAnnotations() []*Annotation {
+	if m != nil {
+		return m.Annotations
+	}
+	return nil
+}
+
+func (m *Record) GetEvents() []*Event {
+	if m != nil {
+		return m.Events
+	}
+	return nil
+}
+
+func (m *Record) GetResponseSize() int64 {
+	if m != nil && m.ResponseSize != nil {
+		return *m.ResponseSize
+	}
+	return 0
+}
+
+func (m *Record) GetMemory() *Memory {
+	if m != nil {
+		return m.Memory
+	}
+	return nil
+}
+
+func (m *Record) GetGoroutinesStart() int32 {
+	if m != nil && m.GoroutinesStart != nil {
+		return *m.GoroutinesStart
+	}
+	return 0
+}
+
+func (m *Record) GetGoroutinesEnd() int32 {
+	if m != nil && m.GoroutinesEnd != nil {
+		return *m.GoroutinesEnd
+	}
+	return 0
+}
+
+func (m *Record) GetParent() int64 {
+	if m != nil && m.Parent != nil {
+		return *m.Parent
+	}
+	return 0
+}
+
+func (m *Record) GetPanic() string {
+	if m != nil && m.Panic != nil {
+		return *m.Panic
+	}
+	return ""
+}
+
+func (m *Record) GetPanicStack() string {
+	if m != nil && m.PanicStack != nil {
+		return *m.PanicStack
+	}
+	return ""
+}
+
+func (m *Record) GetLogs() []*Log {
+	if m != nil {
+		return m.Logs
+	}
+	return nil
+}
+
+func (m *Record) GetBody() []byte {
+	if m != nil {
+		return m.Body
+	}
+	return nil
+}
+
+func (m *Record) GetBodyTruncated() bool {
+	if m != nil && m.BodyTruncated != nil {
+		return *m.BodyTruncated
+	}
+	return false
+}
+
+func (m *Record) GetRequestSize() int64 {
+	if m != nil && m.RequestSize != nil {
+		return *m.RequestSize
+	}
+	return 0
+}
+
+func (m *Record) GetNamespace() string {
+	if m != nil && m.Namespace != nil {
+		return *m.Namespace
+	}
+	return ""
+}
+
+func (m *Record) GetService() string {
+	if m != nil && m.Service != nil {
+		return *m.Service
+	}
+	return ""
+}
+
+func (m *Record) GetVersion() string {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return ""
+}
+
+func (m *Record) GetClass() string {
+	if m != nil && m.Class != nil {
+		return *m.Class
+	}
+	return ""
+}
+
+func (m *Record) GetRecordSize() int64 {
+	if m != nil && m.RecordSize != nil {
+		return *m.RecordSize
+	}
+	return 0
+}
+
+func (m *Record) GetRecordRawSize() int64 {
+	if m != nil && m.RecordRawSize != nil {
+		return *m.RecordRawSize
+	}
+	return 0
+}
+
+func (m *Record) GetDroppedRpcs() int32 {
+	if m != nil && m.DroppedRpcs != nil {
+		return *m.DroppedRpcs
+	}
+	return 0
+}
+
+func (m *Record) GetDroppedDuration() int64 {
+	if m != nil && m.DroppedDuration != nil {
+		return *m.DroppedDuration
+	}
+	return 0
+}
+
+type Memory struct {
+	Mallocs    *uint64 `protobuf:"varint,1,opt,name=mallocs" json:"mallocs,omitempty"`
+	TotalAlloc *uint64 `protobuf:"varint,2,opt,name=total_alloc,json=totalAlloc" json:"total_alloc,omitempty"`
+	HeapGrowth *int64  `protobuf:"varint,3,opt,name=heap_growth,json=heapGrowth" json:"heap_growth,omitempty"`
+	NumGc      *uint32 `protobuf:"varint,4,opt,name=num_gc,json=numGc" json:"num_gc,omitempty"`
+	// Nanoseconds.
+	GcPause          *int64 `protobuf:"varint,5,opt,name=gc_pause,json=gcPause" json:"gc_pause,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *Memory) Reset()         { *m = Memory{} }
+func (m *Memory) String() string { return proto.CompactTextString(m) }
+func (*Memory) ProtoMessage()    {}
+
+func (m *Memory) GetMallocs() uint64 {
+	if m != nil && m.Mallocs != nil {
+		return *m.Mallocs
+	}
+	return 0
+}
+
+func (m *Memory) GetTotalAlloc() uint64 {
+	if m != nil && m.TotalAlloc != nil {
+		return *m.TotalAlloc
+	}
+	return 0
+}
+
+func (m *Memory) GetHeapGrowth() int64 {
+	if m != nil && m.HeapGrowth != nil {
+		return *m.HeapGrowth
+	}
+	return 0
+}
+
+func (m *Memory) GetNumGc() uint32 {
+	if m != nil && m.NumGc != nil {
+		return *m.NumGc
+	}
+	return 0
+}
+
+func (m *Memory) GetGcPause() int64 {
+	if m != nil && m.GcPause != nil {
+		return *m.GcPause
+	}
+	return 0
+}
+
+type Annotation struct {
+	Key              *string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value            *string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Annotation) Reset()         { *m = Annotation{} }
+func (m *Annotation) String() string { return proto.CompactTextString(m) }
+func (*Annotation) ProtoMessage()    {}
+
+func (m *Annotation) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+func (m *Annotation) GetValue() string {
+	if m != nil && m.Value != nil {
+		return *m.Value
+	}
+	return ""
+}
+
+type Event struct {
+	// Time since the start of the request, in nanoseconds.
+	Offset           *int64  `protobuf:"varint,1,opt,name=offset" json:"offset,omitempty"`
+	Message          *string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
+
+func (m *Event) GetOffset() int64 {
+	if m != nil && m.Offset != nil {
+		return *m.Offset
+	}
+	return 0
+}
+
+func (m *Event) GetMessage() string {
+	if m != nil && m.Message != nil {
+		return *m.Message
+	}
+	return ""
+}
+
+type Log struct {
+	// Time since the start of the request, in nanoseconds.
+	Offset           *int64  `protobuf:"varint,1,opt,name=offset" json:"offset,omitempty"`
+	Level            *string `protobuf:"bytes,2,opt,name=level" json:"level,omitempty"`
+	Message          *string `protobuf:"bytes,3,opt,name=message" json:"message,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Log) Reset()         { *m = Log{} }
+func (m *Log) String() string { return proto.CompactTextString(m) }
+func (*Log) ProtoMessage()    {}
+
+func (m *Log) GetOffset() int64 {
+	if m != nil && m.Offset != nil {
+		return *m.Offset
+	}
+	return 0
+}
+
+func (m *Log) GetLevel() string {
+	if m != nil && m.Level != nil {
+		return *m.Level
+	}
+	return ""
+}
+
+func (m *Log) GetMessage() string {
+	if m != nil && m.Message != nil {
+		return *m.Message
+	}
+	return ""
+}
+
+type Header struct {
+	Key              *string  `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Values           []string `protobuf:"bytes,2,rep,name=values" json:"values,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *Header) Reset()         { *m = Header{} }
+func (m *Header) String() string { return proto.CompactTextString(m) }
+func (*Header) ProtoMessage()    {}
+
+func (m *Header) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+func (m *Header) GetValues() []string {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
+type RPC struct {
+	Service       *string   `protobuf:"bytes,1,opt,name=service" json:"service,omitempty"`
+	Method        *string   `protobuf:"bytes,2,opt,name=method" json:"method,omitempty"`
+	Start         *int64    `protobuf:"varint,3,opt,name=start" json:"start,omitempty"`
+	Offset        *int64    `protobuf:"varint,4,opt,name=offset" json:"offset,omitempty"`
+	Duration      *int64    `protobuf:"varint,5,opt,name=duration" json:"duration,omitempty"`
+	ExtraDuration *int64    `protobuf:"varint,6,opt,name=extra_duration,json=extraDuration" json:"extra_duration,omitempty"`
+	StackData     *string   `protobuf:"bytes,7,opt,name=stack_data,json=stackData" json:"stack_data,omitempty"`
+	Frames        []*Frame  `protobuf:"bytes,8,rep,name=frames" json:"frames,omitempty"`
+	In            *string   `protobuf:"bytes,9,opt,name=in" json:"in,omitempty"`
+	Out           *string   `protobuf:"bytes,10,opt,name=out" json:"out,omitempty"`
+	Cost          *int64    `protobuf:"varint,11,opt,name=cost" json:"cost,omitempty"`
+	Pending       *bool     `protobuf:"varint,12,opt,name=pending" json:"pending,omitempty"`
+	Http          *HTTPCall `protobuf:"bytes,13,opt,name=http" json:"http,omitempty"`
+	// Keys found and not found by memcache Get calls.
+	Hits   *int32 `protobuf:"varint,14,opt,name=hits" json:"hits,omitempty"`
+	Misses *int32 `protobuf:"varint,15,opt,name=misses" json:"misses,omitempty"`
+	// Entities read and written, and index entries written, by datastore
+	// calls.
+	Reads       *int32 `protobuf:"varint,16,opt,name=reads" json:"reads,omitempty"`
+	Writes      *int32 `protobuf:"varint,17,opt,name=writes" json:"writes,omitempty"`
+	IndexWrites *int32 `protobuf:"varint,18,opt,name=index_writes,json=indexWrites" json:"index_writes,omitempty"`
+	// Whether in or out were truncated.
+	Truncated        *bool  `protobuf:"varint,19,opt,name=truncated" json:"truncated,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *RPC) Reset()         { *m = RPC{} }
+func (m *RPC) String() string { return proto.CompactTextString(m) }
+func (*RPC) ProtoMessage()    {}
+
+func (m *RPC) GetService() string {
+	if m != nil && m.Service != nil {
+		return *m.Service
+	}
+	return ""
+}
+
+func (m *RPC) GetMethod() string {
+	if m != nil && m.Method != nil {
+		return *m.Method
+	}
+	return ""
+}
+
+func (m *RPC) GetStart() int64 {
+	if m != nil && m.Start != nil {
+		return *m.Start
+	}
+	return 0
+}
+
+func (m *RPC) GetOffset() int64 {
+	if m != nil && m.Offset != nil {
+		return *m.Offset
+	}
+	return 0
+}
+
+func (m *RPC) GetDuration() int64 {
+	if m != nil && m.Duration != nil {
+		return *m.Duration
+	}
+	return 0
+}
+
+func (m *RPC) GetExtraDuration() int64 {
+	if m != nil && m.ExtraDuration != nil {
+		return *m.ExtraDuration
+	}
+	return 0
+}
+
+func (m *RPC) GetStackData() string {
+	if m != nil && m.StackData != nil {
+		return *m.StackData
+	}
+	return ""
+}
+
+func (m *RPC) GetFrames() []*Frame {
+	if m != nil {
+		return m.Frames
+	}
+	return nil
+}
+
+func (m *RPC) GetIn() string {
+	if m != nil && m.In != nil {
+		return *m.In
+	}
+	return ""
+}
+
+func (m *RPC) GetOut() string {
+	if m != nil && m.Out != nil {
+		return *m.Out
+	}
+	return ""
+}
+
+func (m *RPC) GetCost() int64 {
+	if m != nil && m.Cost != nil {
+		return *m.Cost
+	}
+	return 0
+}
+
+func (m *RPC) GetPending() bool {
+	if m != nil && m.Pending != nil {
+		return *m.Pending
+	}
+	return false
+}
+
+func (m *RPC) GetHttp() *HTTPCall {
+	if m != nil {
+		return m.Http
+	}
+	return nil
+}
+
+func (m *RPC) GetHits() int32 {
+	if m != nil && m.Hits != nil {
+		return *m.Hits
+	}
+	return 0
+}
+
+func (m *RPC) GetMisses() int32 {
+	if m != nil && m.Misses != nil {
+		return *m.Misses
+	}
+	return 0
+}
+
+func (m *RPC) GetReads() int32 {
+	if m != nil && m.Reads != nil {
+		return *m.Reads
+	}
+	return 0
+}
+
+func (m *RPC) GetWrites() int32 {
+	if m != nil && m.Writes != nil {
+		return *m.Writes
+	}
+	return 0
+}
+
+func (m *RPC) GetIndexWrites() int32 {
+	if m != nil && m.IndexWrites != nil {
+		return *m.IndexWrites
+	}
+	return 0
+}
+
+func (m *RPC) GetTruncated() bool {
+	if m != nil && m.Truncated != nil {
+		return *m.Truncated
+	}
+	return false
+}
+
+type Frame struct {
+	Location         *string `protobuf:"bytes,1,opt,name=location" json:"location,omitempty"`
+	Call             *string `protobuf:"bytes,2,opt,name=call" json:"call,omitempty"`
+	Lineno           *int32  `protobuf:"varint,3,opt,name=lineno" json:"lineno,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Frame) Reset()         { *m = Frame{} }
+func (m *Frame) String() string { return proto.CompactTextString(m) }
+func (*Frame) ProtoMessage()    {}
+
+func (m *Frame) GetLocation() string {
+	if m != nil && m.Location != nil {
+		return *m.Location
+	}
+	return ""
+}
+
+func (m *Frame) GetCall() string {
+	if m != nil && m.Call != nil {
+		return *m.Call
+	}
+	return ""
+}
+
+func (m *Frame) GetLineno() int32 {
+	if m != nil && m.Lineno != nil {
+		return *m.Lineno
+	}
+	return 0
+}
+
+type HTTPCall struct {
+	Method           *string   `protobuf:"bytes,1,opt,name=method" json:"method,omitempty"`
+	Url              *string   `protobuf:"bytes,2,opt,name=url" json:"url,omitempty"`
+	Status           *int32    `protobuf:"varint,3,opt,name=status" json:"status,omitempty"`
+	RequestHeader    []*Header `protobuf:"bytes,4,rep,name=request_header,json=requestHeader" json:"request_header,omitempty"`
+	ResponseHeader   []*Header `protobuf:"bytes,5,rep,name=response_header,json=responseHeader" json:"response_header,omitempty"`
+	RequestSize      *int64    `protobuf:"varint,6,opt,name=request_size,json=requestSize" json:"request_size,omitempty"`
+	ResponseSize     *int64    `protobuf:"varint,7,opt,name=response_size,json=responseSize" json:"response_size,omitempty"`
+	XXX_unrecognized []byte    `json:"-"`
+}
+
+func (m *HTTPCall) Reset()         { *m = HTTPCall{} }
+func (m *HTTPCall) String() string { return proto.CompactTextString(m) }
+func (*HTTPCall) ProtoMessage()    {}
+
+func (m *HTTPCall) GetMethod() string {
+	if m != nil && m.Method != nil {
+		return *m.Method
+	}
+	return ""
+}
+
+func (m *HTTPCall) GetUrl() string {
+	if m != nil && m.Url != nil {
+		return *m.Url
+	}
+	return ""
+}
+
+func (m *HTTPCall) GetStatus() int32 {
+	if m != nil && m.Status != nil {
+		return *m.Status
+	}
+	return 0
+}
+
+func (m *HTTPCall) GetRequestHeader() []*Header {
+	if m != nil {
+		return m.RequestHeader
+	}
+	return nil
+}
+
+func (m *HTTPCall) GetResponseHeader() []*Header {
+	if m != nil {
+		return m.ResponseHeader
+	}
+	return nil
+}
+
+func (m *HTTPCall) GetRequestSize() int64 {
+	if m != nil && m.RequestSize != nil {
+		return *m.RequestSize
+	}
+	return 0
+}
+
+func (m *HTTPCall) GetResponseSize() int64 {
+	if m != nil && m.ResponseSize != nil {
+		return *m.ResponseSize
+	}
+	return 0
+}
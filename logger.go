@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A LogStat is a line logged during a request through its RequestLog.
+type LogStat struct {
+	// Offset is the time of the line since the start of the request.
+	Offset  time.Duration
+	Level   string
+	Message string
+}
+
+// A RequestLog writes to the App Engine log, like the functions of
+// google.golang.org/appengine/log, or to the standard logger off App
+// Engine, and also records the lines with the
+// request if it is recorded, up to MaxLogLines, to be shown on its
+// details page interleaved with its RPCs.
+type RequestLog struct {
+	c context.Context
+}
+
+// Logger returns the log of the request of c:
+//
+//	appstats.Logger(c).Infof("loaded %d items", len(items))
+func Logger(c context.Context) RequestLog {
+	return RequestLog{c}
+}
+
+// Debugf formats its arguments according to the format, analogous to
+// fmt.Printf, and records the text as a log message at Debug level.
+func (l RequestLog) Debugf(format string, args ...interface{}) {
+	l.logf("DEBUG", format, args)
+}
+
+// Infof is like Debugf, but at Info level.
+func (l RequestLog) Infof(format string, args ...interface{}) {
+	l.logf("INFO", format, args)
+}
+
+// Warningf is like Debugf, but at Warning level.
+func (l RequestLog) Warningf(format string, args ...interface{}) {
+	l.logf("WARNING", format, args)
+}
+
+// Errorf is like Debugf, but at Error level.
+func (l RequestLog) Errorf(format string, args ...interface{}) {
+	l.logf("ERROR", format, args)
+}
+
+// Criticalf is like Debugf, but at Critical level.
+func (l RequestLog) Criticalf(format string, args ...interface{}) {
+	l.logf("CRITICAL", format, args)
+}
+
+func (l RequestLog) logf(level, format string, args []interface{}) {
+	logf(l.c, level, format, args...)
+	s := recording(l.c)
+	if s == nil {
+		return
+	}
+	line := LogStat{
+		Offset:  time.Since(s.Start),
+		Level:   level,
+		Message: fmt.Sprintf(format, args...),
+	}
+	s.lock.Lock()
+	if len(s.Logs) < MaxLogLines {
+		s.Logs = append(s.Logs, line)
+	}
+	s.lock.Unlock()
+}
+
+// A logEntry is a line of the log of a request or one of its RPCs, for
+// display.
+type logEntry struct {
+	Offset time.Duration
+	*LogStat
+
+	// RPC is the index of the RPC of the entry, if Call is set.
+	RPC  int
+	Call *RPCStat
+}
+
+// interleaveLog returns the log lines of s with its RPCs, by offset.
+func interleaveLog(s *RequestStats) []logEntry {
+	if len(s.Logs) == 0 {
+		return nil
+	}
+	var entries []logEntry
+	for i := range s.RPCStats {
+		entries = append(entries, logEntry{Offset: s.RPCStats[i].Offset, RPC: i, Call: &s.RPCStats[i]})
+	}
+	for i := range s.Logs {
+		entries = append(entries, logEntry{Offset: s.Logs[i].Offset, LogStat: &s.Logs[i]})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Offset < entries[j].Offset
+	})
+	return entries
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"runtime"
+	"time"
+)
+
+// MemoryStats are the changes in the runtime.MemStats of the instance
+// during a request. They include the allocations of any requests served
+// concurrently.
+type MemoryStats struct {
+	// Mallocs is the number of objects allocated, and TotalAlloc their
+	// size in bytes.
+	Mallocs    uint64
+	TotalAlloc uint64
+	// HeapGrowth is the change in bytes of allocated heap objects.
+	HeapGrowth int64
+	// NumGC is the number of garbage collections completed, and
+	// GCPause their total stop-the-world pause.
+	NumGC   uint32
+	GCPause time.Duration
+}
+
+// Allocated returns TotalAlloc for display.
+func (m *MemoryStats) Allocated() byteSize {
+	return byteSize(m.TotalAlloc)
+}
+
+// Growth returns HeapGrowth for display.
+func (m *MemoryStats) Growth() string {
+	if m.HeapGrowth < 0 {
+		return "-" + byteSize(-m.HeapGrowth).String()
+	}
+	return "+" + byteSize(m.HeapGrowth).String()
+}
+
+func readMemStats() *runtime.MemStats {
+	m := &runtime.MemStats{}
+	runtime.ReadMemStats(m)
+	return m
+}
+
+// memoryDelta returns the changes from start to end.
+func memoryDelta(start, end *runtime.MemStats) *MemoryStats {
+	return &MemoryStats{
+		Mallocs:    end.Mallocs - start.Mallocs,
+		TotalAlloc: end.TotalAlloc - start.TotalAlloc,
+		HeapGrowth: int64(end.HeapAlloc) - int64(start.HeapAlloc),
+		NumGC:      end.NumGC - start.NumGC,
+		GCPause:    time.Duration(end.PauseTotalNs - start.PauseTotalNs),
+	}
+}
@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricBuckets are the upper bounds, in seconds, of the latency
+// histogram buckets served by the metrics endpoint. It must not be
+// changed once requests are recorded.
+var MetricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h.buckets == nil {
+		h.buckets = make([]uint64, len(MetricBuckets))
+	}
+	v := d.Seconds()
+	for i, b := range MetricBuckets {
+		if v <= b {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+const (
+	// maxMetricKeys is the number of paths and RPCs with their own
+	// metrics. Others are counted under otherMetric, so that an instance
+	// seeing many distinct URLs does not grow its metrics, or the
+	// labels of Prometheus, without bound.
+	maxMetricKeys = 500
+
+	otherMetric = "<other>"
+)
+
+type pathStatus struct {
+	path   string
+	status int
+}
+
+// metrics accumulates the requests recorded by this instance since it
+// started, for Prometheus.
+var metrics = struct {
+	sync.Mutex
+	requests        map[pathStatus]uint64
+	cost            map[string]int64
+	errors          map[string]uint64
+	requestDuration map[string]*histogram
+	rpcDuration     map[string]*histogram
+}{
+	requests:        make(map[pathStatus]uint64),
+	cost:            make(map[string]int64),
+	errors:          make(map[string]uint64),
+	requestDuration: make(map[string]*histogram),
+	rpcDuration:     make(map[string]*histogram),
+}
+
+func observe(hs map[string]*histogram, key string, d time.Duration) {
+	h := hs[key]
+	if h == nil {
+		h = new(histogram)
+		hs[key] = h
+	}
+	h.observe(d)
+}
+
+// MetricPath returns the path under which r is counted in metrics: its
+// route, if it has one, or else the first segment of its path, so that
+// /user/123 is counted as /user. Unlike Path, it has few distinct values.
+func (r *RequestStats) MetricPath() string {
+	path := r.aggregatePath()
+	if r.Route != "" || path != r.Path {
+		return path
+	}
+	if len(path) > 1 {
+		if i := strings.IndexByte(path[1:], '/'); i >= 0 {
+			return path[:i+1]
+		}
+	}
+	return path
+}
+
+// metricKey returns key, or otherMetric if hs has no room for it.
+func metricKey(hs map[string]*histogram, key string) string {
+	if _, ok := hs[key]; !ok && len(hs) >= maxMetricKeys {
+		return otherMetric
+	}
+	return key
+}
+
+// recordMetrics adds r to the metrics.
+func recordMetrics(r *RequestStats) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	path := metricKey(metrics.requestDuration, r.MetricPath())
+	metrics.requests[pathStatus{path, r.Status}]++
+	metrics.cost[path] += r.Cost
+	if r.Status >= 500 {
+		metrics.errors[path]++
+	}
+	observe(metrics.requestDuration, path, r.Duration)
+	for _, s := range r.RPCStats {
+		observe(metrics.rpcDuration, metricKey(metrics.rpcDuration, s.Name()), s.Duration+s.ExtraDuration)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]int64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]uint64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*histogram:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeHistograms(w io.Writer, name, help, label string, hs map[string]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, k := range sortedKeys(hs) {
+		h := hs[k]
+		l := labelEscaper.Replace(k)
+		for i, b := range MetricBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s=\"%s\",le=\"%s\"} %d\n", name, label, l,
+				strconv.FormatFloat(b, 'g', -1, 64), h.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s=\"%s\",le=\"+Inf\"} %d\n", name, label, l, h.count)
+		fmt.Fprintf(w, "%s_sum{%s=\"%s\"} %g\n", name, label, l, h.sum)
+		fmt.Fprintf(w, "%s_count{%s=\"%s\"} %d\n", name, label, l, h.count)
+	}
+}
+
+// serveMetrics serves the metrics of this instance in the Prometheus
+// text exposition format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	fmt.Fprint(w, "# HELP appstats_requests_total Recorded requests.\n# TYPE appstats_requests_total counter\n")
+	keys := make([]pathStatus, 0, len(metrics.requests))
+	for k := range metrics.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].path != keys[j].path {
+			return keys[i].path < keys[j].path
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "appstats_requests_total{path=\"%s\",status=\"%d\"} %d\n",
+			labelEscaper.Replace(k.path), k.status, metrics.requests[k])
+	}
+
+	fmt.Fprint(w, "# HELP appstats_errors_total Recorded requests with a 5xx status.\n# TYPE appstats_errors_total counter\n")
+	for _, k := range sortedKeys(metrics.errors) {
+		fmt.Fprintf(w, "appstats_errors_total{path=\"%s\"} %d\n", labelEscaper.Replace(k), metrics.errors[k])
+	}
+
+	fmt.Fprint(w, "# HELP appstats_cost_total Cost of recorded requests, in micropennies.\n# TYPE appstats_cost_total counter\n")
+	for _, k := range sortedKeys(metrics.cost) {
+		fmt.Fprintf(w, "appstats_cost_total{path=\"%s\"} %d\n", labelEscaper.Replace(k), metrics.cost[k])
+	}
+
+	writeHistograms(w, "appstats_request_duration_seconds", "Duration of recorded requests.", "path", metrics.requestDuration)
+	writeHistograms(w, "appstats_rpc_duration_seconds", "Duration of RPCs of recorded requests.", "rpc", metrics.rpcDuration)
+
+	depth, dropped := saveQueueStats()
+	fmt.Fprintf(w, "# HELP appstats_save_queue_depth Records waiting in SaveQueue.\n# TYPE appstats_save_queue_depth gauge\nappstats_save_queue_depth %d\n", depth)
+	fmt.Fprintf(w, "# HELP appstats_save_queue_dropped_total Records dropped by a full SaveQueue.\n# TYPE appstats_save_queue_dropped_total counter\nappstats_save_queue_dropped_total %d\n", dropped)
+}
@@ -0,0 +1,784 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"encoding/json"
+	"fmt"
+	stdlog "log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+// onAppEngine reports whether the app runs on App Engine or its
+// development server, where its APIs are available.
+var onAppEngine = appengine.IsAppEngine() || appengine.IsDevAppServer()
+
+// secondGen reports whether the app runs on a second generation App
+// Engine runtime, such as go111 and later, where the App Engine APIs
+// are only available to apps using appengine.Main and bundled services.
+var secondGen = appengine.IsSecondGen()
+
+// An Option configures NewHandler, Middleware and Dashboard, so that
+// handlers in the same app may be configured differently. Options
+// replace the deprecated package variables of the same settings.
+type Option func(*config)
+
+type config struct {
+	store      Storage
+	rollups    RollupStorage
+	rollupsSet bool
+
+	fraction    float64
+	fractionSet bool
+	sampler     func(*http.Request) bool
+	sampleKey   func(*http.Request) string
+
+	classFractions map[string]float64
+
+	recordPaths, ignorePaths []string
+	ignorePattern            *regexp.Regexp
+
+	protoMax    int
+	protoMaxSet bool
+
+	body    int
+	bodySet bool
+
+	budget int
+
+	stacks, stacksSet bool
+
+	frames    int
+	framesSet bool
+
+	threshold time.Duration
+
+	rpcs    int
+	rpcsSet bool
+
+	saveFn        func(*RequestStats) bool
+	slow          time.Duration
+	errorsOnly    bool
+	errorStatuses []int
+
+	noPayloads  bool
+	authorizeFn func(*http.Request) bool
+	networks    []string
+	name        string
+	recordCodec Codec
+	compress    bool
+	token       string
+	redactRules []RedactRule
+	services    map[string]string
+	namespace   func(*http.Request) string
+	routes      []route
+}
+
+// A route is the configuration of the requests of a pattern, given by
+// WithRoute.
+type route struct {
+	pattern string
+	opts    []Option
+	config  *config
+}
+
+// WithSampling records the fraction of requests, between 0.0 (none)
+// and 1.0 (all), instead of using ShouldRecord.
+func WithSampling(fraction float64) Option {
+	return func(c *config) {
+		c.fraction = fraction
+		c.fractionSet = true
+		c.sampler = nil
+	}
+}
+
+// WithSampler records the requests for which f returns true, such as an
+// AdaptiveSampler, instead of using ShouldRecord.
+func WithSampler(f func(r *http.Request) bool) Option {
+	return func(c *config) {
+		c.sampler = f
+		c.fractionSet = false
+	}
+}
+
+// WithSampleKey samples requests for WithSampling and WithClassFractions
+// by the hash of f, a stable attribute of a request such as its trace ID
+// or user, instead of at random, so that requests with the same key are
+// all recorded or not. Requests with an empty key are sampled at random.
+// TraceKey samples whole traces across services.
+func WithSampleKey(f func(r *http.Request) string) Option {
+	return func(c *config) {
+		c.sampleKey = f
+	}
+}
+
+// WithClassFractions records the fraction of the requests of each class
+// in fractions, such as ClassCron, instead of that of the other
+// requests. A fraction of 0 excludes a class.
+func WithClassFractions(fractions map[string]float64) Option {
+	return func(c *config) {
+		c.classFractions = fractions
+	}
+}
+
+// WithRecordPaths records the requests to the paths matching patterns
+// only. Patterns are those of path.Match, and a pattern ending in /*
+// also matches all paths below it.
+func WithRecordPaths(patterns ...string) Option {
+	return func(c *config) {
+		c.recordPaths = patterns
+	}
+}
+
+// WithIgnorePaths never records the requests to the paths matching
+// patterns, as in WithRecordPaths, to keep static files and health
+// checks out of the records:
+//
+//	appstats.WithIgnorePaths("/static/*", "/healthz", "/_ah/*")
+func WithIgnorePaths(patterns ...string) Option {
+	return func(c *config) {
+		c.ignorePaths = patterns
+	}
+}
+
+// WithIgnorePathPattern also ignores the requests to the paths re
+// matches.
+func WithIgnorePathPattern(re *regexp.Regexp) Option {
+	return func(c *config) {
+		c.ignorePattern = re
+	}
+}
+
+// WithShouldSave stores the records of the requests for which f returns
+// true once they end, for example to keep only the requests of some
+// users or above some cost, instead of using WithSlowThreshold and
+// WithErrorsOnly. Records not stored are still counted in rollups,
+// metrics and sinks. Requests forced by WithRecordToken and replays are
+// always stored.
+func WithShouldSave(f func(stats *RequestStats) bool) Option {
+	return func(c *config) {
+		c.saveFn = f
+	}
+}
+
+// WithSlowThreshold stores the records of the requests taking at least
+// d only. Faster requests are still recorded, and counted in rollups,
+// metrics and sinks, but their records are dropped once they end, so
+// that only the interesting ones churn the storage. The tables of the
+// dashboard then only show slow requests.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(c *config) {
+		c.slow = d
+	}
+}
+
+// WithErrorsOnly stores the records of the requests that panicked or
+// answered with one of statuses only, all 5xx statuses if none are
+// given, for failure triage. With WithSlowThreshold too, the records of
+// both slow and failed requests are stored.
+func WithErrorsOnly(statuses ...int) Option {
+	return func(c *config) {
+		c.errorsOnly = true
+		c.errorStatuses = statuses
+	}
+}
+
+// WithRecordToken forces the recording of the requests whose
+// X-Appstats-Record header is token, whatever the sampling and the paths
+// to record, with the details of all their RPCs, to capture a request on
+// demand in production:
+//
+//	curl -H "X-Appstats-Record: $TOKEN" https://myapp.appspot.com/slow
+//
+// Keep it as secret as the dashboard: the header is not recorded. The
+// dashboard needs the same token to replay requests, whose replays are
+// signed with it.
+func WithRecordToken(token string) Option {
+	return func(c *config) {
+		c.token = token
+	}
+}
+
+// WithMaxRPCs records n RPCs per request instead of 1000, so that
+// requests making tens of thousands of calls do not exhaust the memory
+// of the instance. Later RPCs are only counted, with their total
+// duration and cost. Zero records all RPCs.
+func WithMaxRPCs(n int) Option {
+	return func(c *config) {
+		c.rpcs = n
+		c.rpcsSet = true
+	}
+}
+
+// WithPayloadLimit records n bytes of the requests and responses of RPCs
+// instead of ProtoMaxBytes.
+func WithPayloadLimit(n int) Option {
+	if n < 0 {
+		n = 0
+	}
+	return func(c *config) {
+		c.protoMax = n
+		c.protoMaxSet = true
+	}
+}
+
+// WithPayloadBudget records n bytes of the requests and responses of
+// the RPCs of each request in total. The payloads of the RPCs made once
+// it is spent are truncated, so that requests making many large calls,
+// such as datastore batches, keep a record within the memcache value
+// size limit. Zero, the default, is no limit.
+func WithPayloadBudget(n int) Option {
+	return func(c *config) {
+		c.budget = n
+	}
+}
+
+// WithBodyLimit records n bytes of request bodies instead of RecordBody.
+// Zero disables recording bodies.
+func WithBodyLimit(n int) Option {
+	return func(c *config) {
+		c.body = n
+		c.bodySet = true
+	}
+}
+
+// WithStorage stores recorded requests in s instead of Store.
+func WithStorage(s Storage) Option {
+	return func(c *config) {
+		c.store = s
+	}
+}
+
+// WithRollups keeps rollups in r instead of Rollups. A nil r disables
+// rollups.
+func WithRollups(r RollupStorage) Option {
+	return func(c *config) {
+		c.rollups = r
+		c.rollupsSet = true
+	}
+}
+
+// WithPayloads enables or disables recording the requests and responses
+// of RPCs and the bodies of requests. They are recorded by default, up
+// to the limits of the package or of WithPayloadLimit and WithBodyLimit.
+func WithPayloads(on bool) Option {
+	return func(c *config) {
+		c.noPayloads = !on
+	}
+}
+
+// WithStacks enables or disables recording the call stacks of RPCs,
+// which are recorded by default. Capturing stacks is a large part of the
+// cost of recording a request that makes many RPCs.
+func WithStacks(on bool) Option {
+	return func(c *config) {
+		c.stacks = on
+		c.stacksSet = true
+	}
+}
+
+// WithStackFrames keeps the top n frames of RPC call stacks, from the
+// caller of the RPC, instead of all of them.
+func WithStackFrames(n int) Option {
+	return func(c *config) {
+		c.frames = n
+		c.framesSet = true
+	}
+}
+
+// WithStackThreshold keeps the call stacks of the RPCs taking at least d
+// only.
+func WithStackThreshold(d time.Duration) Option {
+	return func(c *config) {
+		c.threshold = d
+	}
+}
+
+// WithName aggregates the requests under name instead of their path or
+// the route of PathNormalizer.
+func WithName(name string) Option {
+	return func(c *config) {
+		c.name = name
+	}
+}
+
+// WithCodec encodes the stored records with c instead of GobCodec.
+// Codecs other than GobCodec and ProtoCodec must be registered with
+// RegisterCodec. Records are decoded by the codec that encoded them,
+// whatever the codec of the dashboard.
+func WithCodec(c Codec) Option {
+	return func(cfg *config) {
+		cfg.recordCodec = c
+	}
+}
+
+// WithCompression gzip compresses stored records, so that records with
+// RPC payloads and stacks fit in the memcache value size limit. Records
+// are read back whether compressed or not.
+func WithCompression(on bool) Option {
+	return func(c *config) {
+		c.compress = on
+	}
+}
+
+// WithRedactRules applies rules to the users, paths, query strings,
+// headers, bodies, annotations, events, logs, panics and RPC payloads of
+// requests before they are saved or exported, after RedactHeaders and
+// RedactBody. Add RedactEmails to remove email addresses.
+func WithRedactRules(rules ...RedactRule) Option {
+	return func(c *config) {
+		c.redactRules = rules
+	}
+}
+
+// WithNamespace records the App Engine namespace of requests as given by
+// f, typically the one the app passes to appengine.Namespace for the
+// tenant of the request. Otherwise the namespace of a request is that of
+// its first RPC made in a namespace, or set by Recorder.SetNamespace.
+func WithNamespace(f func(r *http.Request) string) Option {
+	return func(c *config) {
+		c.namespace = f
+	}
+}
+
+// WithAuthorize serves the dashboard to the requests for which f returns
+// true only, such as those with a valid session or SSO claim, instead of
+// the admins of the app on App Engine, or the local host elsewhere. App
+// Engine cron jobs, such as the rollup job, are always served.
+func WithAuthorize(f func(r *http.Request) bool) Option {
+	return func(c *config) {
+		c.authorizeFn = f
+	}
+}
+
+// WithAllowedNetworks serves the dashboard and its API to the clients
+// in the CIDR blocks cidrs, such as "203.0.113.0/24", only, in addition
+// to the other checks. Other clients get 403 Forbidden. On App Engine,
+// the client is taken from the X-Forwarded-For header set by the
+// frontend.
+func WithAllowedNetworks(cidrs ...string) Option {
+	return func(c *config) {
+		c.networks = cidrs
+	}
+}
+
+// WithServices shows the requests of the other services of the app on
+// the services page of the dashboard. services maps their names to the
+// URLs of their dashboards, such as
+// https://api-dot-myapp.appspot.com/_ah/stats/.
+func WithServices(services map[string]string) Option {
+	return func(c *config) {
+		c.services = services
+	}
+}
+
+// WithRoute applies opts, after the other options, to the requests
+// matching pattern, so that one handler wrapping a mux can record its
+// routes differently. Patterns are those of http.ServeMux: a pattern
+// ending in a slash matches the paths it prefixes, and the longest
+// matching pattern applies:
+//
+//	appstats.Middleware(mux,
+//		appstats.WithRoute("/checkout/", appstats.WithBodyLimit(10000)),
+//		appstats.WithRoute("/healthz", appstats.WithSampling(0.01), appstats.WithPayloads(false)),
+//	)
+func WithRoute(pattern string, opts ...Option) Option {
+	return func(c *config) {
+		c.routes = append(c.routes, route{pattern: pattern, opts: opts})
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{}
+	for _, o := range opts {
+		o(c)
+	}
+	for i := range c.routes {
+		rc := *c
+		rc.routes = nil
+		for _, o := range c.routes[i].opts {
+			o(&rc)
+		}
+		c.routes[i].config = &rc
+	}
+	return c
+}
+
+// forRequest returns the configuration of r: that of its route, or c.
+func (c *config) forRequest(r *http.Request) *config {
+	cfg, n := c, -1
+	for _, rt := range c.routes {
+		if len(rt.pattern) > n && matchPattern(rt.pattern, r.URL.Path) {
+			cfg, n = rt.config, len(rt.pattern)
+		}
+	}
+	return cfg
+}
+
+// matchPattern reports whether path matches the http.ServeMux pattern.
+func matchPattern(pattern, path string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(path, pattern)
+	}
+	return path == pattern
+}
+
+// sample reports whether r is sampled for recording, by the fraction of
+// its class of WithClassFractions, WithSampler, the fraction of
+// WithSampling or ShouldRecord. c may be nil.
+func (c *config) sample(r *http.Request) bool {
+	if c == nil {
+		return ShouldRecord(r)
+	}
+	if f, ok := c.classFractions[requestClass(r)]; ok {
+		return sampled(r, f, c.sampleKey)
+	}
+	if c.sampler != nil {
+		return c.sampler(r)
+	}
+	if !c.fractionSet {
+		return ShouldRecord(r)
+	}
+	return sampled(r, c.fraction, c.sampleKey)
+}
+
+// recordToken returns the token of WithRecordToken, or "" for none. c
+// may be nil.
+func (c *config) recordToken() string {
+	if c == nil {
+		return ""
+	}
+	return c.token
+}
+
+// maxRPCs returns the number of RPCs recorded per request, or 0 for all.
+// c may be nil.
+func (c *config) maxRPCs() int {
+	if c == nil || !c.rpcsSet {
+		return defaultMaxRPCs
+	}
+	return c.rpcs
+}
+
+// protoMaxBytes returns the number of bytes of RPC payloads to record.
+// c may be nil.
+func (c *config) protoMaxBytes() int {
+	if c == nil {
+		return ProtoMaxBytes
+	}
+	if c.noPayloads {
+		return 0
+	}
+	if !c.protoMaxSet {
+		return ProtoMaxBytes
+	}
+	return c.protoMax
+}
+
+// payloadBudget returns the total number of bytes of RPC payloads to
+// record per request, or 0 for no limit. c may be nil.
+func (c *config) payloadBudget() int64 {
+	if c == nil {
+		return 0
+	}
+	return int64(c.budget)
+}
+
+// recordBody returns the number of bytes of request bodies to record. c
+// may be nil.
+func (c *config) recordBody() int {
+	if c == nil {
+		return RecordBody
+	}
+	if c.noPayloads {
+		return 0
+	}
+	if !c.bodySet {
+		return RecordBody
+	}
+	return c.body
+}
+
+// stackFrames returns the number of frames of RPC call stacks to record:
+// 0 for all, or -1 for none. c may be nil.
+func (c *config) stackFrames() int {
+	on, n := true, 0
+	if c != nil {
+		if c.stacksSet {
+			on = c.stacks
+		}
+		if c.framesSet {
+			n = c.frames
+		}
+	}
+	if !on {
+		return -1
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// stackThreshold returns the duration below which the call stacks of
+// RPCs are not kept. c may be nil.
+func (c *config) stackThreshold() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.threshold
+}
+
+// authorize returns the function authorizing dashboard requests, or nil
+// for the default checks. c may be nil.
+func (c *config) authorize() func(*http.Request) bool {
+	if c == nil {
+		return nil
+	}
+	return c.authorizeFn
+}
+
+// allowedNetworks returns the CIDR blocks of the clients allowed to see
+// the dashboard, or nil for all. c may be nil.
+func (c *config) allowedNetworks() []string {
+	if c == nil {
+		return nil
+	}
+	return c.networks
+}
+
+// codec returns the Codec of stored records. c may be nil.
+func (c *config) codec() Codec {
+	if c != nil && c.recordCodec != nil {
+		return c.recordCodec
+	}
+	if ProtoRecords {
+		return ProtoCodec
+	}
+	return GobCodec
+}
+
+// compressRecords reports whether stored records are compressed. c may
+// be nil.
+func (c *config) compressRecords() bool {
+	return c != nil && c.compress
+}
+
+// servicesMap returns the services of WithServices. c may be nil.
+func (c *config) servicesMap() map[string]string {
+	if c == nil {
+		return nil
+	}
+	return c.services
+}
+
+// redact returns the rules of WithRedactRules. c may be nil.
+func (c *config) redact() []RedactRule {
+	if c == nil {
+		return nil
+	}
+	return c.redactRules
+}
+
+// configOf returns the configuration of c, or nil if it has none.
+func configOf(c context.Context) *config {
+	cfg, _ := c.Value(configKey).(*config)
+	return cfg
+}
+
+// storage returns the Storage of the configuration of c, or Store.
+func storage(c context.Context) Storage {
+	if cfg := configOf(c); cfg != nil && cfg.store != nil {
+		return cfg.store
+	}
+	return Store
+}
+
+// rollupStorage returns the RollupStorage of the configuration of c, or
+// Rollups. The default memcache rollups are disabled off App Engine.
+func rollupStorage(c context.Context) RollupStorage {
+	if cfg := configOf(c); cfg != nil && cfg.rollupsSet {
+		return cfg.rollups
+	}
+	if _, ok := Rollups.(MemcacheRollups); ok && !onAppEngine {
+		return nil
+	}
+	return Rollups
+}
+
+// Middleware returns a handler recording the requests served by next.
+// Unlike NewHandler, it works on any HTTP server, not only App Engine:
+// next gets the recording context from the request, for use with
+// StartSpan, RecordCall, Annotate and Transport:
+//
+//	store := appstats.WithStorage(appstats.NewMemoryStorage(100))
+//	http.Handle("/", appstats.Middleware(mux, store))
+//	http.Handle("/debug/stats/", appstats.Dashboard("/debug/stats/", store))
+//
+// Off App Engine, records need a Storage that does not use its APIs,
+// such as a MemoryStorage or a redisstore.Store, and rollups are
+// disabled unless given by WithRollups.
+func Middleware(next http.Handler, opts ...Option) http.Handler {
+	return middleware{next, newConfig(opts)}
+}
+
+type middleware struct {
+	next   http.Handler
+	config *config
+}
+
+func (m middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := m.config.forRequest(r)
+	if !shouldRecord(r, cfg) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+	var ctx context.Context
+	if onAppEngine {
+		ctx = newContext(r, cfg)
+	} else {
+		ctx = context.WithValue(r.Context(), statsKey, newStats(r, cfg))
+		ctx = context.WithValue(ctx, headerKey, r.Header)
+	}
+	ctx = context.WithValue(ctx, configKey, cfg)
+	serve(ctx, w, r, func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		m.next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// StartRequest starts recording a request served other than by
+// NewHandler or Middleware, such as a gRPC call, if ShouldRecord samples
+// it. r describes the request, whose method, URL path and headers are
+// recorded. It returns the recording context and the record of the
+// request, or ctx and nil if the request is not recorded. Once the
+// request is served, set the Status and sizes of the record and call
+// SaveRequest with the recording context.
+func StartRequest(ctx context.Context, r *http.Request, opts ...Option) (context.Context, *RequestStats) {
+	cfg := newConfig(opts).forRequest(r)
+	if !shouldRecord(r, cfg) {
+		return ctx, nil
+	}
+	stats := newStats(r, cfg)
+	ctx = context.WithValue(ctx, statsKey, stats)
+	ctx = context.WithValue(ctx, headerKey, r.Header)
+	ctx = context.WithValue(ctx, configKey, cfg)
+	if onAppEngine {
+		ctx = appengine.WithAPICallFunc(ctx, override)
+	}
+	return ctx, stats
+}
+
+// SaveRequest saves the record of the request of ctx, started by
+// StartRequest. It does nothing if the request is not recorded.
+func SaveRequest(ctx context.Context) {
+	if recording(ctx) != nil {
+		save(ctx)
+	}
+}
+
+// isLoopback reports whether r comes from the local host.
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// clientIP returns the address of the client of r. On App Engine, it is
+// the last public address of the X-Forwarded-For header, which the
+// frontend appends the client to; earlier entries are set by the client
+// and cannot be trusted. Elsewhere, it is the remote address.
+func clientIP(r *http.Request) net.IP {
+	if onAppEngine {
+		fwd := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		for i := len(fwd) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(fwd[i]))
+			if ip != nil && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// inNetworks reports whether ip is in one of the CIDR blocks cidrs.
+// Invalid blocks match nothing.
+func inNetworks(ip net.IP, cidrs []string) bool {
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		if _, n, err := net.ParseCIDR(cidr); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// logf logs to the App Engine log of c on first generation App Engine.
+// On second generation runtimes, it writes structured entries to
+// standard error, which App Engine sends to Cloud Logging with the
+// trace of the request, and it uses the standard logger elsewhere.
+// level is one of DEBUG, INFO, WARNING, ERROR and CRITICAL.
+func logf(c context.Context, level, format string, args ...interface{}) {
+	if !onAppEngine {
+		stdlog.Printf("%s: %s", level, fmt.Sprintf(format, args...))
+		return
+	}
+	if secondGen {
+		entry := map[string]string{
+			"severity": level,
+			"message":  fmt.Sprintf(format, args...),
+		}
+		project := os.Getenv("GOOGLE_CLOUD_PROJECT")
+		if s := recording(c); s != nil && s.CloudTraceContext != "" && project != "" {
+			trace := strings.SplitN(s.CloudTraceContext, "/", 2)[0]
+			entry["logging.googleapis.com/trace"] = "projects/" + project + "/traces/" + trace
+		}
+		b, _ := json.Marshal(entry)
+		fmt.Fprintf(os.Stderr, "%s\n", b)
+		return
+	}
+	switch level {
+	case "DEBUG":
+		log.Debugf(c, format, args...)
+	case "INFO":
+		log.Infof(c, format, args...)
+	case "WARNING":
+		log.Warningf(c, format, args...)
+	case "CRITICAL":
+		log.Criticalf(c, format, args...)
+	default:
+		log.Errorf(c, format, args...)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package msgpackcodec provides an appstats.Codec encoding records with
+MessagePack, which are smaller and faster to encode than gob records
+and readable from most languages.
+
+	appstats.NewHandler(h, appstats.WithCodec(msgpackcodec.Codec{}))
+
+Importing the package registers the codec, so that the dashboard reads
+msgpack records whatever the codec in use.
+*/
+package msgpackcodec
+
+import (
+	"net/http"
+
+	"github.com/mjibson/appstats"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Marker is the first byte of the stored records of Codec.
+const Marker = 3
+
+func init() {
+	appstats.RegisterCodec(Marker, Codec{})
+}
+
+// Codec encodes records with MessagePack.
+type Codec struct{}
+
+var _ appstats.Codec = Codec{}
+
+type record struct {
+	Header http.Header            `msgpack:"header,omitempty"`
+	Stats  *appstats.RequestStats `msgpack:"stats"`
+}
+
+// Encode implements appstats.Codec.
+func (Codec) Encode(r *appstats.RequestStats, h http.Header, part bool) ([]byte, error) {
+	return msgpack.Marshal(&record{Header: h, Stats: r})
+}
+
+// Decode implements appstats.Codec.
+func (Codec) Decode(b []byte, part bool) (*appstats.RequestStats, http.Header, error) {
+	var rec record
+	if err := msgpack.Unmarshal(b, &rec); err != nil {
+		return nil, nil, err
+	}
+	return rec.Stats, rec.Header, nil
+}
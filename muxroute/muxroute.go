@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package muxroute aggregates appstats by the route templates of a
+gorilla/mux router, so that /user/123 and /user/456 are both counted
+under /user/{id}.
+
+	r := mux.NewRouter()
+	r.HandleFunc("/user/{id}", userHandler)
+	appstats.PathNormalizer = muxroute.Normalizer(r)
+
+Alternatively, Middleware records the requests of the router, under
+the template of the route they match:
+
+	r.Use(muxroute.Middleware())
+*/
+package muxroute
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mjibson/appstats"
+)
+
+// Normalizer returns an appstats.PathNormalizer returning the path
+// template of the route of router matching a request. Requests matching
+// no route are aggregated by path.
+func Normalizer(router *mux.Router) func(*http.Request) string {
+	return func(r *http.Request) string {
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.Route == nil {
+			return ""
+		}
+		tpl, err := match.Route.GetPathTemplate()
+		if err != nil {
+			return ""
+		}
+		return tpl
+	}
+}
+
+// Middleware returns a router middleware recording requests with
+// appstats.Middleware configured by opts, aggregated under the path
+// template of the route they match.
+func Middleware(opts ...appstats.Option) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return appstats.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route := mux.CurrentRoute(r); route != nil {
+				if tpl, err := route.GetPathTemplate(); err == nil {
+					appstats.FromContext(r.Context()).SetRoute(tpl)
+				}
+			}
+			next.ServeHTTP(w, r)
+		}), opts...)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// setMemcacheHits sets the hits and misses of s, a memcache Get call,
+// from its messages. The Get call serves both memcache.Get and
+// memcache.GetMulti.
+func setMemcacheHits(s *RPCStat, in, out proto.Message) {
+	req := reflect.Indirect(reflect.ValueOf(in))
+	resp := reflect.Indirect(reflect.ValueOf(out))
+	if req.Kind() != reflect.Struct || resp.Kind() != reflect.Struct {
+		return
+	}
+	keys := reflectLen(req.FieldByName("Key"))
+	s.Hits = reflectLen(resp.FieldByName("Item"))
+	if keys > s.Hits {
+		s.Misses = keys - s.Hits
+	}
+}
+
+// cacheStats counts the keys looked up by memcache Get calls.
+type cacheStats struct {
+	Hits, Misses int
+}
+
+func (c *cacheStats) merge(o cacheStats) {
+	c.Hits += o.Hits
+	c.Misses += o.Misses
+}
+
+// Lookups returns the number of keys looked up.
+func (c cacheStats) Lookups() int {
+	return c.Hits + c.Misses
+}
+
+// HitRate returns the percentage of keys found.
+func (c cacheStats) HitRate() float64 {
+	if c.Lookups() == 0 {
+		return 0
+	}
+	return 100 * float64(c.Hits) / float64(c.Lookups())
+}
+
+// Memcache returns the memcache lookups of r.
+func (r *RequestStats) Memcache() cacheStats {
+	var c cacheStats
+	for _, s := range r.RPCStats {
+		c.Hits += s.Hits
+		c.Misses += s.Misses
+	}
+	return c
+}
+
+// setDatastoreOps sets the entities read and written, and the index
+// entries written, by s, a datastore call, from its messages. Index
+// writes are taken from the cost reported by the datastore if any, and
+// otherwise estimated for puts as two for the entity and two for each
+// indexed property value.
+// rpcNamespace returns the namespace of the RPC request in: its own, for
+// example that of memcache or datastore query requests, or that of its
+// first datastore key or entity.
+func rpcNamespace(in proto.Message) string {
+	if m, ok := in.(interface {
+		GetNameSpace() string
+	}); ok {
+		return m.GetNameSpace()
+	}
+	req := reflect.Indirect(reflect.ValueOf(in))
+	if req.Kind() != reflect.Struct {
+		return ""
+	}
+	key := req.FieldByName("Key")
+	if entities := req.FieldByName("Entity"); reflectLen(entities) > 0 {
+		e := reflect.Indirect(entities.Index(0))
+		if e.Kind() == reflect.Struct {
+			key = e.FieldByName("Key")
+		}
+	}
+	if key.Kind() == reflect.Slice {
+		if key.Len() == 0 {
+			return ""
+		}
+		key = key.Index(0)
+	}
+	if key.IsValid() && key.CanInterface() {
+		if k, ok := key.Interface().(interface {
+			GetNameSpace() string
+		}); ok {
+			return k.GetNameSpace()
+		}
+	}
+	return ""
+}
+
+func setDatastoreOps(s *RPCStat, in, out proto.Message) {
+	req := reflect.Indirect(reflect.ValueOf(in))
+	resp := reflect.Indirect(reflect.ValueOf(out))
+	if req.Kind() != reflect.Struct || resp.Kind() != reflect.Struct {
+		return
+	}
+	switch s.Method {
+	case "Get":
+		s.Reads = reflectLen(req.FieldByName("Key"))
+	case "RunQuery", "Next":
+		s.Reads = reflectLen(resp.FieldByName("Result"))
+	case "Put":
+		entities := req.FieldByName("Entity")
+		s.Writes = reflectLen(entities)
+		for i := 0; i < s.Writes; i++ {
+			e := reflect.Indirect(entities.Index(i))
+			if e.Kind() == reflect.Struct {
+				s.IndexWrites += 2 + 2*reflectLen(e.FieldByName("Property"))
+			}
+		}
+	case "Delete":
+		s.Writes = reflectLen(req.FieldByName("Key"))
+	}
+
+	cost := resp.FieldByName("Cost")
+	if cost.Kind() == reflect.Ptr && !cost.IsNil() {
+		cost = cost.Elem()
+		s.IndexWrites = int(reflectInt(cost.FieldByName("IndexWrites")))
+		if w := int(reflectInt(cost.FieldByName("EntityWrites"))); w > 0 {
+			s.Writes = w
+		}
+	}
+}
+
+// datastoreOps counts datastore operations.
+type datastoreOps struct {
+	Reads, Writes, IndexWrites int
+}
+
+func (d *datastoreOps) merge(o datastoreOps) {
+	d.Reads += o.Reads
+	d.Writes += o.Writes
+	d.IndexWrites += o.IndexWrites
+}
+
+// Total returns the number of operations.
+func (d datastoreOps) Total() int {
+	return d.Reads + d.Writes + d.IndexWrites
+}
+
+// String formats d as reads/writes/index writes.
+func (d datastoreOps) String() string {
+	return fmt.Sprintf("%d/%d/%d", d.Reads, d.Writes, d.IndexWrites)
+}
+
+// DatastoreOps returns the datastore operations of s.
+func (s RPCStat) DatastoreOps() datastoreOps {
+	return datastoreOps{s.Reads, s.Writes, s.IndexWrites}
+}
+
+// DatastoreOps returns the datastore operations of r.
+func (r *RequestStats) DatastoreOps() datastoreOps {
+	var d datastoreOps
+	for _, s := range r.RPCStats {
+		d.merge(s.DatastoreOps())
+	}
+	return d
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package otelexport provides an appstats.RecordSink that converts recorded
+requests into OpenTelemetry spans: one server span per request, with a
+client span for each of its RPCs. Requests with a W3C traceparent header
+join the trace of their caller.
+
+Spans are sent to the exporters of the given TracerProvider, for example
+an OTLP exporter:
+
+	exp, err := otlptracehttp.New(ctx)
+	...
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp))
+	appstats.Sink = otelexport.New(tp)
+*/
+package otelexport
+
+import (
+	"encoding/hex"
+
+	"github.com/mjibson/appstats"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"golang.org/x/net/context"
+)
+
+const instrumentationName = "github.com/mjibson/appstats"
+
+var _ appstats.RecordSink = (*Exporter)(nil)
+
+// Exporter is an appstats.RecordSink that records spans with a Tracer.
+type Exporter struct {
+	Tracer trace.Tracer
+}
+
+// New returns an Exporter using a Tracer from tp.
+func New(tp trace.TracerProvider) *Exporter {
+	return &Exporter{
+		Tracer: tp.Tracer(instrumentationName),
+	}
+}
+
+// parent returns a context with the remote parent span of r, if any.
+func parent(r *appstats.RequestStats) context.Context {
+	ctx := context.Background()
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	if r.TraceID == "" {
+		return ctx
+	}
+	if _, err := hex.Decode(traceID[:], []byte(r.TraceID)); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(spanID[:], []byte(r.SpanID)); err != nil {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	}))
+}
+
+// Emit implements appstats.RecordSink.
+func (e *Exporter) Emit(r *appstats.RequestStats) {
+	ctx, span := e.Tracer.Start(parent(r), r.Method+" "+r.Path,
+		trace.WithTimestamp(r.Start),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.Path),
+			attribute.Int("http.status_code", r.Status),
+			attribute.String("enduser.id", r.User),
+			attribute.Int64("appstats.cost", r.Cost),
+		),
+	)
+	if r.Status >= 500 {
+		span.SetStatus(codes.Error, "")
+	}
+
+	for _, s := range r.RPCStats {
+		_, rpc := e.Tracer.Start(ctx, s.Name(),
+			trace.WithTimestamp(s.Start),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("rpc.service", s.Service),
+				attribute.String("rpc.method", s.Method),
+				attribute.Int64("appstats.cost", s.Cost),
+			),
+		)
+		rpc.End(trace.WithTimestamp(s.Start.Add(s.Duration + s.ExtraDuration)))
+	}
+
+	span.End(trace.WithTimestamp(r.Start.Add(r.Duration)))
+}
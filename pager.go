@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+// pager is a page of a list of requests, given by the page and size
+// query parameters.
+type pager struct {
+	// Page is the current page, starting at 1.
+	Page  int
+	Size  int
+	Pages int
+	Total int
+
+	params template.URL
+}
+
+// newPager returns the page requested by r of a list of total items.
+// Invalid or out of range parameters are corrected.
+func newPager(r *http.Request, total int) *pager {
+	p := &pager{
+		Size:   PageSize,
+		Total:  total,
+		params: newFilter(r).Params(),
+	}
+	if size, err := strconv.Atoi(r.FormValue("size")); err == nil && size > 0 {
+		p.Size = size
+	}
+	if p.Size < 1 {
+		p.Size = 1
+	}
+	p.Pages = (total + p.Size - 1) / p.Size
+	if p.Pages < 1 {
+		p.Pages = 1
+	}
+	p.Page, _ = strconv.Atoi(r.FormValue("page"))
+	if p.Page < 1 {
+		p.Page = 1
+	} else if p.Page > p.Pages {
+		p.Page = p.Pages
+	}
+	return p
+}
+
+// First returns the index, starting at 1, of the first item of the
+// page.
+func (p *pager) First() int {
+	return (p.Page-1)*p.Size + 1
+}
+
+// bounds returns the slice bounds of the page.
+func (p *pager) bounds() (start, end int) {
+	start = p.First() - 1
+	end = start + p.Size
+	if end > p.Total {
+		end = p.Total
+	}
+	return start, end
+}
+
+// Link returns the link to page n, keeping the filter.
+func (p *pager) Link(n int) template.URL {
+	q := fmt.Sprintf("page=%d&size=%d", n, p.Size)
+	if p.params != "" {
+		q = string(p.params) + "&" + q
+	}
+	return template.URL("?" + q)
+}
+
+// RequestLink returns the link to the item with index i, starting at 1,
+// on its page.
+func (p *pager) RequestLink(i int) template.URL {
+	return p.Link((i-1)/p.Size+1) + template.URL(fmt.Sprintf("#req-%d", i))
+}
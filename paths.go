@@ -0,0 +1,51 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"path"
+	"strings"
+)
+
+// recordPath reports whether requests to p may be recorded, by
+// WithRecordPaths, WithIgnorePaths and WithIgnorePathPattern. c may be
+// nil.
+func (c *config) recordPath(p string) bool {
+	if c == nil {
+		return true
+	}
+	if len(c.recordPaths) > 0 && !matchPaths(c.recordPaths, p) {
+		return false
+	}
+	if matchPaths(c.ignorePaths, p) {
+		return false
+	}
+	return c.ignorePattern == nil || !c.ignorePattern.MatchString(p)
+}
+
+// matchPaths reports whether p matches one of patterns.
+func matchPaths(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(p, pattern[:len(pattern)-1]) {
+			return true
+		}
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"strings"
+)
+
+// protoNode is a field of a message in the protobuf text format, as
+// captured in the In and Out of RPCs.
+type protoNode struct {
+	Name string
+	// Value is the text of a scalar field; Kind is "string", "number"
+	// or "ident". Messages have Children instead.
+	Value    string
+	Kind     string
+	Children []*protoNode
+}
+
+// Message reports whether n is a message field.
+func (n *protoNode) Message() bool {
+	return n.Kind == ""
+}
+
+// protoParser parses the text format leniently: payloads may be
+// truncated at ProtoMaxBytes, and whatever cannot be parsed is kept as
+// a final raw field.
+type protoParser struct {
+	s string
+	i int
+}
+
+// parseProtoText parses s into its fields.
+func parseProtoText(s string) []*protoNode {
+	p := &protoParser{s: s}
+	nodes := p.fields(0)
+	if rest := strings.TrimSpace(p.s[p.i:]); rest != "" {
+		nodes = append(nodes, &protoNode{Name: "…", Value: rest, Kind: "ident"})
+	}
+	return nodes
+}
+
+func (p *protoParser) skipSpace() {
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+// fields parses fields until the end of the message closed by end, or
+// of the input if end is 0.
+func (p *protoParser) fields(end byte) []*protoNode {
+	var nodes []*protoNode
+	for {
+		p.skipSpace()
+		if p.i >= len(p.s) {
+			return nodes
+		}
+		if end != 0 && p.s[p.i] == end {
+			p.i++
+			return nodes
+		}
+		n := p.field()
+		if n == nil {
+			return nodes
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c == '.' || c == '[' || c == ']' || c == '/' ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// field parses a single field, or returns nil at invalid input, which
+// is left unconsumed.
+func (p *protoParser) field() *protoNode {
+	start := p.i
+	for p.i < len(p.s) && isNameByte(p.s[p.i]) {
+		p.i++
+	}
+	if p.i == start {
+		return nil
+	}
+	n := &protoNode{Name: p.s[start:p.i]}
+	p.skipSpace()
+	if p.i < len(p.s) && p.s[p.i] == ':' {
+		p.i++
+		p.skipSpace()
+	}
+	if p.i >= len(p.s) {
+		p.i = start
+		return nil
+	}
+	switch c := p.s[p.i]; c {
+	case '<', '{':
+		p.i++
+		end := byte('>')
+		if c == '{' {
+			end = '}'
+		}
+		n.Children = p.fields(end)
+	case '"', '\'':
+		n.Kind = "string"
+		vstart := p.i
+		p.i++
+		for p.i < len(p.s) && p.s[p.i] != c {
+			if p.s[p.i] == '\\' {
+				p.i++
+			}
+			p.i++
+		}
+		if p.i < len(p.s) {
+			p.i++
+		}
+		if p.i > len(p.s) {
+			p.i = len(p.s)
+		}
+		n.Value = p.s[vstart:p.i]
+	default:
+		vstart := p.i
+		for p.i < len(p.s) && strings.IndexByte(" \t\r\n<>{}", p.s[p.i]) < 0 {
+			p.i++
+		}
+		n.Value = p.s[vstart:p.i]
+		n.Kind = "ident"
+		if v := n.Value; v != "" && (v[0] == '-' || '0' <= v[0] && v[0] <= '9') {
+			n.Kind = "number"
+		}
+	}
+	return n
+}
+
+// RequestTree returns the fields of the request of r.
+func (r RPCStat) RequestTree() []*protoNode {
+	return parseProtoText(r.In)
+}
+
+// ResponseTree returns the fields of the response of r.
+func (r RPCStat) ResponseTree() []*protoNode {
+	return parseProtoText(r.Out)
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The Python appstats module stores the protobuf encoded RequestStatProto
+// of a request in memcache, under a key for each 100ms slot of 1000.
+const (
+	pythonKeyPart   = keyPrefix + "%06d,part"
+	pythonKeyFull   = keyPrefix + "%06d,full"
+	pythonSlots     = 1000
+	pythonSlotWidth = 100 // milliseconds
+)
+
+// pythonMarker is prepended by MemcacheStorage to the records it reads
+// from Python keys, to tell them from records written by this package.
+const pythonMarker = 1
+
+// pythonSlot returns the slot of the Python record of request id.
+func pythonSlot(id int64) int {
+	ms := id / int64(time.Millisecond)
+	return int(ms/pythonSlotWidth%pythonSlots) * pythonSlotWidth
+}
+
+func millis(v uint64) time.Duration {
+	return time.Duration(int64(v)) * time.Millisecond
+}
+
+// unmarshalPython decodes a RequestStatProto of the Python appstats
+// module. Its CGI environment is returned as the request header.
+func unmarshalPython(b []byte) (*RequestStats, http.Header, error) {
+	r := &RequestStats{
+		Method: "GET",
+		Path:   "/",
+		Status: http.StatusOK,
+	}
+	var h http.Header
+	var offsets []time.Duration
+	err := pfields(b, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 1: // start_timestamp_milliseconds
+			r.Start = time.Unix(0, int64(millis(v)))
+		case 2:
+			r.Method = string(data)
+		case 3:
+			r.Path = string(data)
+		case 4:
+			r.Query = string(data)
+		case 5:
+			r.Status = int(v)
+		case 6:
+			r.Duration = millis(v)
+		case 101: // cgi_env
+			var key, value string
+			err := pfields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					key = string(data)
+				case 2:
+					value = string(data)
+				}
+				return nil
+			})
+			if strings.HasPrefix(key, "HTTP_") {
+				if h == nil {
+					h = make(http.Header)
+				}
+				key = strings.Replace(key[len("HTTP_"):], "_", "-", -1)
+				h.Add(key, value)
+			}
+			return err
+		case 102:
+			r.Overhead = millis(v)
+		case 103:
+			r.User = string(data)
+		case 104:
+			r.Admin = v != 0
+		case 107: // individual_stats
+			s, err := unmarshalPythonRPC(data)
+			if err != nil {
+				return err
+			}
+			r.RPCStats = append(r.RPCStats, *s)
+			offsets = append(offsets, s.Offset)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// RPC start times depend on the request start, which may be decoded
+	// after them.
+	for i := range r.RPCStats {
+		r.RPCStats[i].Start = r.Start.Add(offsets[i])
+		r.Cost += r.RPCStats[i].Cost
+	}
+	return r, h, nil
+}
+
+// unmarshalPythonRPC decodes an IndividualRpcStatsProto.
+func unmarshalPythonRPC(b []byte) (*RPCStat, error) {
+	s := &RPCStat{}
+	err := pfields(b, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 1: // service_call_name
+			name := string(data)
+			if i := strings.Index(name, "."); i >= 0 {
+				s.Service, s.Method = name[:i], name[i+1:]
+			} else {
+				s.Service = name
+			}
+		case 3:
+			s.In = string(data)
+		case 4:
+			s.Out = string(data)
+		case 6:
+			s.Offset = millis(v)
+		case 7:
+			s.Duration = millis(v)
+		case 10: // call_stack
+			f := &frame{}
+			err := pfields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					f.Location = string(data)
+				case 2:
+					f.Lineno = int(v)
+				case 3:
+					f.Call = string(data)
+				}
+				return nil
+			})
+			s.Frames = append(s.Frames, f)
+			return err
+		case 13: // call_cost_microdollars
+			// Costs are shown in micropennies.
+			s.Cost = int64(v) * 100
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+var errProto = errors.New("appstats: malformed Python record")
+
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// pfields calls fn with each field of the message b. Varint fields are
+// passed in v, length-delimited ones in data. Fields of other types are
+// skipped.
+func pfields(b []byte, fn func(field int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := readVarint(b)
+		if n == 0 {
+			return errProto
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := readVarint(b)
+			if n == 0 {
+				return errProto
+			}
+			b = b[n:]
+			if err := fn(field, v, nil); err != nil {
+				return err
+			}
+		case 1:
+			if len(b) < 8 {
+				return errProto
+			}
+			b = b[8:]
+		case 2:
+			l, n := readVarint(b)
+			if n == 0 || uint64(len(b)-n) < l {
+				return errProto
+			}
+			if err := fn(field, 0, b[n:n+int(l)]); err != nil {
+				return err
+			}
+			b = b[n+int(l):]
+		case 5:
+			if len(b) < 4 {
+				return errProto
+			}
+			b = b[4:]
+		default:
+			return errProto
+		}
+	}
+	return nil
+}
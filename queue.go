@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A pendingSave is a record waiting in the save queue.
+type pendingSave struct {
+	ctx   context.Context
+	store Storage
+	// config is the configuration of the request, by which records
+	// are batched, as it determines store.
+	config *config
+	Record
+}
+
+var saveQueue struct {
+	sync.Mutex
+	// ch is created, with the goroutine storing its records, by the
+	// first record queued.
+	ch      chan pendingSave
+	dropped uint64
+}
+
+// queueSave queues the records of request id for storage in the
+// background, if SaveQueue is set, and reports whether it did, or
+// dropped them. ctx is the saving context of the request.
+func queueSave(ctx context.Context, id int64, part, full []byte) bool {
+	if SaveQueue <= 0 || onAppEngine && !secondGen {
+		return false
+	}
+	saveQueue.Lock()
+	if saveQueue.ch == nil {
+		saveQueue.ch = make(chan pendingSave, SaveQueue)
+		go storeQueued(saveQueue.ch)
+	}
+	ch := saveQueue.ch
+	saveQueue.Unlock()
+
+	p := pendingSave{detached{ctx}, storage(ctx), configOf(ctx), Record{id, part, full}}
+	if SaveQueueBlock {
+		ch <- p
+		return true
+	}
+	select {
+	case ch <- p:
+	default:
+		atomic.AddUint64(&saveQueue.dropped, 1)
+		logf(ctx, "WARNING", "appstats: save queue full, dropping record")
+	}
+	return true
+}
+
+// storeQueued stores the records of the save queue ch, in batches of
+// those arriving within SaveFlushInterval.
+func storeQueued(ch chan pendingSave) {
+	for p := range ch {
+		batch := []pendingSave{p}
+		if SaveFlushInterval > 0 {
+			timer := time.NewTimer(SaveFlushInterval)
+		collect:
+			for len(batch) < SaveBatchSize {
+				select {
+				case p := <-ch:
+					batch = append(batch, p)
+				case <-timer.C:
+					break collect
+				}
+			}
+			timer.Stop()
+		}
+		for len(batch) > 0 {
+			var same, rest []pendingSave
+			for _, p := range batch {
+				if p.config == batch[0].config {
+					same = append(same, p)
+				} else {
+					rest = append(rest, p)
+				}
+			}
+			storeBatch(same)
+			batch = rest
+		}
+	}
+}
+
+// storeBatch stores the records of batch, which have the same storage.
+func storeBatch(batch []pendingSave) {
+	c := batch[0].ctx
+	if bs, ok := batch[0].store.(BatchStorage); ok && len(batch) > 1 {
+		records := make([]Record, len(batch))
+		for i, p := range batch {
+			records[i] = p.Record
+		}
+		if err := bs.SaveAll(c, records); err != nil {
+			logf(c, "ERROR", "appstats Save error: %v", err)
+		}
+		return
+	}
+	for _, p := range batch {
+		if err := p.store.Save(p.ctx, p.ID, p.Part, p.Full); err != nil {
+			logf(p.ctx, "ERROR", "appstats Save error: %v", err)
+		}
+	}
+}
+
+// saveQueueStats returns the number of records in the save queue and
+// the number of records it dropped.
+func saveQueueStats() (depth int, dropped uint64) {
+	saveQueue.Lock()
+	depth = len(saveQueue.ch)
+	saveQueue.Unlock()
+	return depth, atomic.LoadUint64(&saveQueue.dropped)
+}
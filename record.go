@@ -0,0 +1,412 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mjibson/appstats/internal/recordpb"
+)
+
+// Records are encoded by a Codec, after the marker of the codec, except
+// for gob records. A gob stream never starts with a zero byte, so that
+// protoMarker, that of ProtoCodec, is told apart.
+const protoMarker = 0
+
+// gzipMarker is followed by the gzip compressed record, with
+// WithCompression. A gob stream, starting with the length of its first
+// message, a type definition, never starts with it either.
+const gzipMarker = 2
+
+// encodeRecord encodes the record of r with header h, with the codec of
+// the package settings, uncompressed. A part record has a nil header.
+func encodeRecord(r *RequestStats, h http.Header, part bool) ([]byte, error) {
+	return encodeWith(nil, r, h, part)
+}
+
+// encodeWith is encodeRecord with the codec and compression of cfg,
+// which may be nil.
+func encodeWith(cfg *config, r *RequestStats, h http.Header, part bool) ([]byte, error) {
+	b, err := encodeRawRecord(cfg.codec(), r, h, part)
+	if err != nil || !cfg.compressRecords() {
+		return b, err
+	}
+	return compressRecord(b)
+}
+
+// encodeRawRecord is encodeWith without compression.
+func encodeRawRecord(c Codec, r *RequestStats, h http.Header, part bool) ([]byte, error) {
+	b, err := c.Encode(r, h, part)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := codecMarker(c); ok {
+		b = append([]byte{m}, b...)
+	} else if c != GobCodec {
+		return nil, fmt.Errorf("appstats: codec %T is not registered", c)
+	}
+	return b, nil
+}
+
+// compressRecord returns b gzip compressed, after a gzipMarker byte.
+func compressRecord(b []byte) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{gzipMarker})
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// uncompressRecord returns the record b, decompressed if it is.
+func uncompressRecord(b []byte) ([]byte, error) {
+	if len(b) == 0 || b[0] != gzipMarker {
+		return b, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b[1:]))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// decodeRecord decodes a part or full record with the codec that
+// encoded it.
+func decodeRecord(b []byte, part bool) (*RequestStats, http.Header, error) {
+	b, err := uncompressRecord(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c := markedCodec(b); c != nil {
+		return c.Decode(b[1:], part)
+	}
+	return GobCodec.Decode(b, part)
+}
+
+// decodePart decodes a part record.
+func decodePart(b []byte) (*RequestStats, error) {
+	r, _, err := decodeRecord(b, true)
+	return r, err
+}
+
+// decodeFull decodes a full record.
+func decodeFull(b []byte) (*stats_full, error) {
+	r, h, err := decodeRecord(b, false)
+	if err != nil {
+		return nil, err
+	}
+	return &stats_full{Header: h, Stats: r}, nil
+}
+
+// The helpers below leave zero values unset, as records always have.
+
+func pbString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func pbInt64(v int64) *int64 {
+	if v == 0 {
+		return nil
+	}
+	return &v
+}
+
+func pbInt32(v int) *int32 {
+	if v == 0 {
+		return nil
+	}
+	i := int32(v)
+	return &i
+}
+
+func pbBool(v bool) *bool {
+	if !v {
+		return nil
+	}
+	return &v
+}
+
+func pbHeader(h http.Header) []*recordpb.Header {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var ph []*recordpb.Header
+	for _, k := range keys {
+		ph = append(ph, &recordpb.Header{Key: pbString(k), Values: h[k]})
+	}
+	return ph
+}
+
+func fromPBHeader(ph []*recordpb.Header) http.Header {
+	if len(ph) == 0 {
+		return nil
+	}
+	h := make(http.Header, len(ph))
+	for _, e := range ph {
+		h[e.GetKey()] = append(h[e.GetKey()], e.GetValues()...)
+	}
+	return h
+}
+
+// marshalRecord returns the Record message of r with header h.
+func marshalRecord(r *RequestStats, h http.Header) ([]byte, error) {
+	m := &recordpb.Record{
+		User:              pbString(r.User),
+		Admin:             pbBool(r.Admin),
+		Method:            pbString(r.Method),
+		Path:              pbString(r.Path),
+		Query:             pbString(r.Query),
+		Status:            pbInt32(r.Status),
+		ContentType:       pbString(r.ContentType),
+		CacheControl:      pbString(r.CacheControl),
+		Age:               pbString(r.Age),
+		Cost:              pbInt64(r.Cost),
+		Start:             pbInt64(r.Start.UnixNano()),
+		Duration:          pbInt64(int64(r.Duration)),
+		Overhead:          pbInt64(int64(r.Overhead)),
+		CloudTraceContext: pbString(r.CloudTraceContext),
+		TraceId:           pbString(r.TraceID),
+		SpanId:            pbString(r.SpanID),
+		TraceState:        pbString(r.TraceState),
+		Header:            pbHeader(h),
+		Route:             pbString(r.Route),
+		ReplayOf:          pbInt64(r.ReplayOf),
+		ResponseSize:      pbInt64(r.ResponseSize),
+		GoroutinesStart:   pbInt32(r.GoroutinesStart),
+		GoroutinesEnd:     pbInt32(r.GoroutinesEnd),
+		Parent:            pbInt64(r.Parent),
+		Panic:             pbString(r.Panic),
+		PanicStack:        pbString(r.PanicStack),
+		BodyTruncated:     pbBool(r.BodyTruncated),
+		RequestSize:       pbInt64(r.RequestSize),
+		Namespace:         pbString(r.Namespace),
+		Service:           pbString(r.Service),
+		Version:           pbString(r.Version),
+		Class:             pbString(r.Class),
+		RecordSize:        pbInt64(r.RecordSize),
+		RecordRawSize:     pbInt64(r.RecordRawSize),
+		DroppedRpcs:       pbInt32(r.DroppedRPCs),
+		DroppedDuration:   pbInt64(int64(r.DroppedDuration)),
+	}
+	if len(r.Body) > 0 {
+		m.Body = r.Body
+	}
+	for i := range r.RPCStats {
+		m.Rpcs = append(m.Rpcs, pbRPC(&r.RPCStats[i]))
+	}
+	for _, a := range r.Annotations {
+		m.Annotations = append(m.Annotations, &recordpb.Annotation{
+			Key:   pbString(a.Key),
+			Value: pbString(a.Value),
+		})
+	}
+	for _, e := range r.Events {
+		m.Events = append(m.Events, &recordpb.Event{
+			Offset:  pbInt64(int64(e.Offset)),
+			Message: pbString(e.Message),
+		})
+	}
+	for _, l := range r.Logs {
+		m.Logs = append(m.Logs, &recordpb.Log{
+			Offset:  pbInt64(int64(l.Offset)),
+			Level:   pbString(l.Level),
+			Message: pbString(l.Message),
+		})
+	}
+	if mem := r.Memory; mem != nil {
+		m.Memory = &recordpb.Memory{
+			HeapGrowth: pbInt64(mem.HeapGrowth),
+			GcPause:    pbInt64(int64(mem.GCPause)),
+		}
+		if mem.Mallocs != 0 {
+			m.Memory.Mallocs = &mem.Mallocs
+		}
+		if mem.TotalAlloc != 0 {
+			m.Memory.TotalAlloc = &mem.TotalAlloc
+		}
+		if mem.NumGC != 0 {
+			m.Memory.NumGc = &mem.NumGC
+		}
+	}
+	return proto.Marshal(m)
+}
+
+func pbRPC(s *RPCStat) *recordpb.RPC {
+	m := &recordpb.RPC{
+		Service:       pbString(s.Service),
+		Method:        pbString(s.Method),
+		Start:         pbInt64(s.Start.UnixNano()),
+		Offset:        pbInt64(int64(s.Offset)),
+		Duration:      pbInt64(int64(s.Duration)),
+		ExtraDuration: pbInt64(int64(s.ExtraDuration)),
+		StackData:     pbString(s.StackData),
+		In:            pbString(s.In),
+		Out:           pbString(s.Out),
+		Cost:          pbInt64(s.Cost),
+		Pending:       pbBool(s.Pending),
+		Hits:          pbInt32(s.Hits),
+		Misses:        pbInt32(s.Misses),
+		Reads:         pbInt32(s.Reads),
+		Writes:        pbInt32(s.Writes),
+		IndexWrites:   pbInt32(s.IndexWrites),
+		Truncated:     pbBool(s.Truncated),
+	}
+	for _, f := range s.Frames {
+		m.Frames = append(m.Frames, &recordpb.Frame{
+			Location: pbString(f.Location),
+			Call:     pbString(f.Call),
+			Lineno:   pbInt32(f.Lineno),
+		})
+	}
+	if c := s.HTTP; c != nil {
+		m.Http = &recordpb.HTTPCall{
+			Method:         pbString(c.Method),
+			Url:            pbString(c.URL),
+			Status:         pbInt32(c.Status),
+			RequestHeader:  pbHeader(c.RequestHeader),
+			ResponseHeader: pbHeader(c.ResponseHeader),
+			RequestSize:    pbInt64(int64(c.RequestSize)),
+			ResponseSize:   pbInt64(int64(c.ResponseSize)),
+		}
+	}
+	return m
+}
+
+// unmarshalRecord decodes a Record message.
+func unmarshalRecord(b []byte) (*RequestStats, http.Header, error) {
+	m := &recordpb.Record{}
+	if err := proto.Unmarshal(b, m); err != nil {
+		return nil, nil, err
+	}
+	r := &RequestStats{
+		User:              m.GetUser(),
+		Admin:             m.GetAdmin(),
+		Method:            m.GetMethod(),
+		Path:              m.GetPath(),
+		Query:             m.GetQuery(),
+		Status:            int(m.GetStatus()),
+		ContentType:       m.GetContentType(),
+		CacheControl:      m.GetCacheControl(),
+		Age:               m.GetAge(),
+		Cost:              m.GetCost(),
+		Start:             time.Unix(0, m.GetStart()),
+		Duration:          time.Duration(m.GetDuration()),
+		Overhead:          time.Duration(m.GetOverhead()),
+		CloudTraceContext: m.GetCloudTraceContext(),
+		TraceID:           m.GetTraceId(),
+		SpanID:            m.GetSpanId(),
+		TraceState:        m.GetTraceState(),
+		Route:             m.GetRoute(),
+		ReplayOf:          m.GetReplayOf(),
+		ResponseSize:      m.GetResponseSize(),
+		GoroutinesStart:   int(m.GetGoroutinesStart()),
+		GoroutinesEnd:     int(m.GetGoroutinesEnd()),
+		Parent:            m.GetParent(),
+		Panic:             m.GetPanic(),
+		PanicStack:        m.GetPanicStack(),
+		Body:              m.GetBody(),
+		BodyTruncated:     m.GetBodyTruncated(),
+		RequestSize:       m.GetRequestSize(),
+		Namespace:         m.GetNamespace(),
+		Service:           m.GetService(),
+		Version:           m.GetVersion(),
+		Class:             m.GetClass(),
+		RecordSize:        m.GetRecordSize(),
+		RecordRawSize:     m.GetRecordRawSize(),
+		DroppedRPCs:       int(m.GetDroppedRpcs()),
+		DroppedDuration:   time.Duration(m.GetDroppedDuration()),
+	}
+	for _, s := range m.GetRpcs() {
+		r.RPCStats = append(r.RPCStats, fromPBRPC(s))
+	}
+	for _, a := range m.GetAnnotations() {
+		r.Annotations = append(r.Annotations, Annotation{Key: a.GetKey(), Value: a.GetValue()})
+	}
+	for _, e := range m.GetEvents() {
+		r.Events = append(r.Events, EventStat{Offset: time.Duration(e.GetOffset()), Message: e.GetMessage()})
+	}
+	for _, l := range m.GetLogs() {
+		r.Logs = append(r.Logs, LogStat{Offset: time.Duration(l.GetOffset()), Level: l.GetLevel(), Message: l.GetMessage()})
+	}
+	if mem := m.GetMemory(); mem != nil {
+		r.Memory = &MemoryStats{
+			Mallocs:    mem.GetMallocs(),
+			TotalAlloc: mem.GetTotalAlloc(),
+			HeapGrowth: mem.GetHeapGrowth(),
+			NumGC:      mem.GetNumGc(),
+			GCPause:    time.Duration(mem.GetGcPause()),
+		}
+	}
+	return r, fromPBHeader(m.GetHeader()), nil
+}
+
+func fromPBRPC(m *recordpb.RPC) RPCStat {
+	s := RPCStat{
+		Service:       m.GetService(),
+		Method:        m.GetMethod(),
+		Start:         time.Unix(0, m.GetStart()),
+		Offset:        time.Duration(m.GetOffset()),
+		Duration:      time.Duration(m.GetDuration()),
+		ExtraDuration: time.Duration(m.GetExtraDuration()),
+		StackData:     m.GetStackData(),
+		In:            m.GetIn(),
+		Out:           m.GetOut(),
+		Cost:          m.GetCost(),
+		Pending:       m.GetPending(),
+		Hits:          int(m.GetHits()),
+		Misses:        int(m.GetMisses()),
+		Reads:         int(m.GetReads()),
+		Writes:        int(m.GetWrites()),
+		IndexWrites:   int(m.GetIndexWrites()),
+		Truncated:     m.GetTruncated(),
+	}
+	for _, f := range m.GetFrames() {
+		s.Frames = append(s.Frames, &frame{
+			Location: f.GetLocation(),
+			Call:     f.GetCall(),
+			Lineno:   int(f.GetLineno()),
+		})
+	}
+	if c := m.GetHttp(); c != nil {
+		s.HTTP = &HTTPCall{
+			Method:         c.GetMethod(),
+			URL:            c.GetUrl(),
+			Status:         int(c.GetStatus()),
+			RequestHeader:  fromPBHeader(c.GetRequestHeader()),
+			ResponseHeader: fromPBHeader(c.GetResponseHeader()),
+			RequestSize:    int(c.GetRequestSize()),
+			ResponseSize:   int(c.GetResponseSize()),
+		}
+	}
+	return s
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRecord returns a full record with most fields set, and its part
+// record, stripped as save strips it.
+func testRecord() (full, part *RequestStats) {
+	full, part = newTestRecord(), newTestRecord()
+	for i := range part.RPCStats {
+		part.RPCStats[i].StackData = ""
+		part.RPCStats[i].Frames = nil
+		part.RPCStats[i].In = ""
+		part.RPCStats[i].Out = ""
+		part.RPCStats[i].HTTP = nil
+	}
+	part.Events = nil
+	part.Logs = nil
+	part.Body = nil
+	part.PanicStack = ""
+	part.RecordSize = 2048
+	part.RecordRawSize = 4096
+	return full, part
+}
+
+func newTestRecord() *RequestStats {
+	start := time.Unix(0, 1500000000123456789)
+	return &RequestStats{
+		User:        "alice@example.com",
+		Admin:       true,
+		Method:      "POST",
+		Path:        "/checkout",
+		Query:       "cart=1&coupon=x",
+		Route:       "/checkout",
+		Status:      http.StatusCreated,
+		ContentType: "application/json",
+		Cost:        1234,
+		Start:       start,
+		Duration:    250 * time.Millisecond,
+		Overhead:    time.Millisecond,
+		RPCStats: []RPCStat{
+			{
+				Service:   "datastore_v3",
+				Method:    "Put",
+				Start:     start.Add(time.Millisecond),
+				Offset:    time.Millisecond,
+				Duration:  20 * time.Millisecond,
+				StackData: "main.handler()\n\t/app/main.go:10 +0x1\n",
+				Frames:    stack{{Location: "/app/main.go:10", Call: "main.handler()", Lineno: 10}},
+				In:        "put request",
+				Out:       "put response",
+				Cost:      1234,
+				Truncated: true,
+				Writes:    2,
+			},
+			{
+				Service:  "urlfetch",
+				Method:   "Fetch",
+				Start:    start.Add(30 * time.Millisecond),
+				Offset:   30 * time.Millisecond,
+				Duration: 100 * time.Millisecond,
+				HTTP: &HTTPCall{
+					Method:         "GET",
+					URL:            "https://example.com/price?id=1",
+					Status:         http.StatusOK,
+					RequestHeader:  http.Header{"Accept": {"application/json"}},
+					ResponseHeader: http.Header{"Content-Type": {"application/json"}},
+					ResponseSize:   42,
+				},
+			},
+		},
+		DroppedRPCs:     3,
+		DroppedDuration: 5 * time.Millisecond,
+		ResponseSize:    512,
+		TraceID:         "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:          "00f067aa0ba902b7",
+		Namespace:       "tenant",
+		Service:         "default",
+		Version:         "v1",
+		Class:           ClassTask,
+		Parent:          1499999999000000000,
+		Panic:           "boom",
+		PanicStack:      "goroutine 1 [running]:\n",
+		RequestSize:     9,
+		Body:            []byte(`{"a":1}`),
+		Annotations:     []Annotation{{Key: "cart", Value: "1"}},
+		Events:          []EventStat{{Offset: time.Millisecond, Message: "priced"}},
+		Logs:            []LogStat{{Offset: 2 * time.Millisecond, Level: "INFO", Message: "done"}},
+		GoroutinesStart: 4,
+		GoroutinesEnd:   5,
+	}
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		marker int
+	}{
+		{"gob", nil, -1},
+		{"proto", []Option{WithCodec(ProtoCodec)}, protoMarker},
+		{"gob gzip", []Option{WithCompression(true)}, gzipMarker},
+		{"proto gzip", []Option{WithCodec(ProtoCodec), WithCompression(true)}, gzipMarker},
+	}
+	full, part := testRecord()
+	h := http.Header{"User-Agent": {"test"}, "Accept": {"*/*", "text/html"}}
+	for _, test := range tests {
+		cfg := newConfig(test.opts)
+
+		b, err := encodeWith(cfg, full, h, false)
+		if err != nil {
+			t.Errorf("%s: encode full: %v", test.name, err)
+			continue
+		}
+		if m := int(b[0]); test.marker >= 0 && m != test.marker || test.marker < 0 && (m == protoMarker || m == gzipMarker) {
+			t.Errorf("%s: marker %d, want %d", test.name, m, test.marker)
+		}
+		got, err := decodeFull(b)
+		if err != nil {
+			t.Errorf("%s: decode full: %v", test.name, err)
+		} else if !reflect.DeepEqual(got.Stats, full) || !reflect.DeepEqual(got.Header, h) {
+			t.Errorf("%s: full record\ngot  %+v %v\nwant %+v %v", test.name, got.Stats, got.Header, full, h)
+		}
+
+		b, err = encodeWith(cfg, part, nil, true)
+		if err != nil {
+			t.Errorf("%s: encode part: %v", test.name, err)
+			continue
+		}
+		gotPart, err := decodePart(b)
+		if err != nil {
+			t.Errorf("%s: decode part: %v", test.name, err)
+		} else if !reflect.DeepEqual(gotPart, part) {
+			t.Errorf("%s: part record\ngot  %+v\nwant %+v", test.name, gotPart, part)
+		}
+	}
+}
+
+func TestRecordDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"gob", []byte{0x7f, 1, 2}},
+		{"proto", []byte{protoMarker, 0xff}},
+		{"gzip", []byte{gzipMarker, 1, 2, 3}},
+	}
+	for _, test := range tests {
+		if _, _, err := decodeRecord(test.b, false); err == nil {
+			t.Errorf("%s: decoded a malformed record", test.name)
+		}
+	}
+}
+
+// sliceCodec is a codec of a type that is not comparable.
+type sliceCodec []string
+
+func (sliceCodec) Encode(r *RequestStats, h http.Header, part bool) ([]byte, error) {
+	return []byte(r.Path), nil
+}
+
+func (sliceCodec) Decode(b []byte, part bool) (*RequestStats, http.Header, error) {
+	return &RequestStats{Path: string(b)}, nil, nil
+}
+
+// otherCodec is a codec that is never registered.
+type otherCodec struct{ sliceCodec }
+
+func TestRegisterCodec(t *testing.T) {
+	panics := func(f func()) (p bool) {
+		defer func() { p = recover() != nil }()
+		f()
+		return false
+	}
+	for _, m := range []byte{protoMarker, gzipMarker, 16} {
+		if !panics(func() { RegisterCodec(m, sliceCodec{}) }) {
+			t.Errorf("registered marker %d", m)
+		}
+	}
+
+	RegisterCodec(15, sliceCodec{"a"})
+	t.Cleanup(func() {
+		codecs.Lock()
+		delete(codecs.m, 15)
+		codecs.Unlock()
+	})
+	if !panics(func() { RegisterCodec(15, otherCodec{}) }) {
+		t.Error("registered marker 15 twice")
+	}
+	if !panics(func() { RegisterCodec(14, sliceCodec{"b"}) }) {
+		t.Error("registered a codec type twice")
+	}
+
+	cfg := newConfig([]Option{WithCodec(sliceCodec{"c"})})
+	b, err := encodeWith(cfg, &RequestStats{Path: "/x"}, nil, true)
+	if err != nil || len(b) == 0 || b[0] != 15 {
+		t.Fatalf("encode = %v, %v, want marker 15", b, err)
+	}
+	if r, err := decodePart(b); err != nil || r.Path != "/x" {
+		t.Errorf("decode = %+v, %v", r, err)
+	}
+
+	cfg = newConfig([]Option{WithCodec(otherCodec{})})
+	if _, err := encodeWith(cfg, &RequestStats{}, nil, true); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Errorf("encode with an unregistered codec: %v", err)
+	}
+}
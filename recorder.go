@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A Recorder is a handle on a recorded request, to annotate it from code
+// that does not otherwise need its context, such as the methods of a
+// long lived value created while serving it. All methods of a nil
+// Recorder do nothing, so the result of FromContext can always be used.
+type Recorder struct {
+	c context.Context
+}
+
+// FromContext returns the recorder of the request of c, or nil if c is
+// not from a recorded request:
+//
+//	rec := appstats.FromContext(c)
+//	...
+//	rec.Annotate("cache", "miss")
+func FromContext(c context.Context) *Recorder {
+	if recording(c) == nil {
+		return nil
+	}
+	return &Recorder{c}
+}
+
+// Annotate is like the Annotate function.
+func (r *Recorder) Annotate(key, value string) {
+	if r != nil {
+		Annotate(r.c, key, value)
+	}
+}
+
+// Event is like the Event function.
+func (r *Recorder) Event(msg string) {
+	if r != nil {
+		Event(r.c, msg)
+	}
+}
+
+// StartSpan is like the StartSpan function.
+func (r *Recorder) StartSpan(name string) func() {
+	if r == nil {
+		return func() {}
+	}
+	return StartSpan(r.c, name)
+}
+
+// RecordCall is like the RecordCall function.
+func (r *Recorder) RecordCall(service, method string, start time.Time, in, out string) {
+	if r != nil {
+		RecordCall(r.c, service, method, start, in, out)
+	}
+}
+
+// SetNamespace records ns as the App Engine namespace of the request.
+func (r *Recorder) SetNamespace(ns string) {
+	if r == nil {
+		return
+	}
+	s := recording(r.c)
+	s.lock.Lock()
+	s.Namespace = ns
+	s.lock.Unlock()
+}
+
+// SetRoute aggregates the request under route, such as /user/{id},
+// instead of its path. It is meant for routers that match requests after
+// Middleware starts recording them.
+func (r *Recorder) SetRoute(route string) {
+	if r == nil {
+		return
+	}
+	s := recording(r.c)
+	s.lock.Lock()
+	s.Route = route
+	s.lock.Unlock()
+}
@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A RedactRule removes sensitive data, such as emails, tokens or other
+// personal data, from records before they are saved or exported: from
+// their users, paths, query strings, headers, bodies, annotations,
+// events, logs, panics and RPC payloads.
+type RedactRule struct {
+	// Pattern, if set, is replaced by Replace wherever it matches.
+	Pattern *regexp.Regexp
+
+	// Replace replaces the matches of Pattern, as by
+	// Regexp.ReplaceAllString, or is [redacted] if empty.
+	Replace string
+
+	// Path, if set, is a dot-separated path, such as user.email or
+	// items.*.token, whose values are replaced by [redacted] in JSON
+	// payloads and bodies. * matches any key or array index. A path of
+	// one element also redacts the query parameter of that name.
+	// Truncated payloads are not valid JSON and are left to Pattern.
+	Path string
+}
+
+// RedactEmails is a RedactRule replacing email addresses.
+var RedactEmails = RedactRule{
+	Pattern: regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`),
+}
+
+// redactRecord applies the rules of WithRedactRules to the request
+// fields of stats, such as its path, query string, body, logs and panic.
+// Its RPCs are redacted by redactRPC as they finish.
+func redactRecord(stats *RequestStats) {
+	rules := stats.config.redact()
+	if len(rules) == 0 {
+		return
+	}
+	stats.User = redactString(rules, stats.User)
+	stats.Path = redactString(rules, stats.Path)
+	stats.Query = redactQuery(rules, stats.Query)
+	if stats.Body != nil {
+		stats.Body = []byte(redactString(rules, string(stats.Body)))
+	}
+	stats.Panic = redactString(rules, stats.Panic)
+	stats.PanicStack = redactString(rules, stats.PanicStack)
+	for i := range stats.Annotations {
+		a := &stats.Annotations[i]
+		a.Value = redactString(rules, a.Value)
+	}
+	for i := range stats.Events {
+		stats.Events[i].Message = redactString(rules, stats.Events[i].Message)
+	}
+	for i := range stats.Logs {
+		stats.Logs[i].Message = redactString(rules, stats.Logs[i].Message)
+	}
+}
+
+// redactRPC applies rules to the payloads of stat, before they are
+// truncated so that the rules see whole values, and redacts its outbound
+// HTTP call.
+func redactRPC(rules []RedactRule, stat *RPCStat) {
+	if c := stat.HTTP; c != nil {
+		redactedCall := *c
+		redactedCall.URL = redactURL(rules, c.URL)
+		redactedCall.RequestHeader = redactHeader(rules, c.RequestHeader)
+		redactedCall.ResponseHeader = redactHeader(rules, c.ResponseHeader)
+		stat.HTTP = &redactedCall
+	}
+	stat.In = redactString(rules, stat.In)
+	stat.Out = redactString(rules, stat.Out)
+}
+
+// redactString returns s with the paths of rules redacted, if s is
+// JSON, and their patterns replaced.
+func redactString(rules []RedactRule, s string) string {
+	if len(rules) == 0 || s == "" {
+		return s
+	}
+	s = redactJSON(rules, s)
+	for _, rule := range rules {
+		if rule.Pattern == nil {
+			continue
+		}
+		repl := rule.Replace
+		if repl == "" {
+			repl = redacted
+		}
+		s = rule.Pattern.ReplaceAllString(s, repl)
+	}
+	return s
+}
+
+// redactJSON returns s with the values at the paths of rules redacted,
+// if it is a JSON object or array.
+func redactJSON(rules []RedactRule, s string) string {
+	t := strings.TrimSpace(s)
+	if t == "" || t[0] != '{' && t[0] != '[' {
+		return s
+	}
+	var v interface{}
+	d := json.NewDecoder(strings.NewReader(t))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil || d.More() {
+		return s
+	}
+	changed := false
+	for _, rule := range rules {
+		if rule.Path == "" {
+			continue
+		}
+		var ok bool
+		if v, ok = redactPath(v, strings.Split(rule.Path, ".")); ok {
+			changed = true
+		}
+	}
+	if !changed {
+		return s
+	}
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return s
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// redactPath returns v with the values at path redacted, and whether
+// any was.
+func redactPath(v interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return redacted, true
+	}
+	changed := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if path[0] == "*" || path[0] == k {
+				if e, ok := redactPath(e, path[1:]); ok {
+					v[k] = e
+					changed = true
+				}
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				if e, ok := redactPath(e, path[1:]); ok {
+					v[i] = e
+					changed = true
+				}
+			}
+		}
+	}
+	return v, changed
+}
+
+// redactQuery returns the query string q with the parameters named by
+// one-element paths of rules redacted and their patterns replaced in the
+// unescaped names and values, which are escaped again. The order and
+// encoding of the other parameters is kept.
+func redactQuery(rules []RedactRule, q string) string {
+	if len(rules) == 0 || q == "" {
+		return q
+	}
+	params := strings.Split(q, "&")
+	for i, p := range params {
+		raw, value := p, ""
+		if j := strings.Index(p, "="); j >= 0 {
+			raw, value = p[:j], p[j+1:]
+		}
+		name := unescapeQuery(raw)
+		if redactParam(rules, name) {
+			value = url.QueryEscape(redacted)
+		} else if v := unescapeQuery(value); redactString(rules, v) != v {
+			value = url.QueryEscape(redactString(rules, v))
+		}
+		if rn := redactString(rules, name); rn != name {
+			raw = url.QueryEscape(rn)
+		}
+		if value != "" || strings.Contains(p, "=") {
+			params[i] = raw + "=" + value
+		} else {
+			params[i] = raw
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// redactParam reports whether a one-element path of rules names the
+// query parameter name.
+func redactParam(rules []RedactRule, name string) bool {
+	for _, rule := range rules {
+		if rule.Path == name {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeQuery returns the query string component s unescaped, or s if
+// it is malformed.
+func unescapeQuery(s string) string {
+	if u, err := url.QueryUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// redactURL returns the URL u of an outbound call with its query string
+// redacted by rules.
+func redactURL(rules []RedactRule, u string) string {
+	if len(rules) == 0 {
+		return u
+	}
+	i := strings.Index(u, "?")
+	if i < 0 {
+		return redactString(rules, u)
+	}
+	return redactString(rules, u[:i]) + "?" + redactQuery(rules, u[i+1:])
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var testRules = []RedactRule{
+	RedactEmails,
+	{Path: "password"},
+	{Path: "token"},
+	{Path: "user.token"},
+	{Path: "items.*.card"},
+	{Pattern: regexp.MustCompile(`\b\d{16}\b`), Replace: "XXXX"},
+}
+
+func TestRedactString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"contact alice@example.com now", "contact [redacted] now"},
+		{"card 4111111111111111", "card XXXX"},
+		{`{"password":"hunter2","name":"bob"}`, `{"name":"bob","password":"[redacted]"}`},
+		{` {"user":{"token":"t","id":1}}`, `{"user":{"id":1,"token":"[redacted]"}}`},
+		{`{"items":[{"card":"4111111111111111"},{"card":"x","n":2.50}]}`, `{"items":[{"card":"[redacted]"},{"card":"[redacted]","n":2.50}]}`},
+		{`{"password":"x","q":"<a>&"}`, `{"password":"[redacted]","q":"<a>&"}`},
+		{`{"email":"alice@example.com"}`, `{"email":"[redacted]"}`},
+		// Paths are rooted: an array is not an object with the key.
+		{`[{"password":"a"}]`, `[{"password":"a"}]`},
+		{`{"user":"bob"}`, `{"user":"bob"}`},
+		// Truncated and trailing JSON is left to patterns.
+		{`{"password":"hun`, `{"password":"hun`},
+		{`{"password":"a"} {"password":"b"}`, `{"password":"a"} {"password":"b"}`},
+	}
+	for _, test := range tests {
+		if got := redactString(testRules, test.in); got != test.want {
+			t.Errorf("redactString(%q) = %q, want %q", test.in, got, test.want)
+		}
+		if got := redactString(nil, test.in); got != test.in {
+			t.Errorf("redactString(nil, %q) = %q", test.in, got)
+		}
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"a=1&token=abc&b=2", "a=1&token=%5Bredacted%5D&b=2"},
+		{"to=alice%40example.com", "to=%5Bredacted%5D"},
+		{"to=alice@example.com&x", "to=%5Bredacted%5D&x"},
+		{"bob%40example.com=1", "%5Bredacted%5D=1"},
+		{"flag&token", "flag&token=%5Bredacted%5D"},
+		{"empty=&token=", "empty=&token=%5Bredacted%5D"},
+		// The encoding of other parameters is kept.
+		{"q=a+b&r=%7E&x=%zz", "q=a+b&r=%7E&x=%zz"},
+		{"password=s&b=1", "password=%5Bredacted%5D&b=1"},
+	}
+	for _, test := range tests {
+		if got := redactQuery(testRules, test.in); got != test.want {
+			t.Errorf("redactQuery(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestRedactRecord(t *testing.T) {
+	newRecord := func(opts ...Option) *RequestStats {
+		return &RequestStats{
+			User:        "alice@example.com",
+			Path:        "/users/alice@example.com",
+			Query:       "token=t&page=2",
+			Body:        []byte(`{"password":"p","n":1}`),
+			Panic:       "no user alice@example.com",
+			PanicStack:  "main.get(\"alice@example.com\")",
+			Annotations: []Annotation{{Key: "email", Value: "alice@example.com"}},
+			Events:      []EventStat{{Message: "mailed alice@example.com"}},
+			Logs:        []LogStat{{Message: `login {"password":"p"}`}},
+			config:      newConfig(opts),
+		}
+	}
+
+	got := newRecord(WithRedactRules(testRules...))
+	redactRecord(got)
+	want := newRecord(WithRedactRules(testRules...))
+	want.User = "[redacted]"
+	want.Path = "/users/[redacted]"
+	want.Query = "token=%5Bredacted%5D&page=2"
+	want.Body = []byte(`{"n":1,"password":"[redacted]"}`)
+	want.Panic = "no user [redacted]"
+	want.PanicStack = "main.get(\"[redacted]\")"
+	want.Annotations[0].Value = "[redacted]"
+	want.Events[0].Message = "mailed [redacted]"
+	// Logs are not JSON, so paths do not apply to them.
+	want.Logs[0].Message = `login {"password":"p"}`
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redactRecord:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	got, want = newRecord(), newRecord()
+	redactRecord(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redactRecord without rules:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestRedactRPC(t *testing.T) {
+	reqHeader := http.Header{
+		"Authorization": {"Bearer secret"},
+		"X-User":        {"alice@example.com", "bob"},
+		"Accept":        {"*/*"},
+	}
+	call := &HTTPCall{
+		Method:         "POST",
+		URL:            "https://api.example.com/u/alice@example.com?token=t&x=1",
+		RequestHeader:  reqHeader,
+		ResponseHeader: http.Header{"Content-Type": {"application/json"}},
+	}
+	stat := RPCStat{
+		In:   `{"token":"t","q":1}`,
+		Out:  "sent to alice@example.com",
+		HTTP: call,
+	}
+	redactRPC(testRules, &stat)
+
+	if want := "https://api.example.com/u/[redacted]?token=%5Bredacted%5D&x=1"; stat.HTTP.URL != want {
+		t.Errorf("URL = %q, want %q", stat.HTTP.URL, want)
+	}
+	wantHeader := http.Header{
+		"Authorization": {"[redacted]"},
+		"X-User":        {"[redacted]", "bob"},
+		"Accept":        {"*/*"},
+	}
+	if !reflect.DeepEqual(stat.HTTP.RequestHeader, wantHeader) {
+		t.Errorf("RequestHeader = %v, want %v", stat.HTTP.RequestHeader, wantHeader)
+	}
+	if !reflect.DeepEqual(stat.HTTP.ResponseHeader, call.ResponseHeader) {
+		t.Errorf("ResponseHeader = %v, want %v", stat.HTTP.ResponseHeader, call.ResponseHeader)
+	}
+	if want := `{"q":1,"token":"[redacted]"}`; stat.In != want {
+		t.Errorf("In = %q, want %q", stat.In, want)
+	}
+	if want := "sent to [redacted]"; stat.Out != want {
+		t.Errorf("Out = %q, want %q", stat.Out, want)
+	}
+
+	// The call of the app is left unchanged.
+	if call.URL != "https://api.example.com/u/alice@example.com?token=t&x=1" || reqHeader.Get("Authorization") != "Bearer secret" || reqHeader["X-User"][0] != "alice@example.com" {
+		t.Errorf("redactRPC changed the original call: %+v", call)
+	}
+}
+
+func TestFilterHeader(t *testing.T) {
+	h := http.Header{
+		"Cookie":     {"session=s"},
+		"From":       {"alice@example.com"},
+		"User-Agent": {"test"},
+		recordHeader: {"token"},
+	}
+	want := http.Header{
+		"Cookie":     {"[redacted]"},
+		"From":       {"[redacted]"},
+		"User-Agent": {"test"},
+	}
+	if got := filterHeader(h, testRules); !reflect.DeepEqual(got, want) {
+		t.Errorf("filterHeader = %v, want %v", got, want)
+	}
+	if h.Get("From") != "alice@example.com" || h.Get(recordHeader) != "token" {
+		t.Errorf("filterHeader changed its header: %v", h)
+	}
+	if got := filterHeader(nil, testRules); got != nil {
+		t.Errorf("filterHeader(nil) = %v", got)
+	}
+}
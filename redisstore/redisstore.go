@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package redisstore provides an appstats.Storage that keeps recorded
+requests in Redis, for apps that do not run on classic App Engine.
+
+	appstats.Store = &redisstore.Store{
+		Pool: &redis.Pool{
+			Dial: func() (redis.Conn, error) { return redis.Dial("tcp", ":6379") },
+		},
+	}
+*/
+package redisstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mjibson/appstats"
+
+	"golang.org/x/net/context"
+)
+
+var _ appstats.Storage = (*Store)(nil)
+
+const (
+	// DefaultPrefix is the key prefix used when Store.Prefix is empty.
+	DefaultPrefix = "appstats:"
+
+	// DefaultTTL is the record lifetime used when Store.TTL is zero.
+	DefaultTTL = 30 * time.Minute
+
+	// DefaultMaxRecords is the number of records listed when
+	// Store.MaxRecords is zero.
+	DefaultMaxRecords = 1000
+)
+
+// Store is an appstats.Storage backed by Redis. Records are stored as
+// plain keys expiring after TTL; a sorted set indexes them by ID.
+type Store struct {
+	// Pool provides connections to the Redis server.
+	Pool *redis.Pool
+
+	// Prefix is prepended to all keys.
+	Prefix string
+
+	// TTL is how long records are kept.
+	TTL time.Duration
+
+	// MaxRecords is the maximum number of most recent records returned
+	// by List.
+	MaxRecords int
+}
+
+func (s *Store) prefix() string {
+	if s.Prefix == "" {
+		return DefaultPrefix
+	}
+	return s.Prefix
+}
+
+func (s *Store) ttl() time.Duration {
+	if s.TTL == 0 {
+		return DefaultTTL
+	}
+	return s.TTL
+}
+
+func (s *Store) maxRecords() int {
+	if s.MaxRecords == 0 {
+		return DefaultMaxRecords
+	}
+	return s.MaxRecords
+}
+
+func (s *Store) indexKey() string {
+	return s.prefix() + "index"
+}
+
+func (s *Store) partKey(id int64) string {
+	return fmt.Sprintf("%s%d:part", s.prefix(), id)
+}
+
+func (s *Store) fullKey(id int64) string {
+	return fmt.Sprintf("%s%d:full", s.prefix(), id)
+}
+
+// Save implements appstats.Storage.
+func (s *Store) Save(c context.Context, id int64, part, full []byte) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	ttl := s.ttl()
+	expired := time.Now().Add(-ttl).UnixNano()
+	conn.Send("MULTI")
+	conn.Send("SET", s.partKey(id), part, "PX", int64(ttl/time.Millisecond))
+	conn.Send("SET", s.fullKey(id), full, "PX", int64(ttl/time.Millisecond))
+	conn.Send("ZADD", s.indexKey(), id, id)
+	conn.Send("ZREMRANGEBYSCORE", s.indexKey(), "-inf", expired)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+func (s *Store) load(key string) ([]byte, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+	return redis.Bytes(conn.Do("GET", key))
+}
+
+// LoadPart implements appstats.Storage.
+func (s *Store) LoadPart(c context.Context, id int64) ([]byte, error) {
+	return s.load(s.partKey(id))
+}
+
+// LoadFull implements appstats.Storage.
+func (s *Store) LoadFull(c context.Context, id int64) ([]byte, error) {
+	return s.load(s.fullKey(id))
+}
+
+// List implements appstats.Storage.
+func (s *Store) List(c context.Context) ([][]byte, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Int64s(conn.Do("ZREVRANGE", s.indexKey(), 0, s.maxRecords()-1))
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	args := make(redis.Args, len(ids))
+	for i, id := range ids {
+		args[i] = s.partKey(id)
+	}
+	values, err := redis.ByteSlices(conn.Do("MGET", args...))
+	if err != nil {
+		return nil, err
+	}
+	records := values[:0]
+	for _, v := range values {
+		// Expired records are still in the index until the next Save.
+		if v != nil {
+			records = append(records, v)
+		}
+	}
+	return records, nil
+}
+
+// Ping implements appstats.Storage.
+func (s *Store) Ping(c context.Context) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.String(conn.Do("PING"))
+	if err != nil {
+		return fmt.Errorf("redisstore: %v", err)
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("redisstore: unexpected ping reply %q", reply)
+	}
+	return nil
+}
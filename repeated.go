@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"regexp"
+	"sort"
+	"time"
+)
+
+// batchCalls suggests, by RPC, how to batch repeated calls.
+var batchCalls = map[string]string{
+	"datastore_v3.Get":    "datastore.GetMulti",
+	"datastore_v3.Put":    "datastore.PutMulti",
+	"datastore_v3.Delete": "datastore.DeleteMulti",
+	"memcache.Get":        "memcache.GetMulti",
+	"memcache.Set":        "memcache.SetMulti",
+	"memcache.Delete":     "memcache.DeleteMulti",
+	"taskqueue.Add":       "taskqueue.AddMulti",
+}
+
+var (
+	shapeString = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+	shapeNumber = regexp.MustCompile(`\b-?[0-9][0-9.eE+-]*\b`)
+)
+
+// payloadShape returns the request payload p with its string and
+// numeric values blanked, so that calls differing only by key or ID
+// have the same shape.
+func payloadShape(p string) string {
+	p = shapeString.ReplaceAllString(p, `""`)
+	return shapeNumber.ReplaceAllString(p, "0")
+}
+
+// repeatedCall is a likely N+1 pattern: calls to the same RPC with the
+// same request shape, each issued after the previous one finished.
+type repeatedCall struct {
+	Name string
+	// Calls are the indexes of the calls in the request's RPCStats.
+	Calls    []int
+	Duration time.Duration
+	// Savings estimates the time saved by issuing the calls as one,
+	// taking as long as the slowest of them.
+	Savings time.Duration
+}
+
+// Batch returns how to batch the calls.
+func (r repeatedCall) Batch() string {
+	if b, ok := batchCalls[r.Name]; ok {
+		return "use " + b
+	}
+	return "batch them or issue them concurrently"
+}
+
+// findRepeated returns the likely N+1 patterns in rpcs, at least
+// RepeatedCalls long, by decreasing Savings. Calls overlapping the
+// previous call of their pattern ran concurrently and are left out, as
+// are spans.
+func findRepeated(rpcs []RPCStat) []repeatedCall {
+	if RepeatedCalls < 2 {
+		return nil
+	}
+	type key struct{ name, shape string }
+	runs := make(map[key]*repeatedCall)
+	var order []key
+	slowest := make(map[key]time.Duration)
+	for i, s := range rpcs {
+		if s.Service == spanService {
+			continue
+		}
+		k := key{s.Name(), payloadShape(s.In)}
+		r := runs[k]
+		if r == nil {
+			r = &repeatedCall{Name: k.name}
+			runs[k] = r
+			order = append(order, k)
+		} else {
+			last := rpcs[r.Calls[len(r.Calls)-1]]
+			if s.Offset < last.Offset+last.Duration {
+				continue
+			}
+		}
+		r.Calls = append(r.Calls, i)
+		r.Duration += s.Duration
+		if s.Duration > slowest[k] {
+			slowest[k] = s.Duration
+		}
+	}
+
+	var found []repeatedCall
+	for _, k := range order {
+		r := runs[k]
+		if len(r.Calls) < RepeatedCalls {
+			continue
+		}
+		r.Savings = r.Duration - slowest[k]
+		found = append(found, *r)
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Savings > found[j].Savings
+	})
+	return found
+}
+
+// batchOpportunity totals, for a path, the repeated calls to an RPC
+// that has a batch form.
+type batchOpportunity struct {
+	Path, Name string
+	// Requests is the number of requests of Path making such calls, and
+	// Calls the number of calls they made.
+	Requests, Calls int
+	Savings         time.Duration
+}
+
+// AvgCalls returns the average number of repeated calls per request.
+func (b batchOpportunity) AvgCalls() float64 {
+	return float64(b.Calls) / float64(b.Requests)
+}
+
+// Batch returns how to batch the calls.
+func (b batchOpportunity) Batch() string {
+	return batchCalls[b.Name]
+}
+
+// batchingOpportunities finds the repeated calls to RPCs that have a
+// batch form in ars, totaled by path and RPC, by decreasing Savings.
+// Records without payloads are compared by RPC name only.
+func batchingOpportunities(ars allrequestStats) []batchOpportunity {
+	type key struct{ path, name string }
+	byKey := make(map[key]*batchOpportunity)
+	for _, r := range ars {
+		for _, rc := range findRepeated(r.RPCStats) {
+			if _, ok := batchCalls[rc.Name]; !ok {
+				continue
+			}
+			k := key{r.aggregatePath(), rc.Name}
+			b := byKey[k]
+			if b == nil {
+				b = &batchOpportunity{Path: k.path, Name: k.name}
+				byKey[k] = b
+			}
+			b.Requests++
+			b.Calls += len(rc.Calls)
+			b.Savings += rc.Savings
+		}
+	}
+
+	found := make([]batchOpportunity, 0, len(byKey))
+	for _, b := range byKey {
+		found = append(found, *b)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Savings != found[j].Savings {
+			return found[i].Savings > found[j].Savings
+		}
+		if found[i].Path != found[j].Path {
+			return found[i].Path < found[j].Path
+		}
+		return found[i].Name < found[j].Name
+	})
+	return found
+}
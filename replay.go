@@ -0,0 +1,190 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/urlfetch"
+)
+
+// replayHeader carries the id of the replayed request, signed by signID.
+// Requests with a valid signature are always recorded, with ReplayOf set.
+const replayHeader = "X-Appstats-Replay-Of"
+
+// replayRequest returns the request replaying the record s with header
+// h, to the app at the host of r, a request to the dashboard, signed
+// with token.
+func replayRequest(s *RequestStats, h http.Header, r *http.Request, token string) (*http.Request, error) {
+	scheme := "http"
+	if onAppEngine && !appengine.IsDevAppServer() || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	u := scheme + "://" + r.Host + s.Path
+	if s.Query != "" {
+		u += "?" + s.Query
+	}
+	var body io.Reader
+	if len(s.Body) > 0 && !s.BodyTruncated {
+		body = bytes.NewReader(s.Body)
+	}
+	req, err := http.NewRequest(s.Method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range ReplayHeaders {
+		for _, v := range h[http.CanonicalHeaderKey(k)] {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set(replayHeader, signID(token, s.ID()))
+	return req, nil
+}
+
+// httpClient returns the client of the requests made by the dashboard:
+// a urlfetch client on first generation App Engine, whose apps cannot
+// make requests otherwise.
+func httpClient(c context.Context) *http.Client {
+	if onAppEngine && !secondGen {
+		return urlfetch.Client(c)
+	}
+	return http.DefaultClient
+}
+
+// findReplay returns the id of the first record replaying request id
+// that started after begin, waiting up to ReplayWait for it to be
+// saved.
+func findReplay(c context.Context, id int64, begin time.Time) (int64, error) {
+	deadline := time.Now().Add(ReplayWait)
+	for {
+		records, err := storage(c).List(c)
+		if err != nil {
+			return 0, err
+		}
+		var found int64
+		for _, b := range records {
+			s, err := decodePart(b)
+			if err != nil || s.ReplayOf != id || s.Start.Before(begin) {
+				continue
+			}
+			if found == 0 || s.ID() < found {
+				found = s.ID()
+			}
+		}
+		if found != 0 {
+			return found, nil
+		}
+		if time.Now().After(deadline) {
+			return 0, errNotFound
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// replay reissues the request given by the time parameter to the app
+// and redirects to the comparison of the original and replayed traces.
+// Requests are replayed with their body if it was recorded whole, and
+// without otherwise.
+func replay(c context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "replay requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	token := configOf(c).recordToken()
+	if token == "" {
+		http.Error(w, "replay requires the WithRecordToken option to sign the replayed request", http.StatusNotImplemented)
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("time"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad time parameter", http.StatusBadRequest)
+		return
+	}
+	b, err := storage(c).LoadFull(c, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	full, err := decodeFull(b)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	req, err := replayRequest(full.Stats, full.Header, r, token)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+
+	begin := time.Now()
+	resp, err := httpClient(c).Do(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("replay failed: %v", err), http.StatusBadGateway)
+		return
+	}
+	resp.Body.Close()
+
+	replayed, err := findReplay(c, id, begin)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("replayed with status %s, but its trace was not found: %v", resp.Status, err), http.StatusGatewayTimeout)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("compare?a=%d&b=%d", id, replayed), http.StatusSeeOther)
+}
+
+// comparePage shows two requests side by side, usually a request and
+// its replay.
+func comparePage(c context.Context, w http.ResponseWriter, r *http.Request) {
+	ida, _ := strconv.ParseInt(r.FormValue("a"), 10, 64)
+	idb, _ := strconv.ParseInt(r.FormValue("b"), 10, 64)
+	a, err := loadDetails(c, ida)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	b, err := loadDetails(c, idb)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	ra, rb := NewRollup(a.Record.Start), NewRollup(b.Record.Start)
+	ra.Add(a.Record)
+	rb.Add(b.Record)
+
+	v := struct {
+		Env  map[string]string
+		A, B *details
+		RPCs []diffRow
+	}{
+		Env: map[string]string{
+			"APPLICATION_ID": appengine.AppID(c),
+		},
+		A:    a,
+		B:    b,
+		RPCs: diffRows(ra.RPCs, rb.RPCs),
+	}
+
+	_ = templates.ExecuteTemplate(w, "compare", v)
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/memcache"
+)
+
+// Aggregate holds the totals of a set of requests or RPCs.
+type Aggregate struct {
+	Count    int
+	Cost     int64
+	Duration time.Duration
+
+	// Latency counts the requests or RPCs by the latency buckets of
+	// the dashboard histograms. Rollups stored by older versions do
+	// not have it.
+	Latency []int
+}
+
+func (a *Aggregate) add(cost int64, d time.Duration) {
+	a.Count++
+	a.Cost += cost
+	a.Duration += d
+	if a.Latency == nil {
+		a.Latency = make([]int, len(latencyBounds)+1)
+	}
+	a.Latency[latencyBucketOf(d)]++
+}
+
+func (a *Aggregate) merge(b *Aggregate) {
+	a.Count += b.Count
+	a.Cost += b.Cost
+	a.Duration += b.Duration
+	for len(a.Latency) < len(b.Latency) {
+		a.Latency = append(a.Latency, 0)
+	}
+	for i, n := range b.Latency {
+		a.Latency[i] += n
+	}
+}
+
+// AvgDuration returns the average duration.
+func (a Aggregate) AvgDuration() time.Duration {
+	if a.Count == 0 {
+		return 0
+	}
+	return a.Duration / time.Duration(a.Count)
+}
+
+// AvgCost returns the average cost.
+func (a Aggregate) AvgCost() float64 {
+	if a.Count == 0 {
+		return 0
+	}
+	return float64(a.Cost) / float64(a.Count)
+}
+
+// A Rollup holds the totals, by path and by RPC, of the requests
+// started in a time bucket of RollupWidth.
+type Rollup struct {
+	Start time.Time
+	Paths map[string]*Aggregate
+	RPCs  map[string]*Aggregate
+}
+
+// NewRollup returns an empty rollup for the bucket of t.
+func NewRollup(t time.Time) *Rollup {
+	return newRollupAt(t.Truncate(RollupWidth))
+}
+
+func newRollupAt(start time.Time) *Rollup {
+	return &Rollup{
+		Start: start,
+		Paths: make(map[string]*Aggregate),
+		RPCs:  make(map[string]*Aggregate),
+	}
+}
+
+func aggregate(m map[string]*Aggregate, name string) *Aggregate {
+	a := m[name]
+	if a == nil {
+		a = &Aggregate{}
+		m[name] = a
+	}
+	return a
+}
+
+// Add adds request s to r.
+func (r *Rollup) Add(s *RequestStats) {
+	aggregate(r.Paths, s.aggregatePath()).add(s.Cost, s.Duration)
+	for _, rpc := range s.RPCStats {
+		aggregate(r.RPCs, rpc.Name()).add(rpc.Cost, rpc.Duration)
+	}
+}
+
+// Total returns the totals of all requests of r.
+func (r *Rollup) Total() Aggregate {
+	var t Aggregate
+	for _, a := range r.Paths {
+		t.merge(a)
+	}
+	return t
+}
+
+// Merge adds the totals of o to r.
+func (r *Rollup) Merge(o *Rollup) {
+	for k, a := range o.Paths {
+		aggregate(r.Paths, k).merge(a)
+	}
+	for k, a := range o.RPCs {
+		aggregate(r.RPCs, k).merge(a)
+	}
+}
+
+// RollupStorage stores rollups by the start of their bucket.
+type RollupStorage interface {
+	// Add merges r into the stored rollup of its bucket.
+	Add(c context.Context, r *Rollup) error
+	// Load returns the stored rollups of the buckets starting in
+	// [start, end).
+	Load(c context.Context, start, end time.Time) ([]*Rollup, error)
+}
+
+// MemcacheRollups is a RollupStorage keeping rollups in memcache, in
+// the Namespace namespace, for RollupExpiration.
+type MemcacheRollups struct{}
+
+var _ RollupStorage = MemcacheRollups{}
+
+const keyRollup = keyPrefix + "rollup:%d"
+
+// maxRollups is the largest number of buckets loaded at once.
+const maxRollups = 10000
+
+func encodeRollup(r *Rollup) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(r)
+	return buf.Bytes(), err
+}
+
+func decodeRollup(b []byte) (*Rollup, error) {
+	r := &Rollup{}
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(r)
+	if r.Paths == nil {
+		r.Paths = make(map[string]*Aggregate)
+	}
+	if r.RPCs == nil {
+		r.RPCs = make(map[string]*Aggregate)
+	}
+	return r, err
+}
+
+var errRollupContention = errors.New("appstats: rollup update contention")
+
+// Add implements RollupStorage with a compare-and-swap loop.
+func (MemcacheRollups) Add(c context.Context, r *Rollup) error {
+	nc, err := MemcacheStorage{}.context(c)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf(keyRollup, r.Start.Unix())
+	for i := 0; i < 3; i++ {
+		item, err := memcache.Get(nc, key)
+		if err == memcache.ErrCacheMiss {
+			b, err := encodeRollup(r)
+			if err != nil {
+				return err
+			}
+			err = memcache.Add(nc, &memcache.Item{
+				Key:        key,
+				Value:      b,
+				Expiration: RollupExpiration,
+			})
+			if err == memcache.ErrNotStored {
+				continue
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+		stored, err := decodeRollup(item.Value)
+		if err != nil {
+			return err
+		}
+		stored.Merge(r)
+		if item.Value, err = encodeRollup(stored); err != nil {
+			return err
+		}
+		item.Expiration = RollupExpiration
+		err = memcache.CompareAndSwap(nc, item)
+		if err == memcache.ErrCASConflict {
+			continue
+		}
+		return err
+	}
+	return errRollupContention
+}
+
+// Load implements RollupStorage.
+func (MemcacheRollups) Load(c context.Context, start, end time.Time) ([]*Rollup, error) {
+	nc, err := MemcacheStorage{}.context(c)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for t := start.Truncate(RollupWidth); t.Before(end); t = t.Add(RollupWidth) {
+		if t.Before(start) {
+			continue
+		}
+		if len(keys) == maxRollups {
+			return nil, fmt.Errorf("appstats: more than %d rollups requested", maxRollups)
+		}
+		keys = append(keys, fmt.Sprintf(keyRollup, t.Unix()))
+	}
+	items, err := memcache.GetMulti(nc, keys)
+	if err != nil {
+		return nil, err
+	}
+	rollups := make([]*Rollup, 0, len(items))
+	for _, item := range items {
+		r, err := decodeRollup(item.Value)
+		if err != nil {
+			continue
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, nil
+}
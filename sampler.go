@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AdaptiveSampler returns a sampler, for WithSampler, recording about
+// perMinute requests a minute on each instance, whatever the traffic, to
+// keep the writes to the storage stable:
+//
+//	appstats.NewHandler(h, appstats.WithSampler(appstats.AdaptiveSampler(60)))
+//
+// Each minute, requests are sampled with the fraction that would have
+// recorded perMinute of the requests of the previous minute, so the
+// fraction drops during traffic spikes and rises again when traffic is
+// quiet. Requests beyond perMinute in a minute are never recorded.
+// Requests are sampled at random.
+func AdaptiveSampler(perMinute int) func(r *http.Request) bool {
+	s := &adaptiveSampler{target: perMinute}
+	return s.sample
+}
+
+type adaptiveSampler struct {
+	target int
+
+	sync.Mutex
+	// start is the start of the current minute, in which seen requests
+	// were sampled with fraction, and recorded recorded.
+	start          time.Time
+	seen, recorded int
+	fraction       float64
+}
+
+func (s *adaptiveSampler) sample(r *http.Request) bool {
+	now := time.Now()
+	s.Lock()
+	if elapsed := now.Sub(s.start); elapsed >= time.Minute {
+		s.fraction = 1
+		if s.seen > 0 && elapsed < 2*time.Minute {
+			s.fraction = float64(s.target) / float64(s.seen)
+		}
+		s.start = now
+		s.seen, s.recorded = 0, 0
+	}
+	s.seen++
+	fraction, full := s.fraction, s.recorded >= s.target
+	s.Unlock()
+
+	if full || !sampled(r, fraction, nil) {
+		return false
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.recorded >= s.target {
+		return false
+	}
+	s.recorded++
+	return true
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+)
+
+// serviceStat totals the requests of a version of a service.
+type serviceStat struct {
+	Service, Version string
+	// URL is the dashboard of the service, empty for this one.
+	URL      string
+	Requests int
+	Errors   int
+	Cost     int64
+	Duration time.Duration
+	P95      time.Duration
+}
+
+// AvgDuration returns the average duration of the requests.
+func (s *serviceStat) AvgDuration() time.Duration {
+	return s.Duration / time.Duration(s.Requests)
+}
+
+// serviceRequest is a request of a service, with the dashboard showing
+// it.
+type serviceRequest struct {
+	*RequestStats
+	URL string
+}
+
+// byService totals reqs by dashboard, service and version, in order of
+// service and most requests first.
+func byService(reqs []serviceRequest) []*serviceStat {
+	type key struct{ url, service, version string }
+	services := make(map[key]*serviceStat)
+	durations := make(map[key][]time.Duration)
+	var stats []*serviceStat
+	for _, r := range reqs {
+		k := key{r.URL, r.Service, r.Version}
+		s := services[k]
+		if s == nil {
+			s = &serviceStat{Service: r.Service, Version: r.Version, URL: r.URL}
+			services[k] = s
+			stats = append(stats, s)
+		}
+		s.Requests++
+		if r.Failed() {
+			s.Errors++
+		}
+		s.Cost += r.Cost
+		s.Duration += r.Duration
+		durations[k] = append(durations[k], r.Duration)
+	}
+	for _, s := range stats {
+		d := durations[key{s.URL, s.Service, s.Version}]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		s.P95 = percentile(d, 95)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Service != stats[j].Service {
+			return stats[i].Service < stats[j].Service
+		}
+		return stats[i].Requests > stats[j].Requests
+	})
+	return stats
+}
+
+// fetchService returns the requests of the service whose dashboard is
+// at url matching f, from its JSON API.
+func fetchService(c context.Context, name, url string, f *filter) (allrequestStats, error) {
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	v := f.values()
+	v.Set("size", "1000000")
+	resp, err := httpClient(c).Get(url + apiRequestsURL + "?" + v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	var body struct {
+		Requests allrequestStats
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	for _, r := range body.Requests {
+		if r.Service == "" {
+			r.Service = name
+		}
+	}
+	return body.Requests, nil
+}
+
+// servicesPage shows the requests of this service and of those of
+// WithServices, fetched concurrently.
+func servicesPage(c context.Context, w http.ResponseWriter, r *http.Request) {
+	ars, err := loadRequests(c, r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	f := newFilter(r)
+	var reqs []serviceRequest
+	for _, r := range ars {
+		reqs = append(reqs, serviceRequest{RequestStats: r})
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		errors []string
+	)
+	for name, url := range configOf(c).servicesMap() {
+		wg.Add(1)
+		go func(name, url string) {
+			defer wg.Done()
+			ars, err := fetchService(c, name, url, f)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("%s: %v", name, err))
+				return
+			}
+			for _, r := range ars {
+				reqs = append(reqs, serviceRequest{RequestStats: r, URL: url})
+			}
+		}(name, url)
+	}
+	wg.Wait()
+	sort.Strings(errors)
+	sort.SliceStable(reqs, func(i, j int) bool {
+		return reqs[i].Start.After(reqs[j].Start)
+	})
+	for _, e := range errors {
+		logf(c, "WARNING", "appstats services: %v", e)
+	}
+
+	recent := reqs
+	if len(recent) > PageSize {
+		recent = recent[:PageSize]
+	}
+	v := struct {
+		Env      map[string]string
+		Filter   *filter
+		Services []*serviceStat
+		Recent   []serviceRequest
+		Errors   []string
+	}{
+		Env: map[string]string{
+			"APPLICATION_ID": appengine.AppID(c),
+		},
+		Filter:   f,
+		Services: byService(reqs),
+		Recent:   recent,
+		Errors:   errors,
+	}
+
+	_ = templates.ExecuteTemplate(w, "services", v)
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rpcShardCount is the number of shards the RPCs of a request are
+// recorded in, so that the RPCs of concurrent goroutines rarely wait
+// for each other.
+const rpcShardCount = 16
+
+// defaultMaxRPCs is the number of RPCs recorded per request unless given
+// by WithMaxRPCs.
+const defaultMaxRPCs = 1000
+
+// rpcShards holds the RPCs of a request while it runs. They are merged
+// into RPCStats when the request is saved.
+type rpcShards struct {
+	// next numbers the RPCs in the order they start, and picks their
+	// shard.
+	next   uint32
+	shards [rpcShardCount]rpcShard
+
+	// dropped counts the RPCs beyond WithMaxRPCs, with their total
+	// duration and cost.
+	dropped, droppedDuration, droppedCost int64
+}
+
+// An rpcShard holds some of the RPCs of a request, with their total cost
+// and recording overhead. Once merged, the RPCs that finish later are
+// dropped.
+type rpcShard struct {
+	sync.Mutex
+	rpcs     []shardRPC
+	cost     int64
+	overhead time.Duration
+	merged   bool
+
+	// pad keeps shards on separate cache lines.
+	pad [64]byte
+}
+
+type shardRPC struct {
+	seq  uint32
+	stat RPCStat
+}
+
+// An rpcRef locates an RPC recorded by startRPC, for finishRPC. The
+// shard of the RPCs beyond WithMaxRPCs is nil.
+type rpcRef struct {
+	shards *rpcShards
+	shard  *rpcShard
+	index  int
+}
+
+// shards returns the shards of s, allocated on first use.
+func (s *RequestStats) shards() *rpcShards {
+	if sh, ok := s.rpcs.Load().(*rpcShards); ok {
+		return sh
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sh, ok := s.rpcs.Load().(*rpcShards); ok {
+		return sh
+	}
+	sh := new(rpcShards)
+	s.rpcs.Store(sh)
+	return sh
+}
+
+// startRPC records the pending RPC stat, whose recording took overhead
+// so far.
+func (s *RequestStats) startRPC(stat RPCStat, overhead time.Duration) rpcRef {
+	sh := s.shards()
+	seq := atomic.AddUint32(&sh.next, 1)
+	if max := s.config.maxRPCs(); max > 0 && seq > uint32(max) {
+		return rpcRef{shards: sh}
+	}
+	shard := &sh.shards[seq%rpcShardCount]
+	shard.Lock()
+	defer shard.Unlock()
+	if shard.merged {
+		return rpcRef{shards: sh}
+	}
+	ref := rpcRef{sh, shard, len(shard.rpcs)}
+	shard.rpcs = append(shard.rpcs, shardRPC{seq, stat})
+	shard.overhead += overhead
+	return ref
+}
+
+// rpcsFull reports whether s has recorded the RPCs of WithMaxRPCs, so
+// that the next are dropped.
+func (s *RequestStats) rpcsFull() bool {
+	max := s.config.maxRPCs()
+	return max > 0 && atomic.LoadUint32(&s.shards().next) >= uint32(max)
+}
+
+// finishRPC replaces the RPC of ref, started by startRPC, with stat,
+// adding overhead to that of its recording.
+func (s *RequestStats) finishRPC(ref rpcRef, stat RPCStat, overhead time.Duration) {
+	shard := ref.shard
+	if shard == nil {
+		atomic.AddInt64(&ref.shards.dropped, 1)
+		atomic.AddInt64(&ref.shards.droppedDuration, int64(stat.Duration))
+		atomic.AddInt64(&ref.shards.droppedCost, stat.Cost)
+		return
+	}
+	shard.Lock()
+	defer shard.Unlock()
+	if shard.merged {
+		return
+	}
+	shard.rpcs[ref.index].stat = stat
+	shard.cost += stat.Cost
+	shard.overhead += overhead
+}
+
+// mergeRPCs appends the RPCs of s, in the order they started, to
+// RPCStats, and adds their cost and overhead to those of s. RPCs that
+// start or finish later are not saved.
+func (s *RequestStats) mergeRPCs() {
+	sh, ok := s.rpcs.Load().(*rpcShards)
+	if !ok {
+		return
+	}
+	var rpcs []shardRPC
+	for i := range sh.shards {
+		shard := &sh.shards[i]
+		shard.Lock()
+		rpcs = append(rpcs, shard.rpcs...)
+		s.Cost += shard.cost
+		s.Overhead += shard.overhead
+		shard.rpcs, shard.cost, shard.overhead = nil, 0, 0
+		shard.merged = true
+		shard.Unlock()
+	}
+	s.DroppedRPCs += int(atomic.SwapInt64(&sh.dropped, 0))
+	s.DroppedDuration += time.Duration(atomic.SwapInt64(&sh.droppedDuration, 0))
+	s.Cost += atomic.SwapInt64(&sh.droppedCost, 0)
+	sort.Slice(rpcs, func(i, j int) bool { return rpcs[i].seq < rpcs[j].seq })
+	for _, r := range rpcs {
+		s.RPCStats = append(s.RPCStats, r.stat)
+	}
+}
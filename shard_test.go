@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFinishRPCAfterSave(t *testing.T) {
+	cfg := newConfig([]Option{
+		WithRollups(nil),
+		WithShouldSave(func(*RequestStats) bool { return false }),
+	})
+	stats := newStats(httptest.NewRequest("GET", "/", nil), cfg)
+	ctx := context.WithValue(context.Background(), statsKey, stats)
+	ctx = context.WithValue(ctx, configKey, cfg)
+
+	ref := stats.startRPC(RPCStat{Service: "memcache", Method: "Get", Pending: true}, 0)
+	save(ctx)
+	stats.finishRPC(ref, RPCStat{Service: "memcache", Method: "Get", Cost: 1}, 0)
+	late := stats.startRPC(RPCStat{Service: "memcache", Method: "Set", Pending: true}, 0)
+	stats.finishRPC(late, RPCStat{Service: "memcache", Method: "Set", Cost: 1}, 0)
+
+	if len(stats.RPCStats) != 1 {
+		t.Fatalf("got %d RPCs, want 1", len(stats.RPCStats))
+	}
+	if !stats.RPCStats[0].Pending {
+		t.Errorf("RPC finished after save is not pending")
+	}
+	if stats.Cost != 0 {
+		t.Errorf("got cost %d, want 0", stats.Cost)
+	}
+}
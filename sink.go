@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+)
+
+// A RecordSink receives every recorded request when it finishes, in
+// addition to the request being saved for the dashboard. It can be used
+// to ship records to an external data pipeline.
+type RecordSink interface {
+	// Emit is called with the finished record, including all of its
+	// RPCs. Emit is called in its own goroutine and must not modify r.
+	Emit(r *RequestStats)
+}
+
+// NopSink is a RecordSink that discards all records.
+type NopSink struct{}
+
+// Emit implements RecordSink.
+func (NopSink) Emit(*RequestStats) {}
+
+// SinkFunc adapts a function to a RecordSink.
+type SinkFunc func(r *RequestStats)
+
+// Emit implements RecordSink by calling f(r).
+func (f SinkFunc) Emit(r *RequestStats) { f(r) }
+
+var sinkActive int32
+
+// emit sends r to Sink in a new goroutine, unless Sink is nil or a
+// NopSink. If SinkConcurrency emits are already running, r is dropped.
+func emit(ctx context.Context, r *RequestStats) {
+	sink := Sink
+	if _, ok := sink.(NopSink); ok || sink == nil {
+		return
+	}
+	if atomic.AddInt32(&sinkActive, 1) > int32(SinkConcurrency) {
+		atomic.AddInt32(&sinkActive, -1)
+		logf(ctx, "WARNING", "appstats: sink busy, dropping record")
+		return
+	}
+	go func() {
+		defer atomic.AddInt32(&sinkActive, -1)
+		sink.Emit(r)
+	}()
+}
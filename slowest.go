@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+)
+
+// slowRequests are the slowest requests, overall and by path.
+type slowRequests struct {
+	// Requests are the slowest requests, slowest first.
+	Requests []*RequestStats
+	// Paths hold the slowest requests of each path, ordered by their
+	// slowest request.
+	Paths []slowPath
+}
+
+// slowPath holds the slowest requests of a path, slowest first.
+type slowPath struct {
+	Path     string
+	Count    int
+	Requests []*RequestStats
+}
+
+// slowestRequests returns the n slowest requests of ars, overall and by
+// path.
+func slowestRequests(ars allrequestStats, n int) slowRequests {
+	sorted := make([]*RequestStats, len(ars))
+	copy(sorted, ars)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Duration > sorted[j].Duration
+	})
+
+	var s slowRequests
+	byPath := make(map[string]int)
+	for _, r := range sorted {
+		if len(s.Requests) < n {
+			s.Requests = append(s.Requests, r)
+		}
+		path := r.aggregatePath()
+		i, ok := byPath[path]
+		if !ok {
+			i = len(s.Paths)
+			byPath[path] = i
+			s.Paths = append(s.Paths, slowPath{Path: path})
+		}
+		p := &s.Paths[i]
+		p.Count++
+		if len(p.Requests) < n {
+			p.Requests = append(p.Requests, r)
+		}
+	}
+	return s
+}
+
+// slowestCount returns the number of slowest requests asked for by the
+// n parameter of r, or SlowestRequests.
+func slowestCount(r *http.Request) int {
+	if n, err := strconv.Atoi(r.FormValue("n")); err == nil && n > 0 {
+		return n
+	}
+	return SlowestRequests
+}
+
+func slowestPage(c context.Context, w http.ResponseWriter, r *http.Request) {
+	ars, err := loadRequests(c, r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+
+	v := struct {
+		Env    map[string]string
+		Filter *filter
+		N      int
+		slowRequests
+	}{
+		Env: map[string]string{
+			"APPLICATION_ID": appengine.AppID(c),
+		},
+		Filter: newFilter(r),
+		N:      slowestCount(r),
+	}
+	v.slowRequests = slowestRequests(ars, v.N)
+
+	_ = templates.ExecuteTemplate(w, "slowest", v)
+}
+
+// apiSlowest serves the slowest requests matching the filters of r,
+// overall and by path, as JSON.
+func apiSlowest(c context.Context, w http.ResponseWriter, r *http.Request) {
+	ars, err := loadRequests(c, r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	serveJSON(w, slowestRequests(ars, slowestCount(r)))
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"bytes"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// spanService is the service of the pseudo RPCs recorded by StartSpan.
+const spanService = "span"
+
+// StartSpan starts timing a section of the request of c, such as
+// template rendering or CPU heavy code, and returns the function ending
+// it. The section is recorded as an RPC named span.name, with its offset
+// and stack, so that it appears on the timeline of the request with its
+// RPCs:
+//
+//	defer appstats.StartSpan(c, "render")()
+//
+// It does nothing if c is not from a recorded request.
+func StartSpan(c context.Context, name string) func() {
+	stats := recording(c)
+	if stats == nil {
+		return func() {}
+	}
+
+	stat, ref := startCall(stats, spanService, name)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stat.Duration = time.Since(stat.Start)
+			finishCall(stats, ref, stat, 0)
+		})
+	}
+}
+
+// RecordCall records a call to service that does not go through the App
+// Engine APIs, such as a database query or a call to another backend,
+// made by the request of c. The call is recorded as an RPC named
+// service.method, started at start and ending now, with its request and
+// response, or error, as they should be shown:
+//
+//	start := time.Now()
+//	v, err := conn.Do("GET", key)
+//	appstats.RecordCall(c, "redis", "GET", start, "GET "+key, fmt.Sprint(v, err))
+//
+// It does nothing if c is not from a recorded request.
+func RecordCall(c context.Context, service, method string, start time.Time, in, out string) {
+	stats := recording(c)
+	if stats == nil {
+		return
+	}
+
+	stat, ref := startCall(stats, service, method)
+	stat.Start = start
+	stat.Offset = start.Sub(stats.Start)
+	stat.Duration = time.Since(start)
+	stat.In = in
+	stat.Out = out
+	finishCall(stats, ref, stat, 0)
+}
+
+// startCall records a pending call of service and method, such as a span
+// or an outbound HTTP request, in stats and returns it with its
+// reference, for finishCall.
+func startCall(stats *RequestStats, service, method string) (RPCStat, rpcRef) {
+	begin := time.Now()
+	stat := RPCStat{
+		Service: service,
+		Method:  method,
+		Start:   begin,
+		Offset:  begin.Sub(stats.Start),
+		Pending: true,
+	}
+	if n := stats.config.stackFrames(); n >= 0 && !stats.rpcsFull() {
+		stat.StackData, stat.Frames = recordStack(callerStack(), n)
+	}
+
+	return stat, stats.startRPC(stat, time.Since(begin))
+}
+
+// callerStack returns the stack of the caller of the function calling
+// startCall, such as StartSpan, after two internal frames, as
+// parseStack expects of the stacks taken in override.
+func callerStack() []byte {
+	b := debug.Stack()
+	// The header line, debug.Stack, callerStack, startCall and its
+	// caller come first. The last two are dropped.
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	if len(lines) < 11 {
+		return b
+	}
+	return bytes.Join(append(lines[:5:5], lines[9:]...), nil)
+}
+
+// truncatePayload returns the first max bytes of the RPC payload s,
+// marked as truncated, or nothing if max is zero.
+func truncatePayload(s string, max int) string {
+	if max <= 0 {
+		return ""
+	}
+	if len(s) > max {
+		return s[:max] + "..."
+	}
+	return s
+}
+
+// limitPayloads truncates the payloads of stat to the limits of stats,
+// ProtoMaxBytes and WithPayloadBudget.
+func limitPayloads(stats *RequestStats, stat *RPCStat) {
+	max := stats.config.protoMaxBytes()
+	in := truncatePayload(stat.In, max)
+	out := truncatePayload(stat.Out, max)
+	if budget := stats.config.payloadBudget(); budget > 0 {
+		n := int64(len(in) + len(out))
+		left := budget - (atomic.AddInt64(&stats.payloadBytes, n) - n)
+		if left < n {
+			in = truncatePayload(in, int(left))
+			out = truncatePayload(out, int(left)-len(in))
+		}
+	}
+	stat.Truncated = max > 0 && (in != stat.In || out != stat.Out)
+	stat.In, stat.Out = in, out
+}
+
+// finishCall replaces the pending entry of stat, started by startCall or
+// override, in stats, after redacting, limiting, sampling and pricing
+// it. overhead is the time spent recording the call since it ended.
+func finishCall(stats *RequestStats, ref rpcRef, stat RPCStat, overhead time.Duration) {
+	stat.Pending = false
+	redactRPC(stats.config.redact(), &stat)
+	limitPayloads(stats, &stat)
+	if stat.Duration < stats.config.stackThreshold() {
+		stat.StackData = ""
+		stat.Frames = nil
+	}
+	if rpcSampler != nil && !stats.forced && !rpcSampler(stat) {
+		stat.StackData = ""
+		stat.Frames = nil
+		stat.In = ""
+		stat.Out = ""
+		stat.HTTP = nil
+	}
+	if Pricing != nil {
+		stat.Cost = Pricing.RPCCost(&stat)
+	}
+	stats.finishRPC(ref, stat, overhead)
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package sqlstats wraps database/sql drivers so that the queries of
+recorded requests appear in their appstats RPCs, as sql.SELECT,
+sql.INSERT and so on, with their text, duration and the number of rows
+affected.
+
+	sql.Register("appstats-mysql", sqlstats.Wrap(&mysql.MySQLDriver{}))
+	db, err := sql.Open("appstats-mysql", dsn)
+
+Only queries given the context of a recorded request, through the
+Context methods of sql.DB, sql.Conn and sql.Tx, are recorded:
+
+	rows, err := db.QueryContext(c, "SELECT name FROM users WHERE id = ?", id)
+
+Query arguments are never recorded, and literal strings and numbers in
+the query text are replaced by ?, so that recorded queries do not carry
+user data.
+*/
+package sqlstats
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mjibson/appstats"
+
+	"golang.org/x/net/context"
+)
+
+// service is the service of the recorded queries.
+const service = "sql"
+
+// Wrap returns a driver recording the queries made through d.
+func Wrap(d driver.Driver) driver.Driver {
+	return wrappedDriver{d}
+}
+
+type wrappedDriver struct {
+	driver.Driver
+}
+
+func (d wrappedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c}, nil
+}
+
+// literals matches the literal strings and numbers of a query, but not
+// numbered placeholders such as $1, keeping the character before a
+// number as the first group.
+var literals = regexp.MustCompile(`'(?:[^']|'')*'|(^|[^$\w.])\d+(?:\.\d+)?\b`)
+
+// Redact replaces the literal strings and numbers of query by ?.
+func Redact(query string) string {
+	return literals.ReplaceAllString(query, "${1}?")
+}
+
+// record records query, started at start, named by its first keyword.
+func record(c context.Context, start time.Time, query string, args int, out string) {
+	method := "QUERY"
+	if f := strings.Fields(query); len(f) > 0 {
+		method = strings.ToUpper(f[0])
+	}
+	in := Redact(query)
+	if args > 0 {
+		in += fmt.Sprintf("\nargs: %d", args)
+	}
+	appstats.RecordCall(c, service, method, start, in, out)
+}
+
+func execResult(res driver.Result, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("rows affected: %d", n)
+}
+
+func queryResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+type conn struct {
+	driver.Conn
+}
+
+var (
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.Pinger             = (*conn)(nil)
+	_ driver.SessionResetter    = (*conn)(nil)
+	_ driver.NamedValueChecker  = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{s, query}, nil
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	p, ok := c.Conn.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	s, err := p.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{s, query}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		// database/sql then prepares the query, which stmt records.
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := e.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		record(ctx, start, query, len(args), execResult(res, err))
+	}
+	return res, err
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		record(ctx, start, query, len(args), queryResult(err))
+	}
+	return rows, err
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) CheckNamedValue(v *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+type stmt struct {
+	driver.Stmt
+	query string
+}
+
+var (
+	_ driver.StmtExecContext  = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+)
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var res driver.Result
+	var err error
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		res, err = s.Stmt.Exec(values(args))
+	}
+	record(ctx, start, s.query, len(args), execResult(res, err))
+	return res, err
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(values(args))
+	}
+	record(ctx, start, s.query, len(args), queryResult(err))
+	return rows, err
+}
+
+// values converts args for drivers without context support, which do
+// not accept named arguments.
+func values(args []driver.NamedValue) []driver.Value {
+	v := make([]driver.Value, len(args))
+	for i, a := range args {
+		v[i] = a.Value
+	}
+	return v
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package statsd provides an appstats.RecordSink that sends timing and
+count metrics of recorded requests and their RPCs to a StatsD or
+DogStatsD server over UDP.
+
+	s, err := statsd.New("127.0.0.1:8125", "myapp.")
+	...
+	appstats.Sink = s
+
+For each request, it sends:
+
+	<prefix>request.<path>              request duration, in milliseconds
+	<prefix>request.<path>.count        request count
+	<prefix>rpc.<Service.Method>        RPC duration, in milliseconds
+	<prefix>rpc.<Service.Method>.count  RPC count
+
+With DogStatsD set, the path and RPC name are sent as request: and rpc:
+tags of the <prefix>request and <prefix>rpc metrics instead. Slashes in
+paths are replaced by dots.
+
+Requests are named by their RequestStats.MetricPath, their route or the
+first segment of their path, so that distinct URLs do not create
+distinct metrics. After 500 paths and RPC names, others are sent as
+other.
+*/
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjibson/appstats"
+)
+
+const (
+	// maxPacket is the size above which metrics are split across UDP
+	// packets, chosen to fit in an Ethernet MTU.
+	maxPacket = 1432
+
+	// maxNames is the number of paths and RPCs with their own metrics.
+	// Others are sent as other.
+	maxNames = 500
+)
+
+var _ appstats.RecordSink = (*Exporter)(nil)
+
+// Exporter is an appstats.RecordSink sending metrics to a StatsD server.
+type Exporter struct {
+	// Prefix is prepended to all metric names.
+	Prefix string
+
+	// DogStatsD enables sending names as DogStatsD tags.
+	DogStatsD bool
+
+	// OnError is called with send errors. The default logs them.
+	OnError func(error)
+
+	conn net.Conn
+
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// New returns an Exporter sending to the StatsD server at addr.
+func New(addr, prefix string) (*Exporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{
+		Prefix: prefix,
+		conn:   conn,
+	}, nil
+}
+
+// Close closes the connection to the server.
+func (e *Exporter) Close() error {
+	return e.conn.Close()
+}
+
+var nameReplacer = strings.NewReplacer("/", ".", ":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+
+// sanitize makes s usable in a metric name or tag value.
+func sanitize(s string) string {
+	s = strings.Trim(s, "/")
+	if s == "" {
+		return "root"
+	}
+	return nameReplacer.Replace(s)
+}
+
+// name returns the metric name of s, or other once maxNames are sent.
+func (e *Exporter) name(s string) string {
+	s = sanitize(s)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.names[s] {
+		if len(e.names) >= maxNames {
+			return "other"
+		}
+		if e.names == nil {
+			e.names = make(map[string]bool)
+		}
+		e.names[s] = true
+	}
+	return s
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// metrics returns the metric lines for r.
+func (e *Exporter) metrics(r *appstats.RequestStats) []string {
+	var lines []string
+	metric := func(kind, name string, d time.Duration) {
+		if e.DogStatsD {
+			lines = append(lines,
+				fmt.Sprintf("%s%s:%g|ms|#%s:%s", e.Prefix, kind, ms(d), kind, name),
+				fmt.Sprintf("%s%s.count:1|c|#%s:%s", e.Prefix, kind, kind, name),
+			)
+		} else {
+			lines = append(lines,
+				fmt.Sprintf("%s%s.%s:%g|ms", e.Prefix, kind, name, ms(d)),
+				fmt.Sprintf("%s%s.%s.count:1|c", e.Prefix, kind, name),
+			)
+		}
+	}
+	metric("request", e.name(r.MetricPath()), r.Duration)
+	for _, s := range r.RPCStats {
+		metric("rpc", e.name(s.Name()), s.Duration+s.ExtraDuration)
+	}
+	return lines
+}
+
+// Emit implements appstats.RecordSink. Metrics are batched into as few
+// packets as possible.
+func (e *Exporter) Emit(r *appstats.RequestStats) {
+	var buf bytes.Buffer
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		if _, err := e.conn.Write(buf.Bytes()); err != nil {
+			if e.OnError != nil {
+				e.OnError(err)
+			} else {
+				log.Printf("statsd: %v", err)
+			}
+		}
+		buf.Reset()
+	}
+	for _, line := range e.metrics(r) {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > maxPacket {
+			flush()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	flush()
+}
@@ -0,0 +1,461 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/memcache"
+)
+
+// Storage persists recorded requests. Each request is saved as two
+// encoded records: a part record without RPC stacks and payloads, used
+// for the dashboard overview, and a full record. Records are identified
+// by the ID of their request.
+type Storage interface {
+	// Save stores the part and full records of request id.
+	Save(c context.Context, id int64, part, full []byte) error
+
+	// LoadPart returns the part record of request id.
+	LoadPart(c context.Context, id int64) ([]byte, error)
+
+	// LoadFull returns the full record of request id.
+	LoadFull(c context.Context, id int64) ([]byte, error)
+
+	// List returns the part records of all stored requests.
+	List(c context.Context) ([][]byte, error)
+
+	// Ping checks that the storage is reachable and working.
+	Ping(c context.Context) error
+}
+
+// A Record is the part and full records of a request, as given to
+// Storage.Save.
+type Record struct {
+	ID         int64
+	Part, Full []byte
+}
+
+// A BatchStorage is a Storage that can store the records of several
+// requests at once, used when SaveFlushInterval coalesces them.
+type BatchStorage interface {
+	Storage
+
+	// SaveAll stores records.
+	SaveAll(c context.Context, records []Record) error
+}
+
+var errNotFound = errors.New("appstats: record not found")
+
+// CheckStorage verifies that recorded requests can be stored by calling
+// the Ping method of the Storage of opts, given by WithStorage, or of
+// Store. Call it at startup with the options of Middleware or NewHandler
+// to fail fast on a misconfigured storage.
+func CheckStorage(ctx context.Context, opts ...Option) error {
+	if s := newConfig(opts).store; s != nil {
+		return s.Ping(ctx)
+	}
+	return Store.Ping(ctx)
+}
+
+const (
+	keyPrefix = "__appstats__:"
+	keyPart   = keyPrefix + "%06d:part"
+	keyFull   = keyPrefix + "%06d:full"
+	keyChunk  = keyPrefix + "%d:chunk:%d"
+)
+
+const (
+	// chunkSize is the size of the chunks full records larger than the
+	// memcache value size limit are split in.
+	chunkSize = 1000000
+
+	// chunkFlags marks the full record items holding the manifest of
+	// a record stored in chunks: its request id and number of chunks.
+	chunkFlags = 1
+
+	// maxChunks is the number of chunks of the largest full record
+	// stored with its RPC stacks.
+	maxChunks = 32
+)
+
+// maxRecordLen returns the size above which full records lose their RPC
+// stacks before they are stored in s. MemcacheStorage splits large
+// records in chunks; other stores may be limited to the memcache value
+// size.
+func maxRecordLen(s Storage) int {
+	switch s.(type) {
+	case MemcacheStorage, *MemcacheStorage:
+		return maxChunks * chunkSize
+	}
+	return bufMaxLen
+}
+
+func slots() int {
+	if MemcacheSlots < 1 {
+		return 1
+	}
+	return MemcacheSlots
+}
+
+// slotDistance spaces the numbers in the keys of slots, so that slot n
+// is stored under n*slotDistance, the keys used before MemcacheSlots was
+// configurable.
+const slotDistance = 100
+
+// listBatch is the number of keys List gets at once.
+const listBatch = 1000
+
+// roundTime returns the number in the memcache keys of the slot of the
+// request with the given ID.
+func roundTime(i int64) int {
+	width := int64(MemcacheSlotWidth)
+	if width < 1 {
+		width = 1
+	}
+	return int(i/width%int64(slots())) * slotDistance
+}
+
+// MemcacheStorage stores records in memcache under Namespace, expiring
+// after MemcacheExpiration. It is the default Storage.
+//
+// Records are kept in MemcacheSlots slots, so a record is overwritten by
+// any later request mapping to the same slot. Full records larger than
+// the memcache value size limit are split in chunks under separate keys,
+// and reassembled when loaded.
+type MemcacheStorage struct{}
+
+func (MemcacheStorage) context(c context.Context) (context.Context, error) {
+	nc, err := appengine.Namespace(c, Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("appstats: bad namespace: %v", err)
+	}
+	return nc, nil
+}
+
+// Save implements Storage.
+func (m MemcacheStorage) Save(c context.Context, id int64, part, full []byte) error {
+	nc, err := m.context(c)
+	if err != nil {
+		return err
+	}
+	return memcache.SetMulti(nc, memcacheItems(id, part, full))
+}
+
+// SaveAll implements BatchStorage, with a single memcache call. Of the
+// records mapping to the same slot, the last one is kept.
+func (m MemcacheStorage) SaveAll(c context.Context, records []Record) error {
+	nc, err := m.context(c)
+	if err != nil {
+		return err
+	}
+	last := make(map[int]Record)
+	for _, r := range records {
+		last[roundTime(r.ID)] = r
+	}
+	var items []*memcache.Item
+	for _, r := range last {
+		items = append(items, memcacheItems(r.ID, r.Part, r.Full)...)
+	}
+	return memcache.SetMulti(nc, items)
+}
+
+// memcacheItems returns the memcache items of the part and full records
+// of request id. A full record larger than chunkSize is split in chunks,
+// stored under their own keys, with a manifest under the key of the
+// full record.
+func memcacheItems(id int64, part, full []byte) []*memcache.Item {
+	t := roundTime(id)
+	items := []*memcache.Item{
+		{
+			Key:        fmt.Sprintf(keyPart, t),
+			Value:      part,
+			Expiration: MemcacheExpiration,
+		},
+	}
+	if len(full) <= chunkSize {
+		return append(items, &memcache.Item{
+			Key:        fmt.Sprintf(keyFull, t),
+			Value:      full,
+			Expiration: MemcacheExpiration,
+		})
+	}
+	n := (len(full) + chunkSize - 1) / chunkSize
+	items = append(items, &memcache.Item{
+		Key:        fmt.Sprintf(keyFull, t),
+		Value:      []byte(fmt.Sprintf("%d %d", id, n)),
+		Flags:      chunkFlags,
+		Expiration: MemcacheExpiration,
+	})
+	for i := 0; i < n; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(full) {
+			end = len(full)
+		}
+		items = append(items, &memcache.Item{
+			Key:        fmt.Sprintf(keyChunk, id, i),
+			Value:      full[i*chunkSize : end],
+			Expiration: MemcacheExpiration,
+		})
+	}
+	return items
+}
+
+func (m MemcacheStorage) load(c context.Context, key string) ([]byte, error) {
+	nc, err := m.context(c)
+	if err != nil {
+		return nil, err
+	}
+	item, err := memcache.Get(nc, key)
+	if err != nil {
+		return nil, err
+	}
+	if item.Flags == chunkFlags {
+		return m.loadChunks(nc, item.Value)
+	}
+	return item.Value, nil
+}
+
+// loadChunks reassembles the full record stored in chunks with the
+// manifest b.
+func (m MemcacheStorage) loadChunks(nc context.Context, b []byte) ([]byte, error) {
+	var id int64
+	var n int
+	if _, err := fmt.Sscanf(string(b), "%d %d", &id, &n); err != nil {
+		return nil, fmt.Errorf("appstats: bad chunk manifest %q: %v", b, err)
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf(keyChunk, id, i)
+	}
+	items, err := memcache.GetMulti(nc, keys)
+	if err != nil {
+		return nil, err
+	}
+	var full []byte
+	for _, key := range keys {
+		item, ok := items[key]
+		if !ok {
+			return nil, fmt.Errorf("appstats: missing chunk %s of record %d", key, id)
+		}
+		full = append(full, item.Value...)
+	}
+	return full, nil
+}
+
+// loadPython loads the record of request id from the key of this
+// package, or with PythonRecords, from the key of the Python appstats
+// module if the former does not hold request id.
+func (m MemcacheStorage) loadPython(c context.Context, id int64, key, pythonKey string) ([]byte, error) {
+	b, err := m.load(c, fmt.Sprintf(key, roundTime(id)))
+	if !PythonRecords {
+		return b, err
+	}
+	if err == nil && recordID(b) == id {
+		return b, nil
+	}
+	pb, perr := m.load(c, fmt.Sprintf(pythonKey, pythonSlot(id)))
+	if perr != nil {
+		return b, err
+	}
+	return append([]byte{pythonMarker}, pb...), nil
+}
+
+// recordID returns the request id of a part or full record, or 0 if it
+// cannot be decoded.
+func recordID(b []byte) int64 {
+	if r, err := decodePart(b); err == nil {
+		return r.ID()
+	}
+	if f, err := decodeFull(b); err == nil {
+		return f.Stats.ID()
+	}
+	return 0
+}
+
+// LoadPart implements Storage.
+func (m MemcacheStorage) LoadPart(c context.Context, id int64) ([]byte, error) {
+	return m.loadPython(c, id, keyPart, pythonKeyPart)
+}
+
+// LoadFull implements Storage.
+func (m MemcacheStorage) LoadFull(c context.Context, id int64) ([]byte, error) {
+	return m.loadPython(c, id, keyFull, pythonKeyFull)
+}
+
+// List implements Storage.
+func (m MemcacheStorage) List(c context.Context) ([][]byte, error) {
+	nc, err := m.context(c)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, slots())
+	for i := range keys {
+		keys[i] = fmt.Sprintf(keyPart, i*slotDistance)
+	}
+	if PythonRecords {
+		for i := 0; i < pythonSlots; i++ {
+			keys = append(keys, fmt.Sprintf(pythonKeyPart, i*pythonSlotWidth))
+		}
+	}
+	var records [][]byte
+	for len(keys) > 0 {
+		n := listBatch
+		if n > len(keys) {
+			n = len(keys)
+		}
+		items, err := memcache.GetMulti(nc, keys[:n])
+		if err != nil {
+			return nil, err
+		}
+		keys = keys[n:]
+		for key, item := range items {
+			if strings.HasSuffix(key, ",part") {
+				records = append(records, append([]byte{pythonMarker}, item.Value...))
+			} else {
+				records = append(records, item.Value)
+			}
+		}
+	}
+	return records, nil
+}
+
+const pingKey = keyPrefix + "ping"
+
+// Ping implements Storage by writing, reading back and deleting a
+// sentinel value.
+func (m MemcacheStorage) Ping(c context.Context) error {
+	nc, err := m.context(c)
+	if err != nil {
+		return err
+	}
+	value := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	if err := memcache.Set(nc, &memcache.Item{
+		Key:        pingKey,
+		Value:      value,
+		Expiration: time.Minute,
+	}); err != nil {
+		return fmt.Errorf("appstats: storage put: %v", err)
+	}
+	item, err := memcache.Get(nc, pingKey)
+	if err != nil {
+		return fmt.Errorf("appstats: storage get: %v", err)
+	}
+	if !bytes.Equal(item.Value, value) {
+		return fmt.Errorf("appstats: storage get: unexpected value %q", item.Value)
+	}
+	if err := memcache.Delete(nc, pingKey); err != nil {
+		return fmt.Errorf("appstats: storage delete: %v", err)
+	}
+	return nil
+}
+
+// MemoryStorage keeps the most recent records in process memory, in a
+// fixed-size ring buffer. It needs no App Engine service, which makes it
+// useful for tests and local development. Records are lost when the
+// process exits and are not shared between instances.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	records []memoryRecord
+	next    int
+	index   map[int64]int
+}
+
+type memoryRecord struct {
+	id         int64
+	part, full []byte
+}
+
+// NewMemoryStorage returns a MemoryStorage holding up to n records.
+func NewMemoryStorage(n int) *MemoryStorage {
+	return &MemoryStorage{
+		records: make([]memoryRecord, 0, n),
+		index:   make(map[int64]int, n),
+	}
+}
+
+// Save implements Storage. It overwrites the oldest record once the
+// storage is full.
+func (m *MemoryStorage) Save(c context.Context, id int64, part, full []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := memoryRecord{id: id, part: part, full: full}
+	if i, ok := m.index[id]; ok {
+		m.records[i] = r
+		return nil
+	}
+	if len(m.records) < cap(m.records) {
+		m.index[id] = len(m.records)
+		m.records = append(m.records, r)
+		return nil
+	}
+	if len(m.records) == 0 {
+		return nil
+	}
+	delete(m.index, m.records[m.next].id)
+	m.records[m.next] = r
+	m.index[id] = m.next
+	m.next = (m.next + 1) % len(m.records)
+	return nil
+}
+
+func (m *MemoryStorage) load(id int64) (memoryRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.index[id]
+	if !ok {
+		return memoryRecord{}, errNotFound
+	}
+	return m.records[i], nil
+}
+
+// LoadPart implements Storage.
+func (m *MemoryStorage) LoadPart(c context.Context, id int64) ([]byte, error) {
+	r, err := m.load(id)
+	return r.part, err
+}
+
+// LoadFull implements Storage.
+func (m *MemoryStorage) LoadFull(c context.Context, id int64) ([]byte, error) {
+	r, err := m.load(id)
+	return r.full, err
+}
+
+// List implements Storage.
+func (m *MemoryStorage) List(c context.Context) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parts := make([][]byte, len(m.records))
+	for i, r := range m.records {
+		parts[i] = r.part
+	}
+	return parts, nil
+}
+
+// Ping implements Storage. It always succeeds.
+func (m *MemoryStorage) Ping(c context.Context) error {
+	return nil
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// pingStorage is a Storage whose Ping fails with err.
+type pingStorage struct {
+	Storage
+	err error
+}
+
+func (s pingStorage) Ping(c context.Context) error { return s.err }
+
+func TestCheckStorage(t *testing.T) {
+	err := errors.New("unreachable")
+	if got := CheckStorage(context.Background(), WithStorage(pingStorage{err: err})); got != err {
+		t.Errorf("got %v, want %v", got, err)
+	}
+	if err := CheckStorage(context.Background(), WithStorage(NewMemoryStorage(10))); err != nil {
+		t.Errorf("MemoryStorage: %v", err)
+	}
+}
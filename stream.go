@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+)
+
+// streamSummary is a recorded request as sent by the stream endpoint.
+type streamSummary struct {
+	ID       int64         `json:"id"`
+	Start    time.Time     `json:"start"`
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Query    string        `json:"query,omitempty"`
+	Status   int           `json:"status"`
+	User     string        `json:"user,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Real     string        `json:"real"`
+	Cost     int64         `json:"cost"`
+	RPCs     int           `json:"rpcs"`
+}
+
+func newStreamSummary(r *RequestStats) streamSummary {
+	return streamSummary{
+		ID:       r.ID(),
+		Start:    r.Start,
+		Method:   r.Method,
+		Path:     r.Path,
+		Query:    r.Query,
+		Status:   r.Status,
+		User:     r.User,
+		Duration: r.Duration,
+		Real:     r.Duration.String(),
+		Cost:     r.Cost,
+		RPCs:     len(r.RPCStats),
+	}
+}
+
+// stream sends the requests recorded from now on, matching the filter
+// of r, as server-sent events. Store is polled every StreamPoll, so that
+// requests recorded by all instances are seen. The stream ends after
+// StreamTimeout; the EventSource of the browser then reconnects and
+// resumes after the last event it received.
+func stream(c context.Context, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	last, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if err != nil {
+		last = time.Now().UnixNano()
+	}
+	f := newFilter(r)
+	timeout := time.After(StreamTimeout)
+	tick := time.NewTicker(StreamPoll)
+	defer tick.Stop()
+
+	fmt.Fprintf(w, "retry: %d\n\n", StreamPoll/time.Millisecond)
+	flusher.Flush()
+	for {
+		records, err := storage(c).List(c)
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+			flusher.Flush()
+			return
+		}
+		var fresh allrequestStats
+		for _, b := range records {
+			s, err := decodePart(b)
+			if err != nil || s.ID() <= last || !f.match(s) {
+				continue
+			}
+			fresh = append(fresh, s)
+		}
+		sort.Sort(fresh)
+		for _, s := range fresh {
+			b, _ := json.Marshal(newStreamSummary(s))
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", s.ID(), b)
+			last = s.ID()
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timeout:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// livePage shows the requests of the stream as they are recorded.
+func livePage(c context.Context, w http.ResponseWriter, r *http.Request) {
+	v := struct {
+		Env    map[string]string
+		Filter *filter
+	}{
+		Env: map[string]string{
+			"APPLICATION_ID": appengine.AppID(c),
+		},
+		Filter: newFilter(r),
+	}
+
+	_ = templates.ExecuteTemplate(w, "live", v)
+}
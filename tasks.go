@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+)
+
+// parentHeader carries the id of the request that enqueued a task. Tasks
+// with it are always recorded, with Parent set. Other requests may carry
+// it too, so it is ignored on them.
+const parentHeader = "X-Appstats-Parent"
+
+// isTask reports whether r is a task queue request. App Engine removes
+// the X-AppEngine-TaskName header from outside requests.
+func isTask(r *http.Request) bool {
+	return onAppEngine && r.Header.Get("X-Appengine-Taskname") != ""
+}
+
+// isTaskAdd reports whether the RPC service.method adds tasks.
+func isTaskAdd(service, method string) bool {
+	return service == "taskqueue" && (method == "Add" || method == "BulkAdd")
+}
+
+// stampTasks adds parentHeader with id to the push tasks of in, a
+// taskqueue Add or BulkAdd request.
+func stampTasks(in proto.Message, id int64) {
+	v := reflect.Indirect(reflect.ValueOf(in))
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	if adds := v.FieldByName("AddRequest"); adds.Kind() == reflect.Slice {
+		for i := 0; i < adds.Len(); i++ {
+			stampTask(reflect.Indirect(adds.Index(i)), id)
+		}
+		return
+	}
+	stampTask(v, id)
+}
+
+// stampTask adds parentHeader with id to the headers of the task add
+// request v, unless it is a pull task or already has the header.
+func stampTask(v reflect.Value, id int64) {
+	if v.Kind() != reflect.Struct || reflectInt(v.FieldByName("Mode")) != 0 {
+		return
+	}
+	headers := v.FieldByName("Header")
+	if headers.Kind() != reflect.Slice || !headers.CanSet() {
+		return
+	}
+	ptr := headers.Type().Elem()
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Struct {
+		return
+	}
+	h := reflect.New(ptr.Elem())
+	key, value := h.Elem().FieldByName("Key"), h.Elem().FieldByName("Value")
+	if key.Type() != reflect.TypeOf([]byte(nil)) || value.Type() != key.Type() {
+		return
+	}
+	for i := 0; i < headers.Len(); i++ {
+		k := reflect.Indirect(headers.Index(i)).FieldByName("Key").Bytes()
+		if http.CanonicalHeaderKey(string(k)) == parentHeader {
+			return
+		}
+	}
+	key.SetBytes([]byte(parentHeader))
+	value.SetBytes([]byte(strconv.FormatInt(id, 10)))
+	headers.Set(reflect.Append(headers, h))
+}
+
+// addsTasks reports whether s enqueued tasks.
+func addsTasks(s *RequestStats) bool {
+	for _, r := range s.RPCStats {
+		if isTaskAdd(r.Service, r.Method) {
+			return true
+		}
+	}
+	return false
+}
+
+// findTasks returns the recorded tasks enqueued by request id, in the
+// order they ran.
+func findTasks(c context.Context, id int64) ([]*RequestStats, error) {
+	records, err := storage(c).List(c)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*RequestStats
+	for _, b := range records {
+		s, err := decodePart(b)
+		if err != nil || s.Parent != id {
+			continue
+		}
+		tasks = append(tasks, s)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Start.Before(tasks[j].Start)
+	})
+	return tasks, nil
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"net/http"
+	"time"
+)
+
+// httpService is the service of the outbound HTTP requests recorded by
+// Transport.
+const httpService = "http"
+
+// Transport is an http.RoundTripper that records the requests it sends
+// as RPCs of the recorded request of their context, named http.METHOD,
+// with their URL, status and duration. It covers outbound calls that do
+// not use the urlfetch service, such as those of the second generation
+// runtimes:
+//
+//	client := &http.Client{Transport: &appstats.Transport{}}
+//	req, _ := http.NewRequest("GET", url, nil)
+//	resp, err := client.Do(req.WithContext(c))
+//
+// Requests whose context is not from a recorded request are sent
+// unrecorded.
+type Transport struct {
+	// Base is the RoundTripper sending the requests. If nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	stats := recording(req.Context())
+	if stats == nil {
+		return t.base().RoundTrip(req)
+	}
+
+	stat, ref := startCall(stats, httpService, req.Method)
+	resp, err := t.base().RoundTrip(req)
+	stat.Duration = time.Since(stat.Start)
+	begin := time.Now()
+	call := &HTTPCall{
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		RequestHeader: req.Header,
+	}
+	if req.ContentLength > 0 {
+		call.RequestSize = int(req.ContentLength)
+	}
+	stat.In = req.Method + " " + call.URL
+	if err != nil {
+		stat.Out = err.Error()
+	} else {
+		call.Status = resp.StatusCode
+		call.ResponseHeader = resp.Header
+		if resp.ContentLength > 0 {
+			call.ResponseSize = int(resp.ContentLength)
+		}
+		stat.Out = resp.Status
+	}
+	stat.HTTP = call
+	finishCall(stats, ref, stat, time.Since(begin))
+	return resp, err
+}
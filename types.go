@@ -19,82 +19,250 @@ package appstats
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const (
-	keyPrefix = "__appstats__:"
-	keyPart   = keyPrefix + "%06d:part"
-	keyFull   = keyPrefix + "%06d:full"
-	distance  = 100
-	modulus   = 1000
-)
-
-type requestStats struct {
-	User        string
-	Admin       bool
-	Method      string
-	Path, Query string
-	Status      int
-	Cost        int64
-	Start       time.Time
-	Duration    time.Duration
-	RPCStats    []rpcStat
+// RequestStats is the record of a request: the request, its response and
+// the RPCs it made. Part records, listed by the dashboard, have no RPC
+// stacks, payloads, HTTP calls, events, logs, body or panic stack.
+type RequestStats struct {
+	User         string
+	Admin        bool
+	Method       string
+	Path, Query  string
+	Route        string
+	Status       int
+	ContentType  string
+	CacheControl string
+	Age          string
+	Cost         int64
+	Start        time.Time
+	Duration     time.Duration
+	Overhead     time.Duration
+	RPCStats     []RPCStat
+
+	// DroppedRPCs is the number of RPCs beyond the limit of WithMaxRPCs,
+	// which are not in RPCStats, and DroppedDuration their total duration.
+	DroppedRPCs     int
+	DroppedDuration time.Duration
+
+	// ResponseSize is the number of bytes of the response body.
+	ResponseSize int64
+
+	// CloudTraceContext is the X-Cloud-Trace-Context header of the
+	// request, as set by Google Cloud load balancers.
+	CloudTraceContext string
+
+	// TraceID and SpanID are the trace and parent span IDs, in hex, of a
+	// valid W3C traceparent header of the request. TraceState is its
+	// tracestate header.
+	TraceID, SpanID string
+	TraceState      string
+
+	// ReplayOf is the ID of the request this one replays, if any.
+	ReplayOf int64
+
+	// Parent is the ID of the request that enqueued this one as a
+	// task, or started it with RunInBackground, if any.
+	Parent int64
+
+	// Namespace is the App Engine namespace of the request, if any: that
+	// given by WithNamespace or Recorder.SetNamespace, or else that of
+	// its first RPC made in a namespace.
+	Namespace string
+
+	// Service and Version are the App Engine service (module) and
+	// version that served the request.
+	Service, Version string
+
+	// Class is the class of the request: ClassCron, ClassWarmup,
+	// ClassTask or ClassBackground, or empty for other requests.
+	Class string
+
+	// RecordSize is the stored size of the full record of the request,
+	// and RecordRawSize its size before compression. They are only set
+	// in part records.
+	RecordSize, RecordRawSize int64
+
+	// Panic is the value the handler panicked with, if it did, and
+	// PanicStack the stack of the panic.
+	Panic, PanicStack string
+
+	// RequestSize is the size of the request body, if known.
+	RequestSize int64
+
+	// Body is the start of the request body, if RecordBody is set, and
+	// BodyTruncated whether the handler read more than RecordBody
+	// bytes of it.
+	Body          []byte
+	BodyTruncated bool
+
+	// Annotations and Events are added by the app with Annotate and
+	// Event.
+	Annotations []Annotation
+	Events      []EventStat
+
+	// Logs are the lines logged through Logger.
+	Logs []LogStat
+
+	// Memory is set if RecordMemory is.
+	Memory *MemoryStats
+
+	// GoroutinesStart and GoroutinesEnd are the number of goroutines of
+	// the instance when the request started and ended.
+	GoroutinesStart, GoroutinesEnd int
+
+	memStart *runtime.MemStats
+	body     *bodyRecorder
+	config   *config
+	// forced is set for requests recorded by WithRecordToken.
+	forced bool
+
+	// payloadBytes counts the bytes of RPC payloads recorded, for
+	// WithPayloadBudget.
+	payloadBytes int64
+
+	// rpcs holds the *rpcShards of the RPCs recorded while the request
+	// runs.
+	rpcs atomic.Value
 
 	lock sync.Mutex
 }
 
-type stats_part requestStats
+// GoroutineGrowth returns the change in the number of goroutines
+// during r, which includes the goroutines of concurrent requests.
+func (r *RequestStats) GoroutineGrowth() int {
+	return r.GoroutinesEnd - r.GoroutinesStart
+}
 
-type stats_full struct {
-	Header http.Header
-	Stats  *requestStats
+// RequestBytes returns RequestSize for display.
+func (r *RequestStats) RequestBytes() byteSize {
+	return byteSize(r.RequestSize)
+}
+
+// ResponseBytes returns ResponseSize for display.
+func (r *RequestStats) ResponseBytes() byteSize {
+	return byteSize(r.ResponseSize)
 }
 
-func (r *requestStats) PartKey() string {
-	t := roundTime(r.Start.Nanosecond())
-	return fmt.Sprintf(keyPart, t)
+// Failed reports whether r was answered with a status other than 2xx.
+// Records without a status did not fail.
+func (r *RequestStats) Failed() bool {
+	return r.Panic != "" || r.Status != 0 && (r.Status < 200 || r.Status > 299)
+}
+
+// aggregatePath returns the path under which r is aggregated.
+func (r *RequestStats) aggregatePath() string {
+	for _, s := range CollapseStatuses {
+		if r.Status == s {
+			return CollapsedPath
+		}
+	}
+	if r.Route != "" {
+		return r.Route
+	}
+	return r.Path
 }
 
-func (r *requestStats) FullKey() string {
-	t := roundTime(r.Start.Nanosecond())
-	return fmt.Sprintf(keyFull, t)
+type stats_part RequestStats
+
+type stats_full struct {
+	Header http.Header
+	Stats  *RequestStats
 }
 
-func roundTime(i int) int {
-	return (i / 1000 / distance) % modulus * distance
+// ID returns the identifier of the request, under which it is stored.
+func (r *RequestStats) ID() int64 {
+	return r.Start.UnixNano()
 }
 
-type rpcStat struct {
+// RPCStat is a single recorded API call.
+type RPCStat struct {
 	Service, Method string
 	Start           time.Time
 	Offset          time.Duration
 	Duration        time.Duration
 	ExtraDuration   time.Duration
 	StackData       string
+	Frames          stack
 	In, Out         string
 	Cost            int64
 	Pending         bool
+
+	// Truncated is set if In or Out were truncated, by ProtoMaxBytes
+	// or WithPayloadBudget.
+	Truncated bool
+
+	// HTTP is set for urlfetch calls.
+	HTTP *HTTPCall
+
+	// Hits and Misses count the keys found and not found by memcache
+	// Get calls.
+	Hits, Misses int
+
+	// Reads and Writes count the entities read and written, including
+	// deletes, by datastore calls, and IndexWrites the index entries
+	// written.
+	Reads, Writes, IndexWrites int
+
+	// stack is StackData parsed by Stack, and stacks the stacks parsed
+	// for the other RPCs of the record, shared by them.
+	stack  stack
+	stacks stackCache
 }
 
-func (r rpcStat) Name() string {
+// stackCache maps the StackData of RPCs to its parsed stack.
+type stackCache map[string]stack
+
+func (r RPCStat) Name() string {
 	return r.Service + "." + r.Method
 }
 
-func (r rpcStat) Request() string {
+func (r RPCStat) Request() string {
 	return r.In
 }
 
-func (r rpcStat) Response() string {
+func (r RPCStat) Response() string {
 	return r.Out
 }
 
-func (r rpcStat) Stack() stack {
-	lines := strings.Split(r.StackData, "\n")
+// Stack returns the call stack of the RPC. Records captured with
+// CompactStacks have their frames parsed already; older records only
+// carry the raw StackData, which is parsed here on first use, once for
+// all the RPCs of the record with the same stack.
+func (r *RPCStat) Stack() stack {
+	if r.Frames != nil {
+		return r.Frames
+	}
+	if r.stack == nil {
+		if s, ok := r.stacks[r.StackData]; ok {
+			r.stack = s
+		} else {
+			r.stack = parseStack(r.StackData)
+			if r.stacks != nil {
+				r.stacks[r.StackData] = r.stack
+			}
+		}
+	}
+	return r.stack
+}
+
+// shareStacks makes the RPCs of r share the stacks they parse in c.
+func (r *RequestStats) shareStacks(c stackCache) {
+	for i := range r.RPCStats {
+		r.RPCStats[i].stacks = c
+	}
+}
+
+func parseStack(s string) stack {
+	lines := strings.Split(s, "\n")
 
 	// Less than 7 lines are basically an empty stack, because
 	// one line is the header, and the four following lines
@@ -128,7 +296,7 @@ func (r rpcStat) Stack() stack {
 		}
 
 		f.Location = lines[i][1:cidx]
-		f.Lineno, _ = strconv.Atoi(lines[i][cidx+1:idx])
+		f.Lineno, _ = strconv.Atoi(lines[i][cidx+1 : idx])
 
 		frames = append(frames, f)
 	}
@@ -136,6 +304,37 @@ func (r rpcStat) Stack() stack {
 	return frames
 }
 
+// recordStack returns the stack b, from debug.Stack, to record for an
+// RPC, as text or, with CompactStacks, as frames, cut to its top n
+// frames if n is positive.
+func recordStack(b []byte, n int) (string, stack) {
+	s := string(b)
+	if n > 0 {
+		// The header line and two internal frames come first, as
+		// in parseStack.
+		lines := strings.SplitAfter(s, "\n")
+		if max := 1 + 2*2 + 2*n; len(lines) > max {
+			s = strings.Join(lines[:max], "")
+		}
+	}
+	if CompactStacks {
+		return "", compactStack(s)
+	}
+	return s, nil
+}
+
+// compactStack parses s and strips the argument lists from the calls,
+// leaving only the symbol names.
+func compactStack(s string) stack {
+	frames := parseStack(s)
+	for _, f := range frames {
+		if i := strings.LastIndex(f.Call, "("); i > 0 {
+			f.Call = f.Call[:i]
+		}
+	}
+	return frames
+}
+
 type stack []*frame
 
 type frame struct {
@@ -144,7 +343,22 @@ type frame struct {
 	Lineno   int
 }
 
-type allrequestStats []*requestStats
+// Link returns the URL of the source of f, in SourceURL or else in the
+// file viewer of the dashboard.
+func (f *frame) Link() string {
+	if SourceURL == "" {
+		v := url.Values{}
+		v.Set("f", f.Location)
+		v.Set("n", strconv.Itoa(f.Lineno))
+		return fmt.Sprintf("file?%s#n%d", v.Encode(), f.Lineno-10)
+	}
+	return strings.NewReplacer(
+		"{file}", strings.TrimPrefix(strings.TrimPrefix(f.Location, SourcePrefix), "/"),
+		"{line}", strconv.Itoa(f.Lineno),
+	).Replace(SourceURL)
+}
+
+type allrequestStats []*RequestStats
 
 func (s allrequestStats) Len() int           { return len(s) }
 func (s allrequestStats) Less(i, j int) bool { return s[i].Start.Sub(s[j].Start) < 0 }
@@ -163,8 +377,178 @@ type statByName struct {
 	SubStats     []*statByName
 	Requests     int
 	RecentReqs   []int
-	RequestStats *requestStats
+	RequestStats *RequestStats
 	Duration     time.Duration
+
+	// P50, P95 and P99 are latency percentiles of the requests or RPCs.
+	P50, P95, P99 time.Duration
+
+	// Errors is the number of failed Requests, for path totals.
+	Errors int
+
+	// Memcache totals the memcache lookups of the Requests, for path
+	// totals.
+	Memcache cacheStats
+
+	// Datastore totals the datastore operations of the RPCs.
+	Datastore datastoreOps
+
+	// Bytes is the size of the responses to the Requests, for path
+	// totals.
+	Bytes int64
+
+	// Goroutines is the total growth in goroutines during the
+	// Requests, for path totals.
+	Goroutines int
+
+	// Histogram is the latency histogram of the RPCs, for RPC totals.
+	Histogram []latencyBucket
+}
+
+// GoroutineGrowth returns the average growth in goroutines during the
+// Requests.
+func (s *statByName) GoroutineGrowth() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Goroutines) / float64(s.Requests)
+}
+
+// Leaking reports whether the Requests likely leak goroutines, as
+// their average growth reaches GoroutineLeakThreshold.
+func (s *statByName) Leaking() bool {
+	return s.GoroutineGrowth() >= GoroutineLeakThreshold
+}
+
+// TotalBytes returns Bytes for display.
+func (s *statByName) TotalBytes() byteSize {
+	return byteSize(s.Bytes)
+}
+
+// AvgBytes returns the average response size of the Requests.
+func (s *statByName) AvgBytes() byteSize {
+	if s.Requests == 0 {
+		return 0
+	}
+	return byteSize(s.Bytes) / byteSize(s.Requests)
+}
+
+// ErrorPercent returns the percentage of Requests that failed.
+func (s *statByName) ErrorPercent() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return 100 * float64(s.Errors) / float64(s.Requests)
+}
+
+// setPercentiles sets the latency percentiles of s from d, which is
+// sorted in place.
+func (s *statByName) setPercentiles(d []time.Duration) {
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	s.P50 = percentile(d, 50)
+	s.P95 = percentile(d, 95)
+	s.P99 = percentile(d, 99)
+}
+
+// latencyBounds are the upper bounds of the buckets of the latency
+// histograms shown on the dashboard. A last bucket holds longer
+// latencies.
+var latencyBounds = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// latencyBucket is a bucket of a latency histogram.
+type latencyBucket struct {
+	// Le is the upper bound of the bucket, or 0 for the last bucket.
+	Le    time.Duration
+	Count int
+	// Height is Count in percent of the largest bucket.
+	Height float64
+}
+
+// Label describes the latencies in b.
+func (b latencyBucket) Label() string {
+	if b.Le == 0 {
+		return "> " + latencyBounds[len(latencyBounds)-1].String()
+	}
+	return "≤ " + b.Le.String()
+}
+
+// latencyBucketOf returns the index of the latency bucket of d.
+func latencyBucketOf(d time.Duration) int {
+	return sort.Search(len(latencyBounds), func(i int) bool { return d <= latencyBounds[i] })
+}
+
+// latencyHistogram returns the histogram of d over latencyBounds.
+func latencyHistogram(d []time.Duration) []latencyBucket {
+	h := make([]latencyBucket, len(latencyBounds)+1)
+	for i, b := range latencyBounds {
+		h[i].Le = b
+	}
+	for _, v := range d {
+		h[latencyBucketOf(v)].Count++
+	}
+	max := 0
+	for _, b := range h {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	if max > 0 {
+		for i := range h {
+			h[i].Height = 100 * float64(h[i].Count) / float64(max)
+		}
+	}
+	return h
+}
+
+// percentile returns the nearest-rank pth percentile of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (p*len(sorted) + 99) / 100
+	if i < 1 {
+		i = 1
+	}
+	return sorted[i-1]
+}
+
+// slowRPC is an RPC listed among the slowest RPCs, along with the
+// request that made it.
+type slowRPC struct {
+	RPCStat
+	Index   int
+	Request *RequestStats
+}
+
+// slowRPCs holds the slowest RPCs seen, slowest first.
+type slowRPCs []slowRPC
+
+// add inserts r if it is among the n slowest RPCs seen so far.
+func (s slowRPCs) add(r slowRPC, n int) slowRPCs {
+	i := sort.Search(len(s), func(i int) bool { return s[i].Duration < r.Duration })
+	if i >= n {
+		return s
+	}
+	if len(s) < n {
+		s = append(s, slowRPC{})
+	}
+	copy(s[i+1:], s[i:])
+	s[i] = r
+	return s
 }
 
 type reverse struct{ sort.Interface }
@@ -176,6 +560,8 @@ type skey struct {
 }
 
 type cVal struct {
-	count int
-	cost  int64
+	count     int
+	cost      int64
+	ops       datastoreOps
+	durations []time.Duration
 }
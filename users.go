@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package appstats
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+)
+
+// userStat totals the requests of a user.
+type userStat struct {
+	User     string
+	Admin    bool
+	Requests int
+	Errors   int
+	RPCs     int
+	Cost     int64
+	Duration time.Duration
+	P95      time.Duration
+}
+
+// AvgDuration returns the average duration of the requests.
+func (u *userStat) AvgDuration() time.Duration {
+	return u.Duration / time.Duration(u.Requests)
+}
+
+// byUser totals ars by user, most expensive first. Requests without a
+// signed in user are totaled under the empty user.
+func byUser(ars allrequestStats) []*userStat {
+	users := make(map[string]*userStat)
+	durations := make(map[string][]time.Duration)
+	var stats []*userStat
+	for _, r := range ars {
+		u := users[r.User]
+		if u == nil {
+			u = &userStat{User: r.User}
+			users[r.User] = u
+			stats = append(stats, u)
+		}
+		u.Admin = u.Admin || r.Admin
+		u.Requests++
+		if r.Failed() {
+			u.Errors++
+		}
+		u.RPCs += len(r.RPCStats)
+		u.Cost += r.Cost
+		u.Duration += r.Duration
+		durations[r.User] = append(durations[r.User], r.Duration)
+	}
+	for _, u := range stats {
+		d := durations[u.User]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		u.P95 = percentile(d, 95)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Cost != stats[j].Cost {
+			return stats[i].Cost > stats[j].Cost
+		}
+		return stats[i].Requests > stats[j].Requests
+	})
+	return stats
+}
+
+func usersPage(c context.Context, w http.ResponseWriter, r *http.Request) {
+	ars, err := loadRequests(c, r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+
+	v := struct {
+		Env    map[string]string
+		Filter *filter
+		Users  []*userStat
+	}{
+		Env: map[string]string{
+			"APPLICATION_ID": appengine.AppID(c),
+		},
+		Filter: newFilter(r),
+		Users:  byUser(ars),
+	}
+
+	_ = templates.ExecuteTemplate(w, "users", v)
+}
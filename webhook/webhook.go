@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package webhook provides an appstats.RecordSink that reports recorded
+requests exceeding a duration or cost threshold, as a JSON summary
+posted to a URL or passed to a function. It can feed alerting pipelines
+without polling the dashboard.
+
+	appstats.Sink = &webhook.Exporter{
+		URL:         "https://alerts.example.com/appstats",
+		MinDuration: time.Second,
+	}
+*/
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mjibson/appstats"
+)
+
+// DefaultTimeout limits each post of a Summary to URL made with a Client
+// without a timeout.
+const DefaultTimeout = 10 * time.Second
+
+var _ appstats.RecordSink = (*Exporter)(nil)
+
+// Exporter is an appstats.RecordSink that reports slow or expensive
+// requests.
+type Exporter struct {
+	// URL receives a POST of the JSON Summary of each reported request.
+	URL string
+
+	// Func, if set, is called with the Summary of each reported request
+	// instead of posting it to URL.
+	Func func(*Summary)
+
+	// MinDuration and MinCost are the thresholds above which a request
+	// is reported. A request is reported if it exceeds either one; a
+	// zero threshold is ignored. If both are zero, all requests are
+	// reported.
+	MinDuration time.Duration
+	MinCost     int64
+
+	// Client is used to post summaries. If nil, http.DefaultClient is
+	// used. Posts time out after DefaultTimeout unless Client sets a
+	// timeout.
+	Client *http.Client
+
+	// OnError is called with post errors. The default logs them.
+	OnError func(error)
+}
+
+// Summary is the JSON summary of a recorded request.
+type Summary struct {
+	ID       int64         `json:"id"`
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Query    string        `json:"query,omitempty"`
+	Status   int           `json:"status"`
+	User     string        `json:"user,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration_ns"`
+	Cost     int64         `json:"cost"`
+	RPCs     int           `json:"rpcs"`
+	TraceID  string        `json:"trace_id,omitempty"`
+}
+
+// NewSummary returns the Summary of r.
+func NewSummary(r *appstats.RequestStats) *Summary {
+	return &Summary{
+		ID:       r.ID(),
+		Method:   r.Method,
+		Path:     r.Path,
+		Query:    r.Query,
+		Status:   r.Status,
+		User:     r.User,
+		Start:    r.Start,
+		Duration: r.Duration,
+		Cost:     r.Cost,
+		RPCs:     len(r.RPCStats),
+		TraceID:  r.TraceID,
+	}
+}
+
+// exceeds reports whether r is over either threshold of e.
+func (e *Exporter) exceeds(r *appstats.RequestStats) bool {
+	if e.MinDuration == 0 && e.MinCost == 0 {
+		return true
+	}
+	return (e.MinDuration > 0 && r.Duration >= e.MinDuration) ||
+		(e.MinCost > 0 && r.Cost >= e.MinCost)
+}
+
+// Emit implements appstats.RecordSink.
+func (e *Exporter) Emit(r *appstats.RequestStats) {
+	if !e.exceeds(r) {
+		return
+	}
+	s := NewSummary(r)
+	if e.Func != nil {
+		e.Func(s)
+		return
+	}
+	if err := e.post(s); err != nil {
+		if e.OnError != nil {
+			e.OnError(err)
+		} else {
+			log.Printf("webhook: %v", err)
+		}
+	}
+}
+
+func (e *Exporter) post(s *Summary) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	var client http.Client
+	if e.Client != nil {
+		client = *e.Client
+	}
+	if client.Timeout == 0 {
+		client.Timeout = DefaultTimeout
+	}
+	resp, err := client.Post(e.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return nil
+}
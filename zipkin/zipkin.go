@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2013 Matt Jibson <matt.jibson@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package zipkin provides an appstats.RecordSink that sends recorded
+requests to a Zipkin collector, using the JSON v2 API. Each request
+becomes a server span, with a client span for every RPC as its child.
+Requests with a W3C traceparent header join the trace of their caller.
+
+	appstats.Sink = zipkin.New("http://zipkin:9411/api/v2/spans", "my-app")
+*/
+package zipkin
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mjibson/appstats"
+)
+
+// DefaultTimeout is the timeout of posts to Endpoint when Client has
+// none.
+const DefaultTimeout = 10 * time.Second
+
+var _ appstats.RecordSink = (*Exporter)(nil)
+
+// Exporter is an appstats.RecordSink that posts spans to a Zipkin
+// collector.
+type Exporter struct {
+	// Endpoint is the URL of the collector's span API, usually ending
+	// in /api/v2/spans.
+	Endpoint string
+
+	// ServiceName is the name of the local service reported in spans.
+	ServiceName string
+
+	// Client is used to post spans. If nil, http.DefaultClient is used,
+	// with DefaultTimeout.
+	Client *http.Client
+
+	// OnError is called with post errors. The default logs them.
+	OnError func(error)
+}
+
+// New returns an Exporter posting to endpoint as serviceName.
+func New(endpoint, serviceName string) *Exporter {
+	return &Exporter{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+	}
+}
+
+type endpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+type span struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Kind          string            `json:"kind"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint endpoint          `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func micros(d time.Duration) int64 {
+	// Zipkin treats a zero duration as unknown.
+	if d < time.Microsecond {
+		return 1
+	}
+	return int64(d / time.Microsecond)
+}
+
+// spans converts r to Zipkin spans.
+func (e *Exporter) spans(r *appstats.RequestStats) []span {
+	local := endpoint{ServiceName: e.ServiceName}
+	root := span{
+		TraceID:       r.TraceID,
+		ID:            randomID(8),
+		ParentID:      r.SpanID,
+		Name:          r.Method + " " + r.Path,
+		Kind:          "SERVER",
+		Timestamp:     r.Start.UnixNano() / int64(time.Microsecond),
+		Duration:      micros(r.Duration),
+		LocalEndpoint: local,
+		Tags: map[string]string{
+			"http.method":      r.Method,
+			"http.path":        r.Path,
+			"http.status_code": strconv.Itoa(r.Status),
+			"appstats.cost":    strconv.FormatInt(r.Cost, 10),
+		},
+	}
+	if root.TraceID == "" {
+		root.TraceID = randomID(16)
+	}
+	spans := []span{root}
+	for _, s := range r.RPCStats {
+		spans = append(spans, span{
+			TraceID:       root.TraceID,
+			ID:            randomID(8),
+			ParentID:      root.ID,
+			Name:          s.Name(),
+			Kind:          "CLIENT",
+			Timestamp:     r.Start.Add(s.Offset).UnixNano() / int64(time.Microsecond),
+			Duration:      micros(s.Duration + s.ExtraDuration),
+			LocalEndpoint: local,
+			Tags: map[string]string{
+				"appstats.cost": strconv.FormatInt(s.Cost, 10),
+			},
+		})
+	}
+	return spans
+}
+
+// Emit implements appstats.RecordSink.
+func (e *Exporter) Emit(r *appstats.RequestStats) {
+	if err := e.post(r); err != nil {
+		if e.OnError != nil {
+			e.OnError(err)
+		} else {
+			log.Printf("zipkin: %v", err)
+		}
+	}
+}
+
+func (e *Exporter) post(r *appstats.RequestStats) error {
+	b, err := json.Marshal(e.spans(r))
+	if err != nil {
+		return err
+	}
+	var client http.Client
+	if e.Client != nil {
+		client = *e.Client
+	}
+	if client.Timeout == 0 {
+		client.Timeout = DefaultTimeout
+	}
+	resp, err := client.Post(e.Endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return nil
+}